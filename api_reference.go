@@ -0,0 +1,296 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// apiReferenceMaxEntries is the maximum number of identifiers of the API reference of a prompt.
+	apiReferenceMaxEntries = 60
+	// apiReferenceMaxDoc is the maximum length of the doc of an identifier in the API reference.
+	apiReferenceMaxDoc = 160
+)
+
+// apiEntry is an identifier of another package used by the code of a prompt.
+type apiEntry struct {
+	obj  types.Object
+	recv types.Type
+}
+
+// apiReference returns the signatures and the docs of the identifiers of other packages used by the
+// declarations of a file enclosing the given lines, or by all its functions when there are no lines.
+// The package is type-checked with its dependencies from the vendor directory or the module cache,
+// so that the model uses the real API instead of guessing it.
+func (j *job) apiReference(fileName string, lines []int) string {
+	if j.source == fileSourceStdin || !strings.HasSuffix(fileName, ".go") {
+		return ""
+	}
+
+	p, err := j.loadOpPackage(fileName, make(map[string][]byte))
+	if err != nil {
+		log.WithError(err).Warn(j.t("The API reference is not added to the prompt"))
+		return ""
+	}
+	file := p.files[filepath.Clean(fileName)]
+	if file == nil {
+		return ""
+	}
+
+	ownPath := p.pkg.Path()
+	if strings.HasSuffix(file.Name.Name, "_test") && j.isTestFile(fileName) {
+		ownPath += "_test"
+	}
+
+	var entries []apiEntry
+	seen := make(map[types.Object]int)
+	add := func(obj types.Object, recv types.Type) {
+		if obj == nil || obj.Pkg() == nil || obj.Pkg().Path() == ownPath {
+			return
+		}
+		switch obj.(type) {
+		case *types.PkgName, *types.Label:
+			return
+		}
+		if i, ok := seen[obj]; ok {
+			// A field first met as a key of a composite literal gets its type from a selector.
+			if entries[i].recv == nil {
+				entries[i].recv = recv
+			}
+			return
+		}
+		seen[obj] = len(entries)
+		entries = append(entries, apiEntry{obj: obj, recv: recv})
+	}
+
+	for _, decl := range file.Decls {
+		if !enclosesLines(p.fs, decl, lines) {
+			continue
+		}
+		ast.Inspect(decl, func(n ast.Node) bool {
+			switch x := n.(type) {
+			case *ast.SelectorExpr:
+				// The fields and the methods are listed with the type they are selected on.
+				if sel, ok := p.info.Selections[x]; ok {
+					add(sel.Obj(), sel.Recv())
+				}
+			case *ast.Ident:
+				add(p.info.Uses[x], nil)
+			}
+			return true
+		})
+	}
+	if len(entries) == 0 {
+		return ""
+	}
+
+	sort.SliceStable(entries, func(a, b int) bool {
+		return entries[a].obj.Pkg().Path() < entries[b].obj.Pkg().Path()
+	})
+	total := len(entries)
+	if total > apiReferenceMaxEntries {
+		entries = entries[:apiReferenceMaxEntries]
+	}
+
+	qualifier := func(pkg *types.Package) string {
+		if pkg.Path() == ownPath {
+			return ""
+		}
+		return pkg.Name()
+	}
+
+	docs := apiDocs{fs: p.fs, files: make(map[string]*ast.File)}
+	var builder strings.Builder
+	builder.WriteString(j.t("API reference of the identifiers of other packages currently used by this code"))
+	if total > len(entries) {
+		builder.WriteString(" (" + fmt.Sprintf(j.t("only the first %d of the %d identifiers are listed"), len(entries), total) + ")")
+	}
+	builder.WriteString(":\n\n```go\n")
+	pkgPath := ""
+	for _, entry := range entries {
+		if path := entry.obj.Pkg().Path(); path != pkgPath {
+			if pkgPath != "" {
+				builder.WriteString("\n")
+			}
+			builder.WriteString("// package " + path + "\n")
+			pkgPath = path
+		}
+		builder.WriteString(apiSignature(entry, qualifier) + "\n")
+		if doc := docs.lookup(entry.obj); doc != "" {
+			builder.WriteString("\t// " + doc + "\n")
+		}
+	}
+	builder.WriteString("```")
+	return builder.String()
+}
+
+// enclosesLines checks whether a declaration encloses one of the lines, or is a function when there are none.
+func enclosesLines(fs *token.FileSet, decl ast.Decl, lines []int) bool {
+	if len(lines) == 0 {
+		_, ok := decl.(*ast.FuncDecl)
+		return ok
+	}
+	start, end := fs.Position(decl.Pos()).Line, fs.Position(decl.End()).Line
+	for _, line := range lines {
+		if line >= start && line <= end {
+			return true
+		}
+	}
+	return false
+}
+
+// apiSignature returns the declaration of an identifier on one line, without the fields and the methods
+// of the structs and the interfaces.
+func apiSignature(entry apiEntry, qualifier types.Qualifier) string {
+	switch obj := entry.obj.(type) {
+	case *types.TypeName:
+		name := qualifier(obj.Pkg()) + "." + obj.Name()
+		if named, ok := obj.Type().(*types.Named); ok && named.TypeParams().Len() > 0 {
+			var params []string
+			for i := 0; i < named.TypeParams().Len(); i++ {
+				param := named.TypeParams().At(i)
+				params = append(params, param.Obj().Name()+" "+types.TypeString(param.Constraint(), qualifier))
+			}
+			name += "[" + strings.Join(params, ", ") + "]"
+		}
+		switch obj.Type().Underlying().(type) {
+		case *types.Struct:
+			return "type " + name + " struct{ ... }"
+		case *types.Interface:
+			return "type " + name + " interface{ ... }"
+		}
+		return "type " + name + " " + types.TypeString(obj.Type().Underlying(), qualifier)
+
+	case *types.Var:
+		if obj.IsField() {
+			owner := ""
+			if entry.recv != nil {
+				owner = "(" + types.TypeString(entry.recv, qualifier) + ")."
+			}
+			return "field " + owner + obj.Name() + " " + types.TypeString(obj.Type(), qualifier)
+		}
+	}
+	return types.ObjectString(entry.obj, qualifier)
+}
+
+// apiDocs finds the doc comments of the identifiers in the sources of their packages.
+type apiDocs struct {
+	fs    *token.FileSet
+	files map[string]*ast.File
+}
+
+// lookup returns the first sentence of the doc of an identifier, or an empty string when it has none.
+func (d *apiDocs) lookup(obj types.Object) string {
+	pos := d.fs.Position(obj.Pos())
+	if !pos.IsValid() || pos.Filename == "" {
+		return ""
+	}
+
+	file, ok := d.files[pos.Filename]
+	if !ok {
+		// The files are parsed again in their own file set, the identifiers are found by offset.
+		file, _ = parser.ParseFile(token.NewFileSet(), pos.Filename, nil, parser.ParseComments)
+		d.files[pos.Filename] = file
+	}
+	if file == nil {
+		return ""
+	}
+
+	var doc *ast.CommentGroup
+	found := false
+	matches := func(idents ...*ast.Ident) bool {
+		for _, ident := range idents {
+			if int(ident.Pos()-file.FileStart) == pos.Offset {
+				return true
+			}
+		}
+		return false
+	}
+	ast.Inspect(file, func(n ast.Node) bool {
+		if found || n == nil {
+			return false
+		}
+		switch x := n.(type) {
+		case *ast.FuncDecl:
+			if matches(x.Name) {
+				doc, found = x.Doc, true
+			}
+		case *ast.GenDecl:
+			for _, spec := range x.Specs {
+				var specDoc *ast.CommentGroup
+				switch s := spec.(type) {
+				case *ast.TypeSpec:
+					if matches(s.Name) {
+						specDoc, found = s.Doc, true
+					}
+				case *ast.ValueSpec:
+					if matches(s.Names...) {
+						specDoc, found = s.Doc, true
+					}
+				}
+				if found {
+					doc = specDoc
+					if doc == nil && len(x.Specs) == 1 {
+						doc = x.Doc
+					}
+					return false
+				}
+			}
+		case *ast.Field:
+			if matches(x.Names...) {
+				doc, found = x.Doc, true
+				if doc == nil {
+					doc = x.Comment
+				}
+			}
+		}
+		return !found
+	})
+
+	return firstSentence(doc.Text(), apiReferenceMaxDoc)
+}
+
+// firstSentence returns the first sentence of a doc comment on one line, shortened to maxLen characters.
+func firstSentence(doc string, maxLen int) string {
+	doc = strings.Join(strings.Fields(doc), " ")
+	if i := strings.Index(doc, ". "); i >= 0 {
+		doc = doc[:i+1]
+	}
+	if runes := []rune(doc); len(runes) > maxLen {
+		doc = string(runes[:maxLen-3]) + "..."
+	}
+	return doc
+}
+
+// apiReferenceForDiagnostics returns the API reference of the declarations enclosing the diagnostics,
+// by file.
+func (j *job) apiReferenceForDiagnostics(diagnostics []diagnostic) string {
+	var order []string
+	lines := make(map[string][]int)
+	for _, d := range diagnostics {
+		fileName, err := filepath.Rel(j.fileDir, j.diagnosticFilePath(d.File))
+		if err != nil {
+			continue
+		}
+		if _, ok := lines[fileName]; !ok {
+			order = append(order, fileName)
+		}
+		lines[fileName] = append(lines[fileName], d.Line)
+	}
+
+	var refs []string
+	for _, fileName := range order {
+		if ref := j.apiReference(fileName, lines[fileName]); ref != "" {
+			refs = append(refs, fmt.Sprintf("// %s\n%s", fileName, ref))
+		}
+	}
+	return strings.Join(refs, "\n\n")
+}
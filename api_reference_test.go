@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"strings"
+	"testing"
+)
+
+func TestFirstSentence(t *testing.T) {
+	tests := []struct {
+		name   string
+		doc    string
+		maxLen int
+		want   string
+	}{
+		{"empty", "", 20, ""},
+		{"one sentence", "Run runs the job.\n", 40, "Run runs the job."},
+		{"several sentences", "Run runs the job. It returns\nan error.\n", 40, "Run runs the job."},
+		{"several lines", "Run runs the job\nof the user.", 40, "Run runs the job of the user."},
+		{"dot without space", "Parse reads a go.mod file", 40, "Parse reads a go.mod file"},
+		{"too long", "Run runs the job of the user", 10, "Run run..."},
+		{"unicode", "Écrit les données à la fin", 10, "Écrit l..."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := firstSentence(tt.doc, tt.maxLen); got != tt.want {
+				t.Errorf("firstSentence() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAPISignature(t *testing.T) {
+	const src = `package b
+
+type Pair[K comparable, V any] struct {
+	Key   K
+	Value V
+}
+
+type Sizer interface{ Size() int }
+
+type Celsius float64
+
+func (c Celsius) String() string { return "" }
+
+func New[K comparable, V any](k K, v V) Pair[K, V] { return Pair[K, V]{k, v} }
+
+var Default = Pair[string, int]{}
+
+const Max = 3
+`
+	fs := token.NewFileSet()
+	file, err := parser.ParseFile(fs, "b.go", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkg, err := (&types.Config{}).Check("example.com/m/b", fs, []*ast.File{file}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	qualifier := func(p *types.Package) string { return p.Name() }
+
+	scope := pkg.Scope()
+	pair := scope.Lookup("Pair").Type()
+	celsius := scope.Lookup("Celsius").Type()
+	stringIntPair, err := types.Instantiate(nil, pair, []types.Type{types.Typ[types.String], types.Typ[types.Int]}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, _, _ := types.LookupFieldOrMethod(stringIntPair, false, pkg, "Key")
+	method, _, _ := types.LookupFieldOrMethod(celsius, false, pkg, "String")
+
+	tests := []struct {
+		name  string
+		entry apiEntry
+		want  string
+	}{
+		{"generic struct", apiEntry{obj: scope.Lookup("Pair")}, "type b.Pair[K comparable, V any] struct{ ... }"},
+		{"interface", apiEntry{obj: scope.Lookup("Sizer")}, "type b.Sizer interface{ ... }"},
+		{"defined type", apiEntry{obj: scope.Lookup("Celsius")}, "type b.Celsius float64"},
+		{"method", apiEntry{obj: method}, "func (b.Celsius).String() string"},
+		{"generic function", apiEntry{obj: scope.Lookup("New")}, "func b.New[K comparable, V any](k K, v V) b.Pair[K, V]"},
+		{"variable", apiEntry{obj: scope.Lookup("Default")}, "var b.Default b.Pair[string, int]"},
+		{"constant", apiEntry{obj: scope.Lookup("Max")}, "const b.Max untyped int"},
+		{"field with its type", apiEntry{obj: key, recv: stringIntPair}, "field (b.Pair[string, int]).Key string"},
+		{"field without its type", apiEntry{obj: key}, "field Key string"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := apiSignature(tt.entry, qualifier); got != tt.want {
+				t.Errorf("apiSignature() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAPIReference(t *testing.T) {
+	var many, uses strings.Builder
+	for i := 0; i <= apiReferenceMaxEntries; i++ {
+		fmt.Fprintf(&many, "func F%02d() {}\n", i)
+		fmt.Fprintf(&uses, "\tc.F%02d()\n", i)
+	}
+
+	dir := writeModule(t, map[string]string{
+		"go.mod": "module example.com/m\n\ngo 1.22\n",
+		"b/b.go": "package b\n\n// Pair holds two values. It is generic.\ntype Pair[K comparable, V any] struct {\n\t// Key is the key.\n\tKey   K\n\tValue V\n}\n\n" +
+			"// New returns a pair.\nfunc New[K comparable, V any](k K, v V) Pair[K, V] { return Pair[K, V]{k, v} }\n",
+		"c/c.go": "package c\n\n" + many.String(),
+		"a/a.go": "package a\n\nimport (\n\t\"strings\"\n\n\t\"example.com/m/b\"\n)\n\n" +
+			"func A() string {\n\tp := b.New(\"x\", 1)\n\treturn strings.ToUpper(p.Key)\n}\n\nfunc Local() int { return 1 }\n",
+		"a/many.go": "package a\n\nimport \"example.com/m/c\"\n\nfunc Many() {\n" + uses.String() + "}\n",
+	})
+
+	tests := []struct {
+		name     string
+		fileName string
+		lines    []int
+		want     []string
+		wantNot  []string
+	}{
+		{
+			name:     "all the functions",
+			fileName: "a/a.go",
+			want: []string{
+				"API reference of the identifiers of other packages currently used by this code:\n\n```go\n",
+				"// package example.com/m/b\nfunc b.New[K comparable, V any](k K, v V) b.Pair[K, V]\n\t// New returns a pair.\n",
+				"field (b.Pair[string, int]).Key string\n\t// Key is the key.\n",
+				"// package strings\nfunc strings.ToUpper(s string) string\n",
+			},
+			wantNot: []string{"only the first", "Local"},
+		},
+		{
+			name:     "declaration without other package",
+			fileName: "a/a.go",
+			lines:    []int{15},
+		},
+		{
+			name:     "truncated list",
+			fileName: "a/many.go",
+			want: []string{
+				fmt.Sprintf("currently used by this code (only the first %d of the %d identifiers are listed):",
+					apiReferenceMaxEntries, apiReferenceMaxEntries+1),
+				fmt.Sprintf("func c.F%02d()\n", apiReferenceMaxEntries-1),
+			},
+			wantNot: []string{fmt.Sprintf("func c.F%02d()\n", apiReferenceMaxEntries)},
+		},
+		{
+			name:     "not a Go file",
+			fileName: "go.mod",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			j := &job{fileDir: dir, source: fileSourceFilePath, modulePath: "example.com/m"}
+			got := j.apiReference(tt.fileName, tt.lines)
+			if len(tt.want) == 0 && got != "" {
+				t.Errorf("apiReference() = %q, want an empty string", got)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(got, want) {
+					t.Errorf("apiReference() = %q, want it to contain %q", got, want)
+				}
+			}
+			for _, wantNot := range tt.wantNot {
+				if strings.Contains(got, wantNot) {
+					t.Errorf("apiReference() = %q, want it not to contain %q", got, wantNot)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// goldenUpdateEnv is the environment variable asking the characterization tests to write their golden files.
+const goldenUpdateEnv = "GOIA_UPDATE_GOLDEN"
+
+// isRewriteStep checks whether the step rewrites existing code without changing its behavior.
+func isRewriteStep(s step) bool {
+	return s == stepOptimize || s == stepRefactor
+}
+
+// testedNames returns the identifiers used by the test files of the folder of a file.
+func (j *job) testedNames(fileName string) (map[string]bool, error) {
+	matches, err := filepath.Glob(filepath.Join(j.fileDir, filepath.Dir(fileName), "*_test.go"))
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]bool)
+	for _, match := range matches {
+		node, err := parser.ParseFile(token.NewFileSet(), match, nil, 0)
+		if err != nil {
+			continue
+		}
+
+		ast.Inspect(node, func(n ast.Node) bool {
+			if ident, ok := n.(*ast.Ident); ok {
+				names[ident.Name] = true
+			}
+			return true
+		})
+	}
+	return names, nil
+}
+
+// untestedFunctions returns the code of the functions and methods of a file that no test references.
+func (j *job) untestedFunctions(fileName string) (map[string]string, error) {
+	tested, err := j.testedNames(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	fs := token.NewFileSet()
+	path := filepath.Join(j.fileDir, fileName)
+	node, err := parser.ParseFile(fs, path, nil, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+
+	untested := make(map[string]string)
+	for _, decl := range node.Decls {
+		funcDecl, ok := decl.(*ast.FuncDecl)
+		if !ok || funcDecl.Body == nil || tested[funcDecl.Name.Name] {
+			continue
+		}
+		if funcDecl.Recv == nil && (funcDecl.Name.Name == "main" || funcDecl.Name.Name == "init") {
+			continue
+		}
+
+		name, code, err := j.extractDeclarationFromLine(path, fs.Position(funcDecl.Pos()).Line)
+		if err != nil {
+			return nil, err
+		}
+		untested[name] = code
+	}
+
+	return untested, nil
+}
+
+// getPromptToAskCharacterizationTests returns a prompt asking for tests recording the current behavior of functions.
+func (j *job) getPromptToAskCharacterizationTests(untested map[string]string) string {
+	var names []string
+	for name := range untested {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var code strings.Builder
+	for _, name := range names {
+		code.WriteString(untested[name] + "\n\n")
+	}
+
+	return j.t("The following functions have no tests and are going to be rewritten") + ":\n\n" + code.String() +
+		fmt.Sprintf(j.t("Write characterization tests named TestCharacterization<Function> in the test file %s"), j.currentTestFileName) + ". " +
+		j.t("They must record the current behavior, even if it looks wrong: call each function with a varied set of generated inputs, and compare each result with a golden file under testdata/golden/<TestName>/<case>.golden") + ". " +
+		fmt.Sprintf(j.t("When the environment variable %s is set, the tests write the golden files instead of comparing them"), goldenUpdateEnv) + ". " +
+		j.t("The results must be deterministic") + ".\n\n" +
+		j.t("Strictly use the following format for each part") + ": `**<folder/file.go>** <code ici>`.\n\n" +
+		j.t("Reply without comment or explanation")
+}
+
+// characterizationPackage returns the package pattern of the current source file.
+func (j *job) characterizationPackage() string {
+	return "./" + filepath.ToSlash(filepath.Dir(j.currentSourceFileName))
+}
+
+// recordGoldenFiles runs the characterization tests twice, writing then comparing the golden files,
+// and returns a repair prompt if they do not pass against the original code.
+func (j *job) recordGoldenFiles(tests []string) (string, error) {
+	if prompt, err := j.compileTestsPrompt(); err != nil || prompt != "" {
+		return prompt, err
+	}
+
+	run := failedTestsRunPattern(tests)
+	if _, err := j.runCommand(nil, []string{goldenUpdateEnv + "=1"}, "go", "test", "-count=1", "-run", run, j.characterizationPackage()); err != nil {
+		log.WithError(err).Warn(j.t("Error writing the golden files"))
+	}
+	// In a sandbox, the golden files are written in the working copy.
+	if err := j.copyFromSandbox(filepath.Join(filepath.Dir(j.currentSourceFileName), "testdata", "golden")); err != nil {
+		return "", err
+	}
+
+	result, err := j.runCommand(nil, nil, "go", "test", "-count=1", "-run", run, j.characterizationPackage())
+	if err == nil {
+		return "", nil
+	}
+	if result == nil {
+		return "", err
+	}
+
+	testCode, err := j.getTestCode(tests)
+	if err != nil {
+		return "", err
+	}
+
+	return j.t("The following characterization tests do not pass against the original code, so they do not record its current behavior") + ":\n\n" +
+		testCode + "\n\n" +
+		j.t("Error") + " : " + result.Output() + "\n\n" +
+		fmt.Sprintf(j.t("Fix the tests in the test file %s, without modifying the source file"), j.currentTestFileName) + ".\n\n" +
+		j.t("Strictly use the following format for each part") + ": `**<folder/file.go>** <code ici>`.\n\n" +
+		j.t("Reply without comment or explanation"), nil
+}
+
+// ensureCharacterizationTests generates characterization tests for the untested functions of the
+// current source file before it is rewritten, and records their golden files from the original code.
+func (j *job) ensureCharacterizationTests() error {
+	sourceFileName := j.currentSourceFileName
+	testFileName := j.currentTestFileName
+
+	untested, err := j.untestedFunctions(sourceFileName)
+	if err != nil {
+		return err
+	}
+	if len(untested) == 0 {
+		return j.recordRewriteBaseline()
+	}
+
+	log.Infof(j.t("Generating characterization tests for %d untested functions"), len(untested))
+
+	existingTests := j.testFunctions(testFileName)
+
+	// The original code is the reference: only the tests may change.
+	j.frozenFiles = map[string]bool{sourceFileName: true}
+	defer func() {
+		j.frozenFiles = nil
+		j.currentSourceFileName = sourceFileName
+		j.currentTestFileName = testFileName
+	}()
+
+	var tests []string
+	check := func() (string, error) {
+		tests = nil
+		for name := range j.testFunctions(testFileName) {
+			if !existingTests[name] {
+				tests = append(tests, name)
+			}
+		}
+		sort.Strings(tests)
+
+		if len(tests) == 0 {
+			return j.getPromptToAskCharacterizationTests(untested), nil
+		}
+		return j.recordGoldenFiles(tests)
+	}
+
+	if err := j.repairLoop(j.getPromptToAskCharacterizationTests(untested), check); err != nil {
+		return err
+	}
+
+	j.characterizationTests = tests
+	log.Infof(j.t("Characterization tests recorded")+": %s", strings.Join(tests, ", "))
+	return j.recordRewriteBaseline()
+}
+
+// recordRewriteBaseline runs the tests of the package against the original code, so that the
+// characterization gate only reports the tests broken by the rewrite.
+func (j *job) recordRewriteBaseline() error {
+	baseline := make(map[string]bool)
+	result, err := j.runCommand(nil, nil, "go", "test", "-count=1", j.characterizationPackage())
+	if err != nil && result == nil {
+		return err
+	}
+	if err != nil {
+		failedTests, err := j.getFailedTests(result.Output())
+		if err != nil {
+			return err
+		}
+		for _, name := range failedTests {
+			baseline[name] = true
+		}
+	}
+
+	j.rewriteBaseline = baseline
+	return nil
+}
+
+// characterizationGate runs the existing and characterization tests of the package against the
+// rewritten code and returns a repair prompt if the rewrite broke some of them.
+func (j *job) characterizationGate() (string, string, error) {
+	result, err := j.runCommand(nil, nil, "go", "test", "-count=1", j.characterizationPackage())
+	if err == nil {
+		return "", "", nil
+	}
+	if result == nil {
+		return "", "", err
+	}
+
+	failedTests, err := j.getFailedTests(result.Output())
+	if err != nil {
+		return "", "", err
+	}
+	var brokenTests []string
+	for _, name := range failedTests {
+		if !j.rewriteBaseline[name] {
+			brokenTests = append(brokenTests, name)
+		}
+	}
+	// Tests already failing before the rewrite are not its fault.
+	if len(failedTests) > 0 && len(brokenTests) == 0 {
+		return "", "", nil
+	}
+	sort.Strings(brokenTests)
+
+	// Without failed tests, the package does not build anymore.
+	var funcCode string
+	if len(brokenTests) == 0 {
+		funcCode, err = j.extractErrorForPrompt(result.Output())
+		if err != nil {
+			return "", "", err
+		}
+	} else if _, err := os.Stat(filepath.Join(j.fileDir, j.currentTestFileName)); err == nil {
+		if testCode, err := j.getTestCode(brokenTests); err == nil {
+			funcCode = testCode
+		}
+	}
+
+	prompt := j.t("The rewritten code broke existing or characterization tests of the package") + ":\n\n" + funcCode + "\n\n" +
+		j.t("Error") + " : " + result.Output() + "\n\n" +
+		j.t("Keep the rewrite but restore the original behavior, without modifying the tests or the golden files") + ".\n\n" +
+		j.t("responds without adding comments or explanations") + "\n\n" +
+		j.t("Generates a concise response that specifies the file to modify in the form: \"MODIFY: <function or section name> (source file, not test file)\"") + "." +
+		j.t("Then provide the corrected code in the form: \"CODE: <corrected code>\"") + "."
+
+	return prompt, result.Output(), nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestUntestedFunctions(t *testing.T) {
+	dir := writeModule(t, map[string]string{
+		"p/p.go": `package p
+
+func main() {}
+
+func init() {}
+
+// Tested is called by the tests.
+func Tested() int { return 1 }
+
+func untested(n int) int {
+	return n * 2
+}
+
+type T struct{}
+
+func (T) Method() {}
+`,
+		"p/p_test.go": "package p\n\nimport \"testing\"\n\nfunc TestTested(t *testing.T) { _ = Tested() }\n",
+	})
+	j := &job{fileDir: dir}
+
+	untested, err := j.untestedFunctions("p/p.go")
+	if err != nil {
+		t.Fatalf("untestedFunctions() error = %v", err)
+	}
+
+	var names []string
+	for name := range untested {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if want := []string{"(T) Method", "untested"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("untestedFunctions() = %v, want %v", names, want)
+	}
+}
+
+func TestCharacterizationGate(t *testing.T) {
+	const testCode = `package p
+
+import "testing"
+
+func TestDouble(t *testing.T) {
+	if got := Double(2); got != 4 {
+		t.Errorf("Double(2) = %d, want 4", got)
+	}
+}
+
+func TestBroken(t *testing.T) {
+	t.Fatal("broken before the rewrite")
+}
+`
+
+	tests := []struct {
+		name       string
+		rewritten  string
+		wantPrompt bool
+		wantTest   string
+	}{
+		{"behavior kept", "package p\n\nfunc Double(n int) int { return n + n }\n", false, ""},
+		{"existing test broken", "package p\n\nfunc Double(n int) int { return n * 3 }\n", true, "func TestDouble"},
+		{"build error", "package p\n\nfunc Double(n int) int { return m * 2 }\n", true, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := writeModule(t, map[string]string{
+				"go.mod":      "module example.com/m\n\ngo 1.22\n",
+				"p/p.go":      "package p\n\nfunc Double(n int) int { return n * 2 }\n",
+				"p/p_test.go": testCode,
+			})
+			j := &job{fileDir: dir, source: fileSourceFilePath, currentSourceFileName: "p/p.go", currentTestFileName: "p/p_test.go"}
+
+			if err := j.recordRewriteBaseline(); err != nil {
+				t.Fatalf("recordRewriteBaseline() error = %v", err)
+			}
+			if want := map[string]bool{"TestBroken": true}; !reflect.DeepEqual(j.rewriteBaseline, want) {
+				t.Errorf("recordRewriteBaseline() baseline = %v, want %v", j.rewriteBaseline, want)
+			}
+
+			if err := os.WriteFile(filepath.Join(dir, "p/p.go"), []byte(tt.rewritten), 0644); err != nil {
+				t.Fatal(err)
+			}
+			prompt, _, err := j.characterizationGate()
+			if err != nil {
+				t.Fatalf("characterizationGate() error = %v", err)
+			}
+			if (prompt != "") != tt.wantPrompt {
+				t.Errorf("characterizationGate() prompt = %q, want a prompt %v", prompt, tt.wantPrompt)
+			}
+			if !strings.Contains(prompt, tt.wantTest) {
+				t.Errorf("characterizationGate() prompt = %q, want it to contain %q", prompt, tt.wantTest)
+			}
+			if strings.Contains(prompt, "func TestBroken") {
+				t.Errorf("characterizationGate() prompt = %q, want it without the test failing before the rewrite", prompt)
+			}
+		})
+	}
+}
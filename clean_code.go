@@ -59,13 +59,21 @@ func (j *job) commentUnusedFunctions(funcs []string) error {
 	}
 
 	// Reconstruire le fichier avec les lignes mises à jour
-	return os.WriteFile(j.fileDir+"/"+j.currentFileName, bytes.Join(updatedLines, []byte("\n")), 0644)
+	if err := os.WriteFile(j.fileDir+"/"+j.currentFileName, bytes.Join(updatedLines, []byte("\n")), 0644); err != nil {
+		return err
+	}
+	return j.reloadFile(j.currentFileName)
 }
 
 // fixImports exécute goimports pour corriger les importations dans le fichier.
 func (j *job) fixImports() error {
 	// Commande pour exécuter goimports
-	cmd := exec.Command("goimports", "-w", j.currentFileName)
+	args := []string{"-w", j.currentFileName}
+	if j.local != "" {
+		// Les imports du module local sont séparés des packages tiers.
+		args = append([]string{"-local", j.local}, args...)
+	}
+	cmd := exec.Command("goimports", args...)
 	cmd.Dir = j.fileDir
 
 	// Exécution de la commande
@@ -77,7 +85,8 @@ func (j *job) fixImports() error {
 		return fmt.Errorf(j.t("error running goimports")+": %v - %s", err, out.String())
 	}
 
-	return nil
+	// Le fichier réécrit par goimports devient la base de la fusion.
+	return j.reloadFile(j.currentFileName)
 }
 
 // findUnusedFunctions utilise staticcheck pour trouver les fonctions non utilisées dans le fichier.
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// defaultMaxAttempts is the number of repair attempts of the commands when none is configured.
+const defaultMaxAttempts = 3
+
+// command is a mode run by `goia [flags] <command> [arguments]`.
+type command func(args *appArgs, cmdArgs []string) error
+
+// findModuleRoot returns the folder containing the go.mod file of the given folder.
+func findModuleRoot(dir string) (string, error) {
+	current, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+
+	for {
+		if _, err := os.Stat(filepath.Join(current, "go.mod")); err == nil {
+			return current, nil
+		}
+
+		parent := filepath.Dir(current)
+		if parent == current {
+			return "", fmt.Errorf("no go.mod file found in or above %s", dir)
+		}
+		current = parent
+	}
+}
+
+// newCommandJob creates a job working on the module containing the given path.
+// The commands always write their changes to the files.
+func newCommandJob(args *appArgs, path string) (*job, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(absPath)
+	if err != nil {
+		return nil, fmt.Errorf("error accessing path %s : %w", path, err)
+	}
+
+	dir := absPath
+	if !info.IsDir() {
+		dir = filepath.Dir(absPath)
+	}
+
+	root, err := findModuleRoot(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	args.write = true
+
+	j, err := newJob(NewConfigCache(args.local, args.prefixes), root, args)
+	if err != nil {
+		return nil, err
+	}
+
+	j.source = fileSourceFilePath
+	if !info.IsDir() {
+		if j.fileName, err = filepath.Rel(root, absPath); err != nil {
+			return nil, err
+		}
+		j.currentFileName = j.fileName
+		j.currentSourceFileName = j.fileName
+	}
+
+	if err := j.updateCache(); err != nil {
+		return nil, err
+	}
+
+	if j.maxAttempts <= 0 {
+		j.maxAttempts = defaultMaxAttempts
+	}
+
+	if err := j.getModulePath(); err != nil {
+		log.WithError(err).Warn(j.t("error getting module path"))
+	}
+
+	return j, nil
+}
+
+// loadCurrentFiles reads the current source and test files from the disk.
+func (j *job) loadCurrentFiles() error {
+	for _, file := range []struct {
+		name string
+		dst  *[]byte
+	}{
+		{name: j.currentSourceFileName, dst: &j.currentSrcSource},
+		{name: j.currentTestFileName, dst: &j.currentSrcTest},
+	} {
+		if file.name == "" {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(j.fileDir, file.name))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		*file.dst = data
+		j.recordBase(file.name, data)
+	}
+	return nil
+}
+
+// packageNameForFile returns the package name used by the Go files of the folder of the given file.
+func (j *job) packageNameForFile(fileName string) string {
+	path := filepath.Join(j.fileDir, fileName)
+	matches, _ := filepath.Glob(filepath.Join(filepath.Dir(path), "*.go"))
+
+	for _, match := range matches {
+		if match == path {
+			continue
+		}
+
+		node, err := parser.ParseFile(token.NewFileSet(), match, nil, parser.PackageClauseOnly)
+		if err != nil {
+			continue
+		}
+
+		name := node.Name.Name
+		// External test packages use the name of the package they test.
+		if j.isTestFile(match) && !j.isTestFile(fileName) {
+			name = strings.TrimSuffix(name, "_test")
+		}
+		return name
+	}
+
+	return sanitizePackageName(path)
+}
+
+// createGoFile creates a Go file with the package clause of its folder.
+func (j *job) createGoFile(fileName string) error {
+	path := filepath.Join(j.fileDir, fileName)
+	if err := j.createFolders(path); err != nil {
+		return err
+	}
+
+	content := fmt.Sprintf("package %s\n\n", j.packageNameForFile(fileName))
+	return os.WriteFile(path, []byte(content), 0o644)
+}
+
+// applyResponse writes the code of a model response in the files it designates.
+// The response either uses the `**<folder/file.go>**` blocks or the `MODIFY: ... CODE: ...` form.
+func (j *job) applyResponse(response string) error {
+	filesAndCode := j.splitFilesAndCode(response)
+	if len(filesAndCode) == 0 {
+		fileToModify, code := j.splitFileNameAndCode(response)
+		filesAndCode = map[string]string{fileToModify: code}
+	}
+
+	for file, code := range filesAndCode {
+		file = strings.TrimPrefix(filepath.Clean(file), "/")
+		if j.frozenFiles[file] {
+			log.Warnf(j.t("The test file %s is frozen, its changes are ignored"), file)
+			continue
+		}
+
+		if j.isTestFile(file) {
+			j.currentTestFileName = file
+		} else {
+			j.currentSourceFileName = file
+		}
+
+		if err := j.loadCurrentFiles(); err != nil {
+			return err
+		}
+
+		if _, err := os.Stat(filepath.Join(j.fileDir, file)); errors.Is(err, os.ErrNotExist) {
+			if err := j.createGoFile(file); err != nil {
+				return err
+			}
+			if err := j.loadCurrentFiles(); err != nil {
+				return err
+			}
+		}
+
+		log.Infof(j.t("file to modify") + ": " + green(file) + "\n\n")
+		if err := j.fixCodeAndWriteFile(file, code); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// repairLoop sends the prompt to the model and applies its response until check returns
+// an empty prompt or the attempts are exhausted. check returns the next prompt to send.
+func (j *job) repairLoop(prompt string, check func() (string, error)) error {
+	for attempt := 1; attempt <= j.maxAttempts; attempt++ {
+		log.Println("attempt:", attempt)
+		log.Infof("\nprompt: "+blue("%s")+"\n\n", prompt)
+
+		response, err := j.callIA(prompt)
+		if err != nil {
+			log.WithError(err).Error(j.t("Error generating code"))
+			return err
+		}
+
+		log.Infof("API response:\n\n"+green("\"%s\"")+"\n\n", response)
+
+		if err := j.applyResponse(response); err != nil {
+			log.WithError(err).Error(j.t("Error fixing code and writing file"))
+			return err
+		}
+
+		if prompt, err = check(); err != nil {
+			return err
+		}
+		if prompt == "" {
+			return nil
+		}
+	}
+
+	return fmt.Errorf(j.t("the code still fails after %d attempts"), j.maxAttempts)
+}
+
+// compileTestsPrompt compiles the tests of the changed packages and returns a repair prompt on failure.
+func (j *job) compileTestsPrompt() (string, error) {
+	patterns, err := j.packagesToBuild()
+	if err != nil || len(patterns) == 0 {
+		patterns = []string{"./..."}
+	}
+
+	result, err := j.runCommand(nil, nil, "go", append([]string{"test", "-count=1", "-run", "^$"}, patterns...)...)
+	if err == nil {
+		return "", nil
+	}
+	if result == nil {
+		return "", err
+	}
+
+	funcCode, err := j.extractErrorForPrompt(result.Output())
+	if err != nil {
+		return "", err
+	}
+
+	return j.t("Fix the following code that generated an error") + ":\n\n" + funcCode + "\n\n" +
+		j.t("Error") + " : " + result.Output() + "\n\n" +
+		j.t("responds without adding comments or explanations") + "\n\n" +
+		j.declOpsPrompt() + ".\n\n" +
+		j.t("Strictly use the following format for each part") + ": `**<folder/file.go>** <code ici>`.", nil
+}
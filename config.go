@@ -3,6 +3,7 @@ package main
 import (
 	"bufio"
 	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
 	"regexp"
@@ -37,6 +38,29 @@ type Config struct {
 	OpenAIURL         string   `yaml:"openai_url"`
 	OpenAITags        []string `yaml:"openai_tags"`
 	ValidateEachStep  bool     `yaml:"validate_each_step"`
+
+	// ResponseFormat is the form of the changes asked to the model: "code" for whole declarations
+	// (the default) or "diff" for unified diffs and search/replace blocks, cheaper on large files.
+	ResponseFormat string `yaml:"response_format"`
+
+	// RewriteSteps are the steps rewriting the existing code after the start step, in order:
+	// "optimize" and "refactor". Untested functions get characterization tests first.
+	RewriteSteps []string `yaml:"rewrite_steps"`
+
+	// Run configures the execution of the generated main programs.
+	Run RunConfig `yaml:"run"`
+
+	// Sandbox configures the isolated execution of the generated tests and programs.
+	Sandbox SandboxConfig `yaml:"sandbox"`
+
+	// Gates configures the validation gates run after each successful build.
+	Gates GatesConfig `yaml:"gates"`
+
+	// Flaky configures the reruns of the failed tests before asking the model to fix them.
+	Flaky FlakyConfig `yaml:"flaky"`
+
+	// Mutation configures the mutation testing of the source file once its tests pass.
+	Mutation MutationConfig `yaml:"mutation"`
 }
 
 // ConfigCache is a cache to contains the configuration for all processed files.
@@ -91,6 +115,25 @@ func (j *job) updateCache() error {
 		j.maxAttempts = cfg.MaxAttempts
 	}
 
+	j.local = cfg.Local
+	j.prefixes = cfg.Prefixes
+	j.responseFormat = cfg.ResponseFormat
+	if j.responseFormat != "" && j.responseFormat != responseFormatCode && j.responseFormat != responseFormatDiff {
+		return fmt.Errorf(j.t("unknown response format %q, use %q or %q"), j.responseFormat, responseFormatCode, responseFormatDiff)
+	}
+	j.rewriteSteps = cfg.RewriteSteps
+	for _, name := range j.rewriteSteps {
+		if _, ok := stepsRewrite[step(name)]; !ok {
+			return fmt.Errorf(j.t("unknown rewrite step %q, use %q or %q"), name, stepOptimize, stepRefactor)
+		}
+	}
+
+	j.runConfig = cfg.Run
+	j.sandboxConfig = cfg.Sandbox
+	j.gatesConfig = cfg.Gates
+	j.flakyConfig = cfg.Flaky
+	j.mutationConfig = cfg.Mutation
+
 	if err := j.loadTranslations(); err != nil {
 		return err
 	}
@@ -105,6 +148,7 @@ func (cfg *Config) Merge(newCfg *Config) *Config {
 	if newCfg.Local != "" {
 		cfg.Local = newCfg.Local
 	}
+	cfg.Prefixes = removeDuplicates(append(cfg.Prefixes, newCfg.Prefixes...))
 
 	{
 		if newCfg.OpenAIURL != "" {
@@ -128,8 +172,20 @@ func (cfg *Config) Merge(newCfg *Config) *Config {
 		if newCfg.MaxAttempts != 0 {
 			cfg.MaxAttempts = newCfg.MaxAttempts
 		}
+		if newCfg.ResponseFormat != "" {
+			cfg.ResponseFormat = newCfg.ResponseFormat
+		}
+		if len(newCfg.RewriteSteps) > 0 {
+			cfg.RewriteSteps = newCfg.RewriteSteps
+		}
 	}
 
+	cfg.Run.Merge(newCfg.Run)
+	cfg.Sandbox.Merge(newCfg.Sandbox)
+	cfg.Gates.Merge(newCfg.Gates)
+	cfg.Flaky.Merge(newCfg.Flaky)
+	cfg.Mutation.Merge(newCfg.Mutation)
+
 	return cfg
 }
 
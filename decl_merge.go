@@ -0,0 +1,667 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// regMajorVersion matches the major version suffix of a module path.
+var regMajorVersion = regexp.MustCompile(`^v\d+$`)
+
+// receiverTypeName returns the name of the type of a method receiver, without pointer nor type parameters.
+func receiverTypeName(recv *ast.FieldList) string {
+	if recv == nil || len(recv.List) == 0 {
+		return ""
+	}
+
+	typ := recv.List[0].Type
+	for {
+		switch x := typ.(type) {
+		case *ast.StarExpr:
+			typ = x.X
+		case *ast.ParenExpr:
+			typ = x.X
+		case *ast.IndexExpr:
+			typ = x.X
+		case *ast.IndexListExpr:
+			typ = x.X
+		case *ast.Ident:
+			return x.Name
+		default:
+			return exprToString(typ)
+		}
+	}
+}
+
+// funcKey returns the key identifying a function in its package: its name, prefixed by the type
+// of its receiver for the methods.
+func funcKey(funcDecl *ast.FuncDecl) string {
+	if recvType := receiverTypeName(funcDecl.Recv); recvType != "" {
+		return recvType + "." + funcDecl.Name.Name
+	}
+	return funcDecl.Name.Name
+}
+
+// packageTypeNames returns the names of the types declared in the package of a file.
+func (j *job) packageTypeNames(fileName string) map[string]bool {
+	names := make(map[string]bool)
+
+	matches, _ := filepath.Glob(filepath.Join(j.fileDir, filepath.Dir(fileName), "*.go"))
+	for _, match := range matches {
+		node, err := parser.ParseFile(token.NewFileSet(), match, nil, parser.SkipObjectResolution)
+		if err != nil {
+			continue
+		}
+		addTypeNames(names, node.Decls)
+	}
+	return names
+}
+
+// addTypeNames adds the names of the types declared in a list of declarations.
+func addTypeNames(names map[string]bool, decls []ast.Decl) {
+	for _, decl := range decls {
+		genDecl, ok := decl.(*ast.GenDecl)
+		if !ok || genDecl.Tok != token.TYPE {
+			continue
+		}
+		for _, spec := range genDecl.Specs {
+			if typeSpec, ok := spec.(*ast.TypeSpec); ok {
+				names[typeSpec.Name.Name] = true
+			}
+		}
+	}
+}
+
+// funcMatcher finds the existing function replaced by a function of the model response.
+type funcMatcher struct {
+	// byKey are the complete functions of the file by key.
+	byKey map[string][]*ast.FuncDecl
+	// methodsByName are the methods of the file by name.
+	methodsByName map[string][]*ast.FuncDecl
+	// types are the types declared in the package and in the response.
+	types map[string]bool
+}
+
+// newFuncMatcher indexes the functions of a file.
+func (j *job) newFuncMatcher(fileName string, decls []ast.Decl, response string) *funcMatcher {
+	m := &funcMatcher{
+		byKey:         make(map[string][]*ast.FuncDecl),
+		methodsByName: make(map[string][]*ast.FuncDecl),
+		types:         j.packageTypeNames(fileName),
+	}
+	addTypeNames(m.types, decls)
+
+	if !strings.HasPrefix(response, "package") {
+		response = "package main\n\n" + response
+	}
+	if node, err := parser.ParseFile(token.NewFileSet(), "", response, parser.SkipObjectResolution); err == nil {
+		addTypeNames(m.types, node.Decls)
+	}
+
+	for _, decl := range decls {
+		funcDecl, ok := decl.(*ast.FuncDecl)
+		if !ok || !isCompleteFunction(funcDecl) {
+			continue
+		}
+		m.byKey[funcKey(funcDecl)] = append(m.byKey[funcKey(funcDecl)], funcDecl)
+		if funcDecl.Recv != nil {
+			m.methodsByName[funcDecl.Name.Name] = append(m.methodsByName[funcDecl.Name.Name], funcDecl)
+		}
+	}
+	return m
+}
+
+// match returns the function replaced by a function of the response, or nil if it is a new function.
+// ambiguous is true when the function can designate several existing functions, or a method of an
+// unknown type, in which case it must neither replace nor be added.
+func (m *funcMatcher) match(openAIFunc *ast.FuncDecl) (existing *ast.FuncDecl, candidates []*ast.FuncDecl, ambiguous bool) {
+	key := funcKey(openAIFunc)
+
+	switch matches := m.byKey[key]; {
+	case len(matches) == 1:
+		return matches[0], nil, false
+	case len(matches) > 1 && key != "init":
+		return nil, matches, true
+	case len(matches) > 1:
+		// A package can have several init functions.
+		return nil, nil, false
+	}
+
+	if recvType := receiverTypeName(openAIFunc.Recv); recvType != "" && !m.types[recvType] {
+		if methods := m.methodsByName[openAIFunc.Name.Name]; len(methods) > 0 {
+			return nil, methods, true
+		}
+	}
+	return nil, nil, false
+}
+
+// reportAmbiguousFunc warns that a function of the response is not merged as it can designate several functions.
+func (j *job) reportAmbiguousFunc(openAIFunc *ast.FuncDecl, candidates []*ast.FuncDecl) {
+	var names []string
+	for _, candidate := range candidates {
+		names = append(names, extractFunctionDetails(candidate))
+	}
+
+	log.Warnf(j.t("The function %s of the response is ambiguous, it is not merged. Candidates")+":\n- %s",
+		extractFunctionDetails(openAIFunc), strings.Join(names, "\n- "))
+}
+
+// declMerger merges the declarations of a model response into a Go file by editing the text of the file,
+// so that its comments, build constraints and directives are kept. The text of the response declarations
+// is copied with their comments.
+type declMerger struct {
+	src  []byte
+	fs   *token.FileSet
+	node *ast.File
+
+	newSrc  []byte
+	newFs   *token.FileSet
+	newNode *ast.File
+
+	// local and prefixes are the local module and its prefixes grouping the imports.
+	local    string
+	prefixes []string
+
+	edits []textEdit
+	added []string
+}
+
+// newDeclMerger parses the file and the response to merge.
+func (j *job) newDeclMerger(fileName string, src []byte, response string) (*declMerger, error) {
+	fs := token.NewFileSet()
+	node, err := parser.ParseFile(fs, filepath.Join(j.fileDir, fileName), src, parser.ParseComments)
+	if err != nil {
+		return nil, fmt.Errorf(j.t("error parsing file")+": %v", err)
+	}
+
+	if !strings.HasPrefix(response, "package") {
+		response = "package main\n\n" + response
+	}
+	newFs := token.NewFileSet()
+	newNode, err := parser.ParseFile(newFs, "", response, parser.ParseComments)
+	if err != nil {
+		return nil, fmt.Errorf(j.t("error parsing functions from code")+": %v", err)
+	}
+
+	return &declMerger{
+		src: src, fs: fs, node: node,
+		newSrc: []byte(response), newFs: newFs, newNode: newNode,
+		local: j.local, prefixes: j.prefixes,
+	}, nil
+}
+
+// offset returns the offset of a position of the file.
+func (m *declMerger) offset(pos token.Pos) int {
+	return m.fs.Position(pos).Offset
+}
+
+// newText returns the text of the response between two positions.
+func (m *declMerger) newText(start, end token.Pos) string {
+	return string(m.newSrc[m.newFs.Position(start).Offset:m.newFs.Position(end).Offset])
+}
+
+// replace replaces the text of the file between two positions.
+func (m *declMerger) replace(start, end token.Pos, text string) {
+	if string(m.src[m.offset(start):m.offset(end)]) == text {
+		return
+	}
+	m.edits = append(m.edits, textEdit{start: m.offset(start), end: m.offset(end), text: text})
+}
+
+// insert inserts a text in the file before a position.
+func (m *declMerger) insert(pos token.Pos, text string) {
+	m.edits = append(m.edits, textEdit{start: m.offset(pos), end: m.offset(pos), text: text})
+}
+
+// add adds a declaration of the response at the end of the file, with its doc comment.
+func (m *declMerger) add(doc *ast.CommentGroup, text string) {
+	if doc != nil {
+		text = m.newText(doc.Pos(), doc.End()) + "\n" + text
+	}
+	m.added = append(m.added, text)
+}
+
+// addDoc attaches the doc comment of a response declaration to an existing declaration without one.
+func (m *declMerger) addDoc(pos token.Pos, doc, newDoc *ast.CommentGroup) {
+	if doc == nil && newDoc != nil {
+		m.insert(pos, m.newText(newDoc.Pos(), newDoc.End())+"\n")
+	}
+}
+
+// specDoc returns the doc comment of a spec, which is the one of its declaration when it is alone.
+func specDoc(genDecl *ast.GenDecl, specDoc *ast.CommentGroup) *ast.CommentGroup {
+	if specDoc == nil && !genDecl.Lparen.IsValid() {
+		return genDecl.Doc
+	}
+	return specDoc
+}
+
+// importSpec is an import of the merged file with its comments.
+type importSpec struct {
+	// alias is the explicit name of the import, which sorts the imports of the same path as gofmt does.
+	alias, path string
+	text        string
+	group       int
+}
+
+// importName returns the name under which an import is used in the code.
+func importName(spec *ast.ImportSpec) string {
+	if spec.Name != nil {
+		return spec.Name.Name
+	}
+
+	path, _ := strconv.Unquote(spec.Path.Value)
+	parts := strings.Split(path, "/")
+	name := parts[len(parts)-1]
+	// Major version suffixes are not part of the package name.
+	if len(parts) > 1 && regMajorVersion.MatchString(name) {
+		name = parts[len(parts)-2]
+	}
+	return strings.TrimPrefix(name, "go-")
+}
+
+// importAlias returns the explicit name of an import, or an empty string.
+func importAlias(spec *ast.ImportSpec) string {
+	if spec.Name != nil {
+		return spec.Name.Name
+	}
+	return ""
+}
+
+// importGroup returns the group of an import path: the standard library, the third-party packages,
+// the packages of the local module, then the packages of each prefix of the local module.
+func importGroup(path, local string, prefixes []string) int {
+	group, longest := 1, 0
+	if !strings.Contains(strings.Split(path, "/")[0], ".") {
+		group = 0
+	}
+
+	isUnder := func(path, prefix string) bool {
+		return prefix != "" && (path == prefix || strings.HasPrefix(path, prefix+"/"))
+	}
+
+	if isUnder(path, local) {
+		group, longest = 2, len(local)
+	}
+	for i, prefix := range prefixes {
+		if local != "" && !strings.HasPrefix(prefix, local) {
+			prefix = local + "/" + strings.Trim(prefix, "/")
+		}
+		if isUnder(path, prefix) && len(prefix) > longest {
+			group, longest = 3+i, len(prefix)
+		}
+	}
+	return group
+}
+
+// specText returns the text of an import spec of the file with its comments.
+func (m *declMerger) specText(spec *ast.ImportSpec) string {
+	start, end := spec.Pos(), spec.End()
+	if spec.Doc != nil {
+		start = spec.Doc.Pos()
+	}
+	if spec.Comment != nil {
+		end = spec.Comment.End()
+	}
+	return string(m.src[m.offset(start):m.offset(end)])
+}
+
+// mergeImports adds the imports of the response missing from the file, keeping the names, the blank
+// imports and the comments of the imports, and rewrites the imports in groups: standard library,
+// third-party packages, local module, then each prefix of the local module.
+// The cgo import "C" and its preamble are left as is.
+func (m *declMerger) mergeImports() {
+	var decls []*ast.GenDecl
+	var specs []importSpec
+	existing := make(map[string]string)
+
+	for _, decl := range m.node.Decls {
+		genDecl, ok := decl.(*ast.GenDecl)
+		if !ok || genDecl.Tok != token.IMPORT {
+			continue
+		}
+		if len(genDecl.Specs) == 1 && genDecl.Specs[0].(*ast.ImportSpec).Path.Value == `"C"` {
+			continue
+		}
+		decls = append(decls, genDecl)
+
+		for _, spec := range genDecl.Specs {
+			imp := spec.(*ast.ImportSpec)
+			path, _ := strconv.Unquote(imp.Path.Value)
+			specs = append(specs, importSpec{alias: importAlias(imp), path: path, text: m.specText(imp)})
+			existing[importName(imp)+" "+path] = path
+		}
+	}
+
+	added := false
+	for _, imp := range m.newNode.Imports {
+		path, _ := strconv.Unquote(imp.Path.Value)
+		key := importName(imp) + " " + path
+		if path == "C" || existing[key] != "" {
+			continue
+		}
+		existing[key] = path
+
+		// The response code uses its own name for the package, even if the file imports it under another one.
+		text := m.newText(imp.Pos(), imp.End())
+		if imp.Doc != nil {
+			text = m.newText(imp.Doc.Pos(), imp.End())
+		}
+		specs = append(specs, importSpec{alias: importAlias(imp), path: path, text: text})
+		added = true
+	}
+
+	if !added {
+		return
+	}
+
+	// The comments inside the import declarations that belong to no spec are kept at the top.
+	var floating []string
+	for _, group := range m.node.Comments {
+		for _, decl := range decls {
+			if group.Pos() <= decl.Pos() || group.End() >= decl.End() {
+				continue
+			}
+			attached := false
+			for _, spec := range decl.Specs {
+				imp := spec.(*ast.ImportSpec)
+				attached = attached || group == imp.Doc || group == imp.Comment
+			}
+			if !attached {
+				floating = append(floating, string(m.src[m.offset(group.Pos()):m.offset(group.End())]))
+			}
+		}
+	}
+
+	for i := range specs {
+		specs[i].group = importGroup(specs[i].path, m.local, m.prefixes)
+	}
+	sort.SliceStable(specs, func(a, b int) bool {
+		if specs[a].group != specs[b].group {
+			return specs[a].group < specs[b].group
+		}
+		if specs[a].path != specs[b].path {
+			return specs[a].path < specs[b].path
+		}
+		return specs[a].alias < specs[b].alias
+	})
+
+	var block strings.Builder
+	block.WriteString("import (\n")
+	for _, comment := range floating {
+		block.WriteString("\t" + comment + "\n\n")
+	}
+	for i, spec := range specs {
+		if i > 0 && spec.group != specs[i-1].group {
+			block.WriteString("\n")
+		}
+		block.WriteString("\t" + spec.text + "\n")
+	}
+	block.WriteString(")")
+
+	if len(decls) == 0 {
+		m.insert(m.node.Name.End(), "\n\n"+block.String())
+		return
+	}
+
+	m.replace(decls[0].Pos(), decls[0].End(), block.String())
+	for _, decl := range decls[1:] {
+		start := decl.Pos()
+		if decl.Doc != nil {
+			start = decl.Doc.Pos()
+		}
+		m.replace(start, decl.End(), "")
+	}
+}
+
+// mergeTypes replaces the types of the file declared in the response and adds the new ones.
+func (m *declMerger) mergeTypes() {
+	existing := make(map[string]*ast.TypeSpec)
+	docPos := make(map[string]token.Pos)
+	docs := make(map[string]*ast.CommentGroup)
+	for _, decl := range m.node.Decls {
+		genDecl, ok := decl.(*ast.GenDecl)
+		if !ok || genDecl.Tok != token.TYPE {
+			continue
+		}
+		for _, spec := range genDecl.Specs {
+			typeSpec := spec.(*ast.TypeSpec)
+			existing[typeSpec.Name.Name] = typeSpec
+			docs[typeSpec.Name.Name] = specDoc(genDecl, typeSpec.Doc)
+			docPos[typeSpec.Name.Name] = typeSpec.Pos()
+			if !genDecl.Lparen.IsValid() {
+				docPos[typeSpec.Name.Name] = genDecl.Pos()
+			}
+		}
+	}
+
+	for _, decl := range m.newNode.Decls {
+		genDecl, ok := decl.(*ast.GenDecl)
+		if !ok || genDecl.Tok != token.TYPE {
+			continue
+		}
+		for _, spec := range genDecl.Specs {
+			typeSpec := spec.(*ast.TypeSpec)
+			doc := specDoc(genDecl, typeSpec.Doc)
+
+			old, ok := existing[typeSpec.Name.Name]
+			if !ok {
+				m.add(doc, "type "+m.newText(typeSpec.Pos(), typeSpec.End()))
+				continue
+			}
+
+			// The type parameters and the type are replaced, the name and the comments are kept.
+			m.replace(old.Name.End(), old.Type.End(), m.newText(typeSpec.Name.End(), typeSpec.Type.End()))
+			m.addDoc(docPos[typeSpec.Name.Name], docs[typeSpec.Name.Name], doc)
+		}
+	}
+}
+
+// valueGroup is a set of specs of the response and of the file sharing names, directly or through each other.
+type valueGroup struct {
+	olds []*ast.ValueSpec
+	news []*ast.ValueSpec
+}
+
+// mergeValues replaces the constants or the variables of the file declared in the response and adds the new ones.
+// The specs of the file are replaced by the specs of the response declaring one of their names: the first spec of the
+// file is replaced, the others are removed, and the other specs of the response are added. It returns the names of
+// the specs of the response that would remove a name of the file, which are not merged.
+func (m *declMerger) mergeValues(tok token.Token) []string {
+	existing := make(map[string]*ast.ValueSpec)
+	existingText := make(map[string]bool)
+	genDecls := make(map[*ast.ValueSpec]*ast.GenDecl)
+	for _, decl := range m.node.Decls {
+		genDecl, ok := decl.(*ast.GenDecl)
+		if !ok || genDecl.Tok != tok {
+			continue
+		}
+		for _, spec := range genDecl.Specs {
+			valueSpec := spec.(*ast.ValueSpec)
+			for _, name := range valueSpec.Names {
+				if name.Name != "_" {
+					existing[name.Name] = valueSpec
+				}
+			}
+			genDecls[valueSpec] = genDecl
+			existingText[string(m.src[m.offset(valueSpec.Pos()):m.offset(valueSpec.End())])] = true
+		}
+	}
+
+	// The specs sharing names are grouped, so that each spec of the file is replaced once.
+	groups := make(map[ast.Spec]*valueGroup)
+	var order []*valueGroup
+	for _, decl := range m.newNode.Decls {
+		genDecl, ok := decl.(*ast.GenDecl)
+		if !ok || genDecl.Tok != tok {
+			continue
+		}
+		for _, spec := range genDecl.Specs {
+			valueSpec := spec.(*ast.ValueSpec)
+			for _, name := range valueSpec.Names {
+				old, ok := existing[name.Name]
+				if !ok || groups[valueSpec] == groups[old] && groups[old] != nil {
+					continue
+				}
+
+				group := groups[valueSpec]
+				if group == nil {
+					group = &valueGroup{news: []*ast.ValueSpec{valueSpec}}
+					groups[valueSpec] = group
+					order = append(order, group)
+				}
+				other := groups[old]
+				if other == nil {
+					group.olds = append(group.olds, old)
+					groups[old] = group
+					continue
+				}
+
+				// The group of the spec of the file joins the one of the spec of the response.
+				group.olds = append(group.olds, other.olds...)
+				group.news = append(group.news, other.news...)
+				for _, s := range other.olds {
+					groups[s] = group
+				}
+				for _, s := range other.news {
+					groups[s] = group
+				}
+				other.olds, other.news = nil, nil
+			}
+		}
+	}
+
+	var conflicts []string
+	handled := make(map[*ast.ValueSpec]bool)
+	for _, group := range order {
+		if len(group.olds) == 0 {
+			continue
+		}
+		sort.Slice(group.olds, func(a, b int) bool { return group.olds[a].Pos() < group.olds[b].Pos() })
+		sort.Slice(group.news, func(a, b int) bool { return group.news[a].Pos() < group.news[b].Pos() })
+
+		declared := make(map[string]bool)
+		for _, spec := range group.news {
+			for _, name := range spec.Names {
+				declared[name.Name] = true
+			}
+		}
+		var lost []string
+		for _, spec := range group.olds {
+			for _, name := range spec.Names {
+				if name.Name != "_" && !declared[name.Name] {
+					lost = append(lost, name.Name)
+				}
+			}
+		}
+		if len(lost) > 0 {
+			var names []string
+			for _, spec := range group.news {
+				handled[spec] = true
+				for _, name := range spec.Names {
+					names = append(names, name.Name)
+				}
+			}
+			conflicts = append(conflicts, strings.Join(names, ", "))
+			continue
+		}
+
+		handled[group.news[0]] = true
+		m.replace(group.olds[0].Pos(), group.olds[0].End(), m.newText(group.news[0].Pos(), group.news[0].End()))
+		for _, old := range group.olds[1:] {
+			m.removeValueSpec(genDecls[old], old)
+		}
+	}
+
+	for _, decl := range m.newNode.Decls {
+		genDecl, ok := decl.(*ast.GenDecl)
+		if !ok || genDecl.Tok != tok {
+			continue
+		}
+
+		var added []string
+		for _, spec := range genDecl.Specs {
+			valueSpec := spec.(*ast.ValueSpec)
+			text := m.newText(valueSpec.Pos(), valueSpec.End())
+
+			// Blank declarations, such as interface assertions, are only identified by their text.
+			if !handled[valueSpec] && !existingText[text] {
+				added = append(added, text)
+			}
+		}
+
+		switch {
+		case len(added) == 0:
+		case len(added) == len(genDecl.Specs):
+			m.add(genDecl.Doc, m.newText(genDecl.Pos(), genDecl.End()))
+		default:
+			m.add(nil, tok.String()+" (\n"+strings.Join(added, "\n")+"\n)")
+		}
+	}
+
+	return conflicts
+}
+
+// removeValueSpec removes a spec of the file with its comments, or its declaration when it is alone.
+func (m *declMerger) removeValueSpec(genDecl *ast.GenDecl, spec *ast.ValueSpec) {
+	if len(genDecl.Specs) == 1 {
+		start := genDecl.Pos()
+		if genDecl.Doc != nil {
+			start = genDecl.Doc.Pos()
+		}
+		m.replace(start, genDecl.End(), "")
+		return
+	}
+
+	start, end := spec.Pos(), spec.End()
+	if spec.Doc != nil {
+		start = spec.Doc.Pos()
+	}
+	if spec.Comment != nil {
+		end = spec.Comment.End()
+	}
+	m.replace(start, end, "")
+}
+
+// bytes returns the merged file, formatted.
+func (m *declMerger) bytes() ([]byte, error) {
+	edits := m.edits
+	if len(m.added) > 0 {
+		edits = append(edits, textEdit{start: len(m.src), end: len(m.src), text: "\n\n" + strings.Join(m.added, "\n\n") + "\n"})
+	}
+	return format.Source(applyTextEdits(m.src, edits))
+}
+
+// keepDirectives adds to filtered comments the comment groups of all containing directives, such as
+// //go:generate, which must survive the removal of the node they were attached to.
+func keepDirectives(all, filtered []*ast.CommentGroup) []*ast.CommentGroup {
+	kept := make(map[*ast.CommentGroup]bool)
+	for _, group := range filtered {
+		kept[group] = true
+	}
+
+	var result []*ast.CommentGroup
+	for _, group := range all {
+		if kept[group] {
+			result = append(result, group)
+			continue
+		}
+		for _, comment := range group.List {
+			if strings.HasPrefix(comment.Text, "//go:") {
+				result = append(result, group)
+				break
+			}
+		}
+	}
+	return result
+}
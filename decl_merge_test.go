@@ -0,0 +1,378 @@
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"reflect"
+	"testing"
+)
+
+func TestReceiverTypeName(t *testing.T) {
+	tests := map[string]string{
+		"func F() {}":               "",
+		"func (T) F() {}":           "T",
+		"func (t *T) F() {}":        "T",
+		"func (l *List[T]) F() {}":  "List",
+		"func (m Map[K, V]) F() {}": "Map",
+		"func (t *(T)) F() {}":      "T",
+	}
+
+	for src, want := range tests {
+		file, err := parser.ParseFile(token.NewFileSet(), "", "package p\n\n"+src, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := receiverTypeName(file.Decls[0].(*ast.FuncDecl).Recv); got != want {
+			t.Errorf("receiverTypeName(%q) = %q, want %q", src, got, want)
+		}
+	}
+}
+
+func TestFuncMatcherMatch(t *testing.T) {
+	src := "package p\n\ntype A struct{}\ntype B struct{}\n\n" +
+		"func F() {}\nfunc init() {}\nfunc init() {}\nfunc (A) Close() {}\nfunc (*B) Close() {}\nfunc (A) Open() {}\n"
+	file, err := parser.ParseFile(token.NewFileSet(), "", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name          string
+		response      string
+		wantMatch     string
+		wantAmbiguous bool
+	}{
+		{"function", "func F() {}", "F", false},
+		{"method", "func (a *A) Close() {}", "A.Close", false},
+		{"new function", "func G() {}", "", false},
+		{"several init", "func init() {}", "", false},
+		{"method of an unknown type", "func (c *C) Close() {}", "", true},
+		{"new method of a declared type", "func (b *B) Open() {}", "", false},
+		{"method of a type of the response", "type C struct{}\n\nfunc (c *C) Close() {}", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			j := &job{fileDir: t.TempDir()}
+			m := j.newFuncMatcher("p.go", file.Decls, tt.response)
+
+			response, err := parser.ParseFile(token.NewFileSet(), "", "package p\n\n"+tt.response, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			openAIFunc := response.Decls[len(response.Decls)-1].(*ast.FuncDecl)
+
+			existing, _, ambiguous := m.match(openAIFunc)
+			got := ""
+			if existing != nil {
+				got = funcKey(existing)
+			}
+			if got != tt.wantMatch || ambiguous != tt.wantAmbiguous {
+				t.Errorf("match() = %q, %v, want %q, %v", got, ambiguous, tt.wantMatch, tt.wantAmbiguous)
+			}
+		})
+	}
+}
+
+func TestMergeCodeKeepsTheFileText(t *testing.T) {
+	src := `//go:build linux
+
+// Package p does things.
+package p
+
+//go:generate stringer -type=Kind
+
+// Kind is a kind.
+type Kind int // the kind
+
+const (
+	// KindA is the first kind.
+	KindA Kind = iota
+	KindB
+)
+
+var _ fmt.Stringer = Kind(0)
+
+// F does things.
+//
+//go:noinline
+func F() int {
+	// A comment in the body.
+	return 1
+}
+`
+
+	tests := []struct {
+		name     string
+		response string
+		want     string
+	}{
+		{
+			name:     "type replaced",
+			response: "// Kind is the kind of a thing.\ntype Kind uint8",
+			want: `//go:build linux
+
+// Package p does things.
+package p
+
+//go:generate stringer -type=Kind
+
+// Kind is a kind.
+type Kind uint8 // the kind
+
+const (
+	// KindA is the first kind.
+	KindA Kind = iota
+	KindB
+)
+
+var _ fmt.Stringer = Kind(0)
+
+// F does things.
+//
+//go:noinline
+func F() int {
+	// A comment in the body.
+	return 1
+}
+`,
+		},
+		{
+			name:     "constant replaced and constant added",
+			response: "const (\n\tKindA Kind = iota + 1\n\tKindC Kind = 10\n)",
+			want: `//go:build linux
+
+// Package p does things.
+package p
+
+//go:generate stringer -type=Kind
+
+// Kind is a kind.
+type Kind int // the kind
+
+const (
+	// KindA is the first kind.
+	KindA Kind = iota + 1
+	KindB
+)
+
+var _ fmt.Stringer = Kind(0)
+
+// F does things.
+//
+//go:noinline
+func F() int {
+	// A comment in the body.
+	return 1
+}
+
+const (
+	KindC Kind = 10
+)
+`,
+		},
+		{
+			name:     "interface assertion repeated",
+			response: "var _ fmt.Stringer = Kind(0)\n\nfunc F() int {\n\treturn 2\n}",
+			want: `//go:build linux
+
+// Package p does things.
+package p
+
+//go:generate stringer -type=Kind
+
+// Kind is a kind.
+type Kind int // the kind
+
+const (
+	// KindA is the first kind.
+	KindA Kind = iota
+	KindB
+)
+
+var _ fmt.Stringer = Kind(0)
+
+// F does things.
+//
+//go:noinline
+func F() int {
+	return 2
+}
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			j := &job{fileDir: t.TempDir()}
+			got, err := j.mergeCode("p.go", []byte(src), tt.response)
+			if err != nil {
+				t.Fatalf("mergeCode() error = %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("mergeCode() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestImportName(t *testing.T) {
+	tests := []struct {
+		spec string
+		want string
+	}{
+		{`"fmt"`, "fmt"},
+		{`"net/http"`, "http"},
+		{`f "fmt"`, "f"},
+		{`_ "embed"`, "_"},
+		{`"github.com/go-chi/chi/v5"`, "chi"},
+		{`"github.com/mattn/go-sqlite3"`, "sqlite3"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			file, err := parser.ParseFile(token.NewFileSet(), "", "package p\n\nimport "+tt.spec+"\n", parser.ImportsOnly)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := importName(file.Imports[0]); got != tt.want {
+				t.Errorf("importName() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestImportGroup(t *testing.T) {
+	tests := []struct {
+		path     string
+		local    string
+		prefixes []string
+		want     int
+	}{
+		{"fmt", "", nil, 0},
+		{"github.com/pkg/errors", "", nil, 1},
+		{"example.com/m/a", "example.com/m", nil, 2},
+		{"example.com/mod", "example.com/m", nil, 1},
+		{"example.com/m/internal/db", "example.com/m", []string{"internal", "internal/db"}, 4},
+		{"example.com/m/internal/x", "example.com/m", []string{"example.com/m/internal"}, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if got := importGroup(tt.path, tt.local, tt.prefixes); got != tt.want {
+				t.Errorf("importGroup() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeImports(t *testing.T) {
+	tests := []struct {
+		name     string
+		src      string
+		response string
+		want     string
+	}{
+		{
+			name:     "no import",
+			src:      "package p\n\nfunc F() {}\n",
+			response: "import \"fmt\"\n\nfunc G() { fmt.Println() }",
+			want:     "package p\n\nimport (\n\t\"fmt\"\n)\n\nfunc F() {}\n\nfunc G() { fmt.Println() }\n",
+		},
+		{
+			name: "groups, names and comments kept",
+			src: "package p\n\nimport (\n\t// Register the driver.\n\t_ \"github.com/lib/pq\"\n\t\"example.com/m/a\"\n\tstr \"strings\" // short name\n)\n\n" +
+				"func F() { _ = a.A; _ = str.ToUpper }\n",
+			response: "import (\n\t\"os\"\n\t\"example.com/m/internal/db\"\n\t\"github.com/lib/pq\"\n)\n\nfunc G() { _ = os.Args; _ = db.Open; _ = pq.Driver{} }",
+			want: "package p\n\nimport (\n\t\"os\"\n\tstr \"strings\" // short name\n\n" +
+				"\t\"github.com/lib/pq\"\n\t// Register the driver.\n\t_ \"github.com/lib/pq\"\n\n" +
+				"\t\"example.com/m/a\"\n\n\t\"example.com/m/internal/db\"\n)\n\n" +
+				"func F() { _ = a.A; _ = str.ToUpper }\n\nfunc G() { _ = os.Args; _ = db.Open; _ = pq.Driver{} }\n",
+		},
+		{
+			name:     "imports already there",
+			src:      "package p\n\nimport \"fmt\"\n\nfunc F() { fmt.Println() }\n",
+			response: "import \"fmt\"\n\nfunc G() { fmt.Println() }",
+			want:     "package p\n\nimport \"fmt\"\n\nfunc F() { fmt.Println() }\n\nfunc G() { fmt.Println() }\n",
+		},
+		{
+			name:     "cgo preamble left as is",
+			src:      "package p\n\n// #include <stdio.h>\nimport \"C\"\n\nimport \"fmt\"\n\nfunc F() { fmt.Println() }\n",
+			response: "import \"os\"\n\nfunc G() { _ = os.Args }",
+			want: "package p\n\n// #include <stdio.h>\nimport \"C\"\n\nimport (\n\t\"fmt\"\n\t\"os\"\n)\n\n" +
+				"func F() { fmt.Println() }\n\nfunc G() { _ = os.Args }\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			j := &job{fileDir: t.TempDir(), local: "example.com/m", prefixes: []string{"internal"}}
+			got, err := j.mergeCode("p.go", []byte(tt.src), tt.response)
+			if err != nil {
+				t.Fatalf("mergeCode() error = %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("mergeCode() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeValues(t *testing.T) {
+	tests := []struct {
+		name          string
+		src           string
+		response      string
+		want          string
+		wantConflicts []string
+	}{
+		{
+			name:     "names split into several specs",
+			src:      "package p\n\nvar a, b int\n",
+			response: "var a int\n\nvar b string",
+			want:     "package p\n\nvar a int\n\nvar b string\n",
+		},
+		{
+			name:     "names grouped into one spec",
+			src:      "package p\n\n// A is a.\nvar a int\n\nvar b int\n\nvar c int\n",
+			response: "var a, b = 1, 2",
+			want:     "package p\n\n// A is a.\nvar a, b = 1, 2\n\nvar c int\n",
+		},
+		{
+			name:     "spec removed from a group",
+			src:      "package p\n\nconst (\n\tA = 1\n\t// B is b.\n\tB = 2 // two\n\tC = 3\n)\n",
+			response: "const A, B = 10, 20",
+			want:     "package p\n\nconst (\n\tA, B = 10, 20\n\n\tC = 3\n)\n",
+		},
+		{
+			name:          "name removed",
+			src:           "package p\n\nvar a, b int\n",
+			response:      "var a string\n\nvar c int",
+			want:          "package p\n\nvar a, b int\n\nvar c int\n",
+			wantConflicts: []string{"a"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			j := &job{fileDir: t.TempDir()}
+			m, err := j.newDeclMerger("p.go", []byte(tt.src), tt.response)
+			if err != nil {
+				t.Fatalf("newDeclMerger() error = %v", err)
+			}
+			conflicts := append(m.mergeValues(token.CONST), m.mergeValues(token.VAR)...)
+			if !reflect.DeepEqual(conflicts, tt.wantConflicts) {
+				t.Errorf("mergeValues() conflicts = %q, want %q", conflicts, tt.wantConflicts)
+			}
+
+			got, err := m.bytes()
+			if err != nil {
+				t.Fatalf("bytes() error = %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("mergeValues() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,494 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// declOpKind is the kind of an operation requested by the model on an existing declaration.
+type declOpKind string
+
+const (
+	declOpDelete declOpKind = "DELETE"
+	declOpRename declOpKind = "RENAME"
+)
+
+// regDeclOp matches the `// DELETE: <name>` and `// RENAME: <old> -> <new>` lines of a response.
+var regDeclOp = regexp.MustCompile(`(?m)^[ \t]*(?://[ \t]*)?(DELETE|RENAME):[ \t]*([\w.()*]+)(?:[ \t]*->[ \t]*(\w+))?[ \t]*\r?$\n?`)
+
+// declOp is a deletion or a renaming of a declaration of the package. The methods and the fields
+// are named <Type>.<name>.
+type declOp struct {
+	kind    declOpKind
+	name    string
+	newName string
+}
+
+// String returns the operation as written by the model.
+func (op declOp) String() string {
+	if op.kind == declOpRename {
+		return fmt.Sprintf("%s: %s -> %s", op.kind, op.name, op.newName)
+	}
+	return fmt.Sprintf("%s: %s", op.kind, op.name)
+}
+
+// parseDeclOps extracts the operations on declarations of a response and returns the code without them.
+func parseDeclOps(code string) ([]declOp, string) {
+	var ops []declOp
+	for _, match := range regDeclOp.FindAllStringSubmatch(code, -1) {
+		op := declOp{
+			kind:    declOpKind(match[1]),
+			name:    strings.NewReplacer("(", "", ")", "", "*", "").Replace(match[2]),
+			newName: match[3],
+		}
+		if op.kind == declOpRename && op.newName == "" {
+			continue
+		}
+		ops = append(ops, op)
+	}
+	return ops, regDeclOp.ReplaceAllString(code, "")
+}
+
+// declOpsPrompt returns the instructions telling the model how to delete or rename a declaration.
+func (j *job) declOpsPrompt() string {
+	return j.t("To delete a declaration of the package, add the comment line `// DELETE: <name>` to the code, " +
+		"and to rename it with its references, add the comment line `// RENAME: <old name> -> <new name>`; " +
+		"name the methods and the fields <Type>.<name>")
+}
+
+// opPackage is the type-checked package of the files modified by the operations on declarations.
+type opPackage struct {
+	fs    *token.FileSet
+	pkg   *types.Package
+	info  *types.Info
+	files map[string]*ast.File
+	srcs  map[string][]byte
+}
+
+// fileContent returns the content of a file of the module, from memory for the current files.
+func (j *job) fileContent(fileName string) ([]byte, error) {
+	if fileName == j.currentSourceFileName && j.currentSrcSource != nil {
+		return j.currentSrcSource, nil
+	}
+	if fileName == j.currentTestFileName && j.currentSrcTest != nil {
+		return j.currentSrcTest, nil
+	}
+	return os.ReadFile(filepath.Join(j.fileDir, fileName))
+}
+
+// loadOpPackage parses and type-checks the package of a file with its tests.
+// The type errors are ignored: the declarations are still resolved in a package that does not compile.
+func (j *job) loadOpPackage(fileName string, srcs map[string][]byte) (*opPackage, error) {
+	absDir, err := filepath.Abs(filepath.Join(j.fileDir, filepath.Dir(fileName)))
+	if err != nil {
+		return nil, err
+	}
+	matches, err := filepath.Glob(filepath.Join(absDir, "*.go"))
+	if err != nil {
+		return nil, err
+	}
+
+	p := &opPackage{
+		fs: token.NewFileSet(),
+		info: &types.Info{
+			Defs:       make(map[*ast.Ident]types.Object),
+			Uses:       make(map[*ast.Ident]types.Object),
+			Selections: make(map[*ast.SelectorExpr]*types.Selection),
+		},
+		files: make(map[string]*ast.File),
+		srcs:  srcs,
+	}
+
+	var pkgFiles, xtestFiles []*ast.File
+	pkgName := ""
+	for _, match := range matches {
+		rel, err := filepath.Rel(j.fileDir, match)
+		if err != nil {
+			return nil, err
+		}
+		src, ok := srcs[rel]
+		if !ok {
+			if src, err = j.fileContent(rel); err != nil {
+				return nil, err
+			}
+			srcs[rel] = src
+		}
+
+		file, err := parser.ParseFile(p.fs, match, src, parser.ParseComments)
+		if err != nil {
+			return nil, fmt.Errorf(j.t("error parsing file")+" %s: %v", rel, err)
+		}
+		p.files[rel] = file
+
+		if j.isTestFile(match) && strings.HasSuffix(file.Name.Name, "_test") {
+			xtestFiles = append(xtestFiles, file)
+			continue
+		}
+		pkgFiles = append(pkgFiles, file)
+		if !j.isTestFile(match) || pkgName == "" {
+			pkgName = file.Name.Name
+		}
+	}
+
+	conf := types.Config{
+		Importer: j.sourceImporter(p.fs),
+		Error:    func(error) {},
+	}
+
+	importPath := j.packageImportPath(absDir)
+	p.pkg, _ = conf.Check(importPath, p.fs, pkgFiles, p.info)
+	if p.pkg == nil {
+		p.pkg = types.NewPackage(importPath, pkgName)
+	}
+
+	if len(xtestFiles) > 0 {
+		conf.Importer = &packageImporter{pkg: p.pkg, importer: conf.Importer.(types.ImporterFrom)}
+		_, _ = conf.Check(importPath+"_test", p.fs, xtestFiles, p.info)
+	}
+
+	return p, nil
+}
+
+// lookup returns the object declared by the package under a name, <Type>.<name> for the methods and the fields.
+func (p *opPackage) lookup(j *job, name string) (types.Object, error) {
+	typeName, member, isMember := strings.Cut(name, ".")
+
+	obj := p.pkg.Scope().Lookup(typeName)
+	if obj == nil {
+		return nil, fmt.Errorf(j.t("%s is not declared in the package"), typeName)
+	}
+	if !isMember {
+		return obj, nil
+	}
+
+	if _, ok := obj.(*types.TypeName); !ok {
+		return nil, fmt.Errorf(j.t("%s is not a type"), typeName)
+	}
+	memberObj, _, _ := types.LookupFieldOrMethod(obj.Type(), true, p.pkg, member)
+	if memberObj == nil {
+		return nil, fmt.Errorf(j.t("%s has no field or method %s"), typeName, member)
+	}
+	if memberObj.Pkg() != p.pkg {
+		return nil, fmt.Errorf(j.t("%s is not declared in the package"), name)
+	}
+	return memberObj, nil
+}
+
+// references returns the identifiers of the package and of its tests that declare or use an object, by file.
+func (p *opPackage) references(obj types.Object) map[string][]*ast.Ident {
+	refs := make(map[string][]*ast.Ident)
+	seen := make(map[*ast.Ident]bool)
+	for _, objects := range []map[*ast.Ident]types.Object{p.info.Defs, p.info.Uses} {
+		for ident, identObj := range objects {
+			if identObj != obj || seen[ident] {
+				continue
+			}
+			seen[ident] = true
+			file := p.fileName(ident.Pos())
+			refs[file] = append(refs[file], ident)
+		}
+	}
+	return refs
+}
+
+// fileName returns the name of the file of a position, relative to the module.
+func (p *opPackage) fileName(pos token.Pos) string {
+	for name, file := range p.files {
+		if file.FileStart <= pos && pos <= file.FileEnd {
+			return name
+		}
+	}
+	return ""
+}
+
+// offset returns the offset of a position in its file.
+func (p *opPackage) offset(pos token.Pos) int {
+	return p.fs.Position(pos).Offset
+}
+
+// rename renames an object and all its references, and the first word of its doc comment.
+func (p *opPackage) rename(j *job, obj types.Object, newName string) (map[string][]textEdit, error) {
+	if !token.IsIdentifier(newName) {
+		return nil, fmt.Errorf(j.t("%s is not a valid identifier"), newName)
+	}
+
+	conflict := p.pkg.Scope().Lookup(newName)
+	if recv := declaringType(obj); recv != nil {
+		conflict, _, _ = types.LookupFieldOrMethod(recv, true, p.pkg, newName)
+	}
+	if conflict != nil {
+		return nil, fmt.Errorf(j.t("%s is already declared"), newName)
+	}
+
+	edits := make(map[string][]textEdit)
+	for file, idents := range p.references(obj) {
+		for _, ident := range idents {
+			edits[file] = append(edits[file], textEdit{start: p.offset(ident.Pos()), end: p.offset(ident.End()), text: newName})
+		}
+	}
+
+	if file, _, doc := p.declaration(obj); doc != nil {
+		if text := doc.List[0].Text; strings.HasPrefix(text, "// "+obj.Name()+" ") {
+			start := p.offset(doc.List[0].Pos()) + len("// ")
+			edits[file] = append(edits[file], textEdit{start: start, end: start + len(obj.Name()), text: newName})
+		}
+	}
+
+	return edits, nil
+}
+
+// delete removes the declaration of an object with its comments.
+func (p *opPackage) delete(j *job, obj types.Object) (map[string][]textEdit, error) {
+	file, node, _ := p.declaration(obj)
+	if node == nil {
+		return nil, fmt.Errorf(j.t("the declaration of %s was not found"), obj.Name())
+	}
+
+	start, end := p.offset(node.Pos()), p.offset(node.End())
+	src := p.srcs[file]
+
+	// The separator, the spaces and the line comment following the declaration go with it, and so does
+	// the end of the line when the declaration starts its line.
+	end = skipBlanks(src, end)
+	if end < len(src) && src[end] == ';' {
+		end = skipBlanks(src, end+1)
+	}
+	if bytes.HasPrefix(src[end:], []byte("//")) {
+		for end < len(src) && src[end] != '\n' {
+			end++
+		}
+	}
+	if end < len(src) && src[end] == '\n' && startsLine(src, start) {
+		end++
+	}
+
+	// The references left are reported to the model by the compilation.
+	uses := 0
+	for _, idents := range p.references(obj) {
+		for _, ident := range idents {
+			if p.info.Uses[ident] != nil && (p.fileName(ident.Pos()) != file || p.offset(ident.Pos()) < start || p.offset(ident.Pos()) >= end) {
+				uses++
+			}
+		}
+	}
+	if uses > 0 {
+		log.Warnf(j.t("%s is deleted but still used %d times"), obj.Name(), uses)
+	}
+
+	return map[string][]textEdit{file: {{start: start, end: end}}}, nil
+}
+
+// skipBlanks returns the offset of the first character from offset that is not a space or a tab.
+func skipBlanks(src []byte, offset int) int {
+	for offset < len(src) && (src[offset] == ' ' || src[offset] == '\t') {
+		offset++
+	}
+	return offset
+}
+
+// startsLine checks whether only spaces and tabs precede the offset on its line.
+func startsLine(src []byte, offset int) bool {
+	for i := offset - 1; i >= 0 && src[i] != '\n'; i-- {
+		if src[i] != ' ' && src[i] != '\t' {
+			return false
+		}
+	}
+	return true
+}
+
+// declaringType returns the type declaring a method or a field, or nil for the other objects.
+func declaringType(obj types.Object) types.Type {
+	switch o := obj.(type) {
+	case *types.Func:
+		if sig, ok := o.Type().(*types.Signature); ok && sig.Recv() != nil {
+			return sig.Recv().Type()
+		}
+	case *types.Var:
+		if o.IsField() {
+			return fieldOwner(o)
+		}
+	}
+	return nil
+}
+
+// fieldOwner returns the named type of the package whose struct declares a field.
+func fieldOwner(field *types.Var) types.Type {
+	scope := field.Pkg().Scope()
+	for _, name := range scope.Names() {
+		typeName, ok := scope.Lookup(name).(*types.TypeName)
+		if !ok {
+			continue
+		}
+		if st, ok := typeName.Type().Underlying().(*types.Struct); ok {
+			for i := 0; i < st.NumFields(); i++ {
+				if st.Field(i) == field {
+					return typeName.Type()
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// declaration returns the file, the node to delete and the doc comment of the declaration of an object.
+// The node includes the doc and line comments, and is the whole declaration when it declares nothing else.
+func (p *opPackage) declaration(obj types.Object) (string, ast.Node, *ast.CommentGroup) {
+	defines := func(ident *ast.Ident) bool { return p.info.Defs[ident] == obj }
+
+	for fileName, file := range p.files {
+		var found ast.Node
+		var doc *ast.CommentGroup
+		ast.Inspect(file, func(n ast.Node) bool {
+			if found != nil {
+				return false
+			}
+			switch x := n.(type) {
+			case *ast.FuncDecl:
+				if defines(x.Name) {
+					found, doc = withComments(x, x.Doc, nil), x.Doc
+				}
+			case *ast.GenDecl:
+				for _, spec := range x.Specs {
+					var names []*ast.Ident
+					var specDocs, comment *ast.CommentGroup
+					switch s := spec.(type) {
+					case *ast.TypeSpec:
+						names, specDocs, comment = []*ast.Ident{s.Name}, s.Doc, s.Comment
+					case *ast.ValueSpec:
+						names, specDocs, comment = s.Names, s.Doc, s.Comment
+					}
+					if len(names) != 1 || !defines(names[0]) {
+						continue
+					}
+					if len(x.Specs) == 1 {
+						found, doc = withComments(x, x.Doc, comment), specDoc(x, specDocs)
+					} else {
+						found, doc = withComments(spec, specDocs, comment), specDocs
+					}
+				}
+			case *ast.Field:
+				if len(x.Names) == 1 && defines(x.Names[0]) {
+					found, doc = withComments(x, x.Doc, x.Comment), x.Doc
+				}
+			}
+			return found == nil
+		})
+		if found != nil {
+			return fileName, found, doc
+		}
+	}
+	return "", nil, nil
+}
+
+// nodeRange is a range of positions of a file.
+type nodeRange struct {
+	pos, end token.Pos
+}
+
+// Pos returns the start of the range.
+func (r nodeRange) Pos() token.Pos { return r.pos }
+
+// End returns the end of the range.
+func (r nodeRange) End() token.Pos { return r.end }
+
+// withComments returns the range of a node extended to its doc and line comments.
+func withComments(node ast.Node, doc, comment *ast.CommentGroup) ast.Node {
+	r := nodeRange{pos: node.Pos(), end: node.End()}
+	if doc != nil && doc.Pos() < r.pos {
+		r.pos = doc.Pos()
+	}
+	if comment != nil && comment.End() > r.end {
+		r.end = comment.End()
+	}
+	return r
+}
+
+// applyDeclOps deletes and renames the declarations of the package of a file, and writes the modified files
+// after their review. Without -w, only the changes of the current source and test files are kept.
+// Each operation is applied to the package type-checked again, so that they can follow each other.
+func (j *job) applyDeclOps(fileName string, ops []declOp) error {
+	if len(ops) == 0 {
+		return nil
+	}
+
+	srcs := make(map[string][]byte)
+	changed := make(map[string]bool)
+	for _, op := range ops {
+		p, err := j.loadOpPackage(fileName, srcs)
+		if err != nil {
+			return err
+		}
+
+		obj, err := p.lookup(j, op.name)
+		if err != nil {
+			log.WithError(err).Warnf(j.t("The operation %s is ignored"), op)
+			continue
+		}
+
+		var edits map[string][]textEdit
+		if op.kind == declOpRename {
+			edits, err = p.rename(j, obj, op.newName)
+		} else {
+			edits, err = p.delete(j, obj)
+		}
+		if err != nil {
+			log.WithError(err).Warnf(j.t("The operation %s is ignored"), op)
+			continue
+		}
+		if frozen := j.frozenFileIn(edits); frozen != "" {
+			log.Warnf(j.t("The operation %s is ignored, it modifies the frozen file %s"), op, frozen)
+			continue
+		}
+
+		for file, fileEdits := range edits {
+			srcs[file] = applyTextEdits(srcs[file], fileEdits)
+			changed[file] = true
+		}
+		log.Infof(j.t("Operation applied")+": %s", op)
+	}
+
+	files := make([]string, 0, len(changed))
+	for file := range changed {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+
+	for _, file := range files {
+		// Without -w, only the files of the job are printed.
+		if !j.args.write && file != j.currentSourceFileName && file != j.currentTestFileName {
+			log.Warnf(j.t("The changes of the file %s are not written, use -w to write them"), file)
+			continue
+		}
+
+		res, err := format.Source(srcs[file])
+		if err != nil {
+			return fmt.Errorf(j.t("error while formatting file")+": %v", err)
+		}
+		if err := j.writeResponse(file, res); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// frozenFileIn returns the first frozen file modified by edits, or an empty string.
+func (j *job) frozenFileIn(edits map[string][]textEdit) string {
+	for file := range edits {
+		if j.frozenFiles[file] {
+			return file
+		}
+	}
+	return ""
+}
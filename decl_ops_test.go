@@ -0,0 +1,190 @@
+package main
+
+import (
+	"go/format"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseDeclOps(t *testing.T) {
+	code := "// DELETE: helper\n" +
+		"// RENAME: (*Parser).next -> advance\n" +
+		"RENAME: Old\n" +
+		"func F() {}\n"
+
+	wantOps := []declOp{
+		{kind: declOpDelete, name: "helper"},
+		{kind: declOpRename, name: "Parser.next", newName: "advance"},
+	}
+	ops, rest := parseDeclOps(code)
+	if !reflect.DeepEqual(ops, wantOps) {
+		t.Errorf("parseDeclOps() ops = %v, want %v", ops, wantOps)
+	}
+	if want := "func F() {}\n"; rest != want {
+		t.Errorf("parseDeclOps() code = %q, want %q", rest, want)
+	}
+}
+
+func TestOpPackageDelete(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		obj  string
+		want string
+	}{
+		{
+			name: "function with its doc and its line comment",
+			src:  "package p\n\n// F does things.\nfunc F() {} // legacy\n\nfunc G() {}\n",
+			obj:  "F",
+			want: "package p\n\nfunc G() {}\n",
+		},
+		{
+			name: "first declaration of a line",
+			src:  "package p\n\nfunc F() {}; func G() {}\n",
+			obj:  "F",
+			want: "package p\n\nfunc G() {}\n",
+		},
+		{
+			name: "last declaration of a line",
+			src:  "package p\n\nfunc F() {}; func G() {} // G\n\nvar x = 1\n",
+			obj:  "G",
+			want: "package p\n\nfunc F() {}\n\nvar x = 1\n",
+		},
+		{
+			name: "field of a struct on one line",
+			src:  "package p\n\ntype T struct{ A int; B string }\n",
+			obj:  "T.A",
+			want: "package p\n\ntype T struct{ B string }\n",
+		},
+		{
+			name: "spec of a group",
+			src:  "package p\n\nconst (\n\t// A is a.\n\tA = 1 // first\n\tB = 2\n)\n",
+			obj:  "A",
+			want: "package p\n\nconst (\n\tB = 2\n)\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := writeModule(t, map[string]string{
+				"go.mod": "module example.com/m\n\ngo 1.22\n",
+				"p/p.go": tt.src,
+			})
+			j := &job{fileDir: dir, modulePath: "example.com/m"}
+
+			srcs := make(map[string][]byte)
+			p, err := j.loadOpPackage("p/p.go", srcs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			obj, err := p.lookup(j, tt.obj)
+			if err != nil {
+				t.Fatal(err)
+			}
+			edits, err := p.delete(j, obj)
+			if err != nil {
+				t.Fatalf("delete() error = %v", err)
+			}
+
+			got, err := format.Source(applyTextEdits(srcs["p/p.go"], edits["p/p.go"]))
+			if err != nil {
+				t.Fatalf("delete() left invalid code: %v\n%s", err, applyTextEdits(srcs["p/p.go"], edits["p/p.go"]))
+			}
+			if string(got) != tt.want {
+				t.Errorf("delete() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOpPackageRename(t *testing.T) {
+	dir := writeModule(t, map[string]string{
+		"go.mod":      "module example.com/m\n\ngo 1.22\n",
+		"p/p.go":      "package p\n\n// helper returns one.\nfunc helper() int { return 1 }\n\nfunc F() int { return helper() }\n",
+		"p/p_test.go": "package p\n\nfunc use() int { return helper() }\n",
+	})
+	j := &job{fileDir: dir, modulePath: "example.com/m"}
+
+	srcs := make(map[string][]byte)
+	p, err := j.loadOpPackage("p/p.go", srcs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj, err := p.lookup(j, "helper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	edits, err := p.rename(j, obj, "one")
+	if err != nil {
+		t.Fatalf("rename() error = %v", err)
+	}
+
+	want := map[string]string{
+		"p/p.go":      "package p\n\n// one returns one.\nfunc one() int { return 1 }\n\nfunc F() int { return one() }\n",
+		"p/p_test.go": "package p\n\nfunc use() int { return one() }\n",
+	}
+	for file, content := range want {
+		if got := string(applyTextEdits(srcs[file], edits[file])); got != content {
+			t.Errorf("rename() of %s =\n%s\nwant\n%s", file, got, content)
+		}
+	}
+
+	if _, err := p.rename(j, obj, "F"); err == nil {
+		t.Error("rename() to a declared name succeeded")
+	}
+}
+
+func TestApplyDeclOps(t *testing.T) {
+	const src = "package p\n\nfunc helper() int { return 1 }\n\nfunc F() int { return helper() }\n"
+	const other = "package p\n\nfunc G() int { return helper() }\n"
+
+	tests := []struct {
+		name      string
+		write     bool
+		wantSrc   string
+		wantOther string
+	}{
+		{
+			name:      "without -w",
+			wantSrc:   "package p\n\nfunc one() int { return 1 }\n\nfunc F() int { return one() }\n",
+			wantOther: other,
+		},
+		{
+			name:      "with -w",
+			write:     true,
+			wantSrc:   "package p\n\nfunc one() int { return 1 }\n\nfunc F() int { return one() }\n",
+			wantOther: "package p\n\nfunc G() int { return one() }\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := writeModule(t, map[string]string{
+				"go.mod": "module example.com/m\n\ngo 1.22\n",
+				"p/p.go": src,
+				"p/q.go": other,
+			})
+			j := &job{
+				args:    &appArgs{write: tt.write, listOnly: true},
+				fileDir: dir, source: fileSourceFilePath, modulePath: "example.com/m",
+				currentSourceFileName: "p/p.go", currentTestFileName: "p/p_test.go", currentSrcSource: []byte(src),
+			}
+
+			if err := j.applyDeclOps("p/p.go", []declOp{{kind: declOpRename, name: "helper", newName: "one"}}); err != nil {
+				t.Fatalf("applyDeclOps() error = %v", err)
+			}
+			if string(j.currentSrcSource) != tt.wantSrc {
+				t.Errorf("applyDeclOps() source =\n%s\nwant\n%s", j.currentSrcSource, tt.wantSrc)
+			}
+			got, err := os.ReadFile(filepath.Join(dir, "p/q.go"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.wantOther {
+				t.Errorf("applyDeclOps() other file =\n%s\nwant\n%s", got, tt.wantOther)
+			}
+		})
+	}
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// diagnosticCategory is the kind of problem reported by the Go toolchain.
+type diagnosticCategory string
+
+const (
+	diagnosticUndefined      diagnosticCategory = "undefined"
+	diagnosticTypeMismatch   diagnosticCategory = "type mismatch"
+	diagnosticUnusedVariable diagnosticCategory = "unused variable"
+	diagnosticUnusedImport   diagnosticCategory = "unused import"
+	diagnosticRedeclared     diagnosticCategory = "redeclared"
+	diagnosticSyntax         diagnosticCategory = "syntax"
+	diagnosticOther          diagnosticCategory = "other"
+)
+
+// diagnostic is a single error reported by the Go toolchain.
+type diagnostic struct {
+	File     string
+	Line     int
+	Col      int
+	Message  string
+	Category diagnosticCategory
+}
+
+// String returns the diagnostic in the `file:line:col: message` form of the Go toolchain.
+func (d diagnostic) String() string {
+	if d.Col > 0 {
+		return fmt.Sprintf("%s:%d:%d: %s", d.File, d.Line, d.Col, d.Message)
+	}
+	return fmt.Sprintf("%s:%d: %s", d.File, d.Line, d.Message)
+}
+
+// buildEvent is an event printed by `go build -json`.
+type buildEvent struct {
+	ImportPath string
+	Action     string
+	Output     string
+}
+
+var (
+	regDiagnosticLine = regexp.MustCompile(`^(?:vet: )?([^\s:][^:]*\.go):(\d+)(?::(\d+))?: (.+)$`)
+
+	goBuildJSONOnce      sync.Once
+	goBuildJSONSupported bool
+)
+
+// goBuildSupportsJSON checks once whether the installed Go toolchain accepts `go build -json`.
+func goBuildSupportsJSON() bool {
+	goBuildJSONOnce.Do(func() {
+		out, err := exec.Command("go", "help", "build").CombinedOutput()
+		goBuildJSONSupported = err == nil && bytes.Contains(out, []byte("-json"))
+	})
+	return goBuildJSONSupported
+}
+
+// buildOutputText converts the output of `go build -json` into the plain text printed by `go build`.
+// Lines that are not JSON events are kept as is.
+func buildOutputText(output string) string {
+	var builder strings.Builder
+
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		var event buildEvent
+		if strings.HasPrefix(line, "{") && json.Unmarshal([]byte(line), &event) == nil {
+			if event.Action == "build-output" {
+				builder.WriteString(event.Output)
+			}
+			continue
+		}
+
+		builder.WriteString(line)
+		builder.WriteString("\n")
+	}
+
+	return builder.String()
+}
+
+// parseDiagnostics parses the output of `go build`, `go vet` or `go test` into a list of diagnostics.
+// Duplicated diagnostics are reported only once.
+func parseDiagnostics(output string) []diagnostic {
+	var diagnostics []diagnostic
+	seen := make(map[string]struct{})
+
+	scanner := bufio.NewScanner(strings.NewReader(buildOutputText(output)))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		matches := regDiagnosticLine.FindStringSubmatch(line)
+		if matches == nil {
+			continue
+		}
+
+		lineNumber, err := strconv.Atoi(matches[2])
+		if err != nil {
+			continue
+		}
+		col, _ := strconv.Atoi(matches[3])
+
+		d := diagnostic{
+			File:     matches[1],
+			Line:     lineNumber,
+			Col:      col,
+			Message:  matches[4],
+			Category: categorizeDiagnostic(matches[4]),
+		}
+
+		if _, exists := seen[d.String()]; exists {
+			continue
+		}
+		seen[d.String()] = struct{}{}
+		diagnostics = append(diagnostics, d)
+	}
+
+	return diagnostics
+}
+
+// categorizeDiagnostic returns the category of a compiler message.
+func categorizeDiagnostic(message string) diagnosticCategory {
+	switch {
+	case strings.HasPrefix(message, "undefined:"),
+		strings.Contains(message, "has no field or method"),
+		strings.Contains(message, "not declared by package"):
+		return diagnosticUndefined
+	case strings.HasPrefix(message, "declared and not used"),
+		strings.HasSuffix(message, "declared and not used"),
+		strings.HasSuffix(message, "declared but not used"):
+		return diagnosticUnusedVariable
+	case strings.Contains(message, "imported and not used"):
+		return diagnosticUnusedImport
+	case strings.HasPrefix(message, "cannot use"),
+		strings.Contains(message, "mismatched types"),
+		strings.Contains(message, "cannot convert"),
+		strings.Contains(message, "not enough arguments"),
+		strings.Contains(message, "too many arguments"),
+		strings.Contains(message, "not enough return values"),
+		strings.Contains(message, "too many return values"),
+		strings.Contains(message, "does not implement"):
+		return diagnosticTypeMismatch
+	case strings.Contains(message, "redeclared"):
+		return diagnosticRedeclared
+	case strings.HasPrefix(message, "syntax error"),
+		strings.HasPrefix(message, "expected "):
+		return diagnosticSyntax
+	default:
+		return diagnosticOther
+	}
+}
+
+// diagnosticFilePath returns the path of the file reported by a diagnostic, relative to the current directory.
+func (j *job) diagnosticFilePath(file string) string {
+	if filepath.IsAbs(file) {
+		return file
+	}
+	return filepath.Join(j.fileDir, file)
+}
+
+// extractDeclarationFromLine returns the name and the code of the declaration enclosing the line of a file.
+// If the line is outside any declaration, the 10 lines around it are returned.
+func (j *job) extractDeclarationFromLine(filePath string, lineNumber int) (string, string, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return "", "", fmt.Errorf(j.t("error reading file")+": %v", err)
+	}
+
+	fs := token.NewFileSet()
+	node, err := parser.ParseFile(fs, filePath, data, parser.ParseComments)
+	if err != nil && node == nil {
+		return "", "", fmt.Errorf(j.t("error parsing file")+": %v", err)
+	}
+
+	for _, decl := range node.Decls {
+		startLine := fs.Position(decl.Pos()).Line
+		endLine := fs.Position(decl.End()).Line
+		if lineNumber < startLine || lineNumber > endLine {
+			continue
+		}
+
+		var code bytes.Buffer
+		if err := printer.Fprint(&code, fs, decl); err != nil {
+			return "", "", fmt.Errorf(j.t("error printing function")+": %v", err)
+		}
+		return declarationName(decl), code.String(), nil
+	}
+
+	lines := bytes.Split(data, []byte("\n"))
+	startLine := max(0, lineNumber-10)
+	endLine := min(len(lines), lineNumber+10)
+
+	var surroundingCode bytes.Buffer
+	for i := startLine; i < endLine; i++ {
+		surroundingCode.Write(lines[i])
+		surroundingCode.WriteString("\n")
+	}
+
+	return fmt.Sprintf("%s:%d-%d", filepath.Base(filePath), startLine+1, endLine), surroundingCode.String(), nil
+}
+
+// declarationName returns a readable name for a top-level declaration.
+func declarationName(decl ast.Decl) string {
+	switch d := decl.(type) {
+	case *ast.FuncDecl:
+		if d.Recv != nil && len(d.Recv.List) > 0 {
+			return fmt.Sprintf("(%s) %s", exprToString(d.Recv.List[0].Type), d.Name.Name)
+		}
+		return d.Name.Name
+	case *ast.GenDecl:
+		var names []string
+		for _, spec := range d.Specs {
+			switch s := spec.(type) {
+			case *ast.TypeSpec:
+				names = append(names, s.Name.Name)
+			case *ast.ValueSpec:
+				for _, name := range s.Names {
+					names = append(names, name.Name)
+				}
+			case *ast.ImportSpec:
+				names = append(names, s.Path.Value)
+			}
+		}
+		return d.Tok.String() + " " + strings.Join(names, ", ")
+	default:
+		return fmt.Sprintf("%T", decl)
+	}
+}
+
+// diagnosticsForPrompt groups the diagnostics by enclosing declaration and returns the code
+// of each declaration followed by the errors it contains.
+func (j *job) diagnosticsForPrompt(diagnostics []diagnostic) (string, error) {
+	type declarationErrors struct {
+		file   string
+		name   string
+		code   string
+		errors []diagnostic
+	}
+
+	var order []string
+	declarations := make(map[string]*declarationErrors)
+
+	for _, d := range diagnostics {
+		filePath := j.diagnosticFilePath(d.File)
+		name, code, err := j.extractDeclarationFromLine(filePath, d.Line)
+		if err != nil {
+			return "", fmt.Errorf(j.t("error extracting function")+": %v", err)
+		}
+
+		key := filePath + "#" + name
+		decl, exists := declarations[key]
+		if !exists {
+			decl = &declarationErrors{file: d.File, name: name, code: code}
+			declarations[key] = decl
+			order = append(order, key)
+		}
+		decl.errors = append(decl.errors, d)
+	}
+
+	var builder strings.Builder
+	for _, key := range order {
+		decl := declarations[key]
+		sort.SliceStable(decl.errors, func(a, b int) bool {
+			return decl.errors[a].Line < decl.errors[b].Line
+		})
+
+		builder.WriteString(fmt.Sprintf("// %s (%s)\n", decl.name, decl.file))
+		builder.WriteString(decl.code)
+		builder.WriteString("\n\n")
+		for _, d := range decl.errors {
+			builder.WriteString(fmt.Sprintf("- [%s] %s\n", d.Category, d))
+		}
+		builder.WriteString("\n")
+	}
+
+	if ref := j.apiReferenceForDiagnostics(diagnostics); ref != "" {
+		builder.WriteString(ref + "\n")
+	}
+
+	return builder.String(), nil
+}
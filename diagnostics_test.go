@@ -0,0 +1,108 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseDiagnostics(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   []diagnostic
+	}{
+		{
+			name:   "no diagnostic",
+			output: "ok  \tgithub.com/ariden/goia\t0.010s\n",
+			want:   nil,
+		},
+		{
+			name: "every error of the build",
+			output: "# github.com/ariden/goia/a\n" +
+				"a/a.go:3:2: \"os\" imported and not used\n" +
+				"a/a.go:10:9: undefined: foo\n" +
+				"a/b.go:7:12: cannot use x (variable of type int) as string value in return statement\n",
+			want: []diagnostic{
+				{File: "a/a.go", Line: 3, Col: 2, Message: "\"os\" imported and not used", Category: diagnosticUnusedImport},
+				{File: "a/a.go", Line: 10, Col: 9, Message: "undefined: foo", Category: diagnosticUndefined},
+				{File: "a/b.go", Line: 7, Col: 12, Message: "cannot use x (variable of type int) as string value in return statement", Category: diagnosticTypeMismatch},
+			},
+		},
+		{
+			name:   "line without column and vet prefix",
+			output: "vet: a.go:4: x declared and not used\n",
+			want: []diagnostic{
+				{File: "a.go", Line: 4, Message: "x declared and not used", Category: diagnosticUnusedVariable},
+			},
+		},
+		{
+			name:   "duplicated diagnostics",
+			output: "a.go:1:1: syntax error: unexpected }\na.go:1:1: syntax error: unexpected }\n",
+			want: []diagnostic{
+				{File: "a.go", Line: 1, Col: 1, Message: "syntax error: unexpected }", Category: diagnosticSyntax},
+			},
+		},
+		{
+			name: "json build events",
+			output: `{"ImportPath":"p","Action":"build-output","Output":"# p\n"}` + "\n" +
+				`{"ImportPath":"p","Action":"build-output","Output":"./p.go:5:2: x redeclared in this block\n"}` + "\n" +
+				`{"ImportPath":"p","Action":"build-fail"}` + "\n",
+			want: []diagnostic{
+				{File: "./p.go", Line: 5, Col: 2, Message: "x redeclared in this block", Category: diagnosticRedeclared},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseDiagnostics(tt.output)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseDiagnostics() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCategorizeDiagnostic(t *testing.T) {
+	tests := []struct {
+		message string
+		want    diagnosticCategory
+	}{
+		{"undefined: foo", diagnosticUndefined},
+		{"x.Foo undefined (type T has no field or method Foo)", diagnosticUndefined},
+		{"declared and not used: x", diagnosticUnusedVariable},
+		{"\"fmt\" imported and not used", diagnosticUnusedImport},
+		{"not enough arguments in call to f", diagnosticTypeMismatch},
+		{"T does not implement I (missing method M)", diagnosticTypeMismatch},
+		{"f redeclared in this block", diagnosticRedeclared},
+		{"expected ';', found 'EOF'", diagnosticSyntax},
+		{"missing return", diagnosticOther},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.message, func(t *testing.T) {
+			if got := categorizeDiagnostic(tt.message); got != tt.want {
+				t.Errorf("categorizeDiagnostic(%q) = %q, want %q", tt.message, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiagnosticString(t *testing.T) {
+	tests := []struct {
+		name string
+		d    diagnostic
+		want string
+	}{
+		{"with column", diagnostic{File: "a.go", Line: 3, Col: 2, Message: "m"}, "a.go:3:2: m"},
+		{"without column", diagnostic{File: "a.go", Line: 3, Message: "m"}, "a.go:3: m"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.d.String(); got != tt.want {
+				t.Errorf("String() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
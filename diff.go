@@ -0,0 +1,478 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/fatih/color"
+)
+
+const (
+	// diffContext is the number of unchanged lines around the changes of a unified diff.
+	diffContext = 3
+	// sideBySideWidth is the default width of the side-by-side output, as for `diff -y`.
+	sideBySideWidth = 130
+)
+
+var (
+	redHighlight   = color.New(color.FgRed, color.Bold, color.ReverseVideo).SprintFunc()
+	greenHighlight = color.New(color.FgGreen, color.Bold, color.ReverseVideo).SprintFunc()
+)
+
+// diffEdit is an operation of an edit script: ' ' keeps the line old of a in the line new of b,
+// '-' deletes the line old of a and '+' inserts the line new of b.
+type diffEdit struct {
+	kind     byte
+	old, new int
+	text     string
+}
+
+// diffHunk is a group of edits with their context, as in a unified diff.
+type diffHunk struct {
+	oldStart, oldLines int
+	newStart, newLines int
+	edits              []diffEdit
+}
+
+// splitLines splits a text into lines ending with their newline, the last one may not have it.
+func splitLines(text string) []string {
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// myersDiff returns the shortest edit script turning a into b, computed with the Myers algorithm.
+// The line indexes of the edits are shifted by aOff and bOff.
+func myersDiff(a, b []string, aOff, bOff int) []diffEdit {
+	n, m := len(a), len(b)
+	limit := n + m
+	v := make([]int, 2*limit+2)
+
+	// trace[d] holds the furthest x reached on the diagonals -d..d before the round d.
+	var trace [][]int
+	found := false
+	for d := 0; d <= limit && !found; d++ {
+		trace = append(trace, append([]int(nil), v[limit-d:limit+d+1]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[limit+k-1] < v[limit+k+1]) {
+				x = v[limit+k+1]
+			} else {
+				x = v[limit+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[limit+k] = x
+			if x >= n && y >= m {
+				found = true
+				break
+			}
+		}
+	}
+
+	var edits []diffEdit
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		prev := func(k int) int { return trace[d][k+d] }
+		k := x - y
+
+		var prevK int
+		if k == -d || (k != d && prev(k-1) < prev(k+1)) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := 0
+		if d > 0 {
+			prevX = prev(prevK)
+		}
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			x--
+			y--
+			edits = append(edits, diffEdit{kind: ' ', old: aOff + x, new: bOff + y, text: a[x]})
+		}
+		if d > 0 {
+			if x == prevX {
+				edits = append(edits, diffEdit{kind: '+', old: aOff + x, new: bOff + prevY, text: b[prevY]})
+			} else {
+				edits = append(edits, diffEdit{kind: '-', old: aOff + prevX, new: bOff + y, text: a[prevX]})
+			}
+		}
+		x, y = prevX, prevY
+	}
+
+	for i, j := 0, len(edits)-1; i < j; i, j = i+1, j-1 {
+		edits[i], edits[j] = edits[j], edits[i]
+	}
+	return edits
+}
+
+// patienceDiff returns an edit script turning a into b, anchored on the lines that are unique in both,
+// which keeps the functions and the blocks of code aligned. The gaps between the anchors are diffed
+// with the Myers algorithm.
+func patienceDiff(a, b []string) []diffEdit {
+	return patienceRange(a, b, 0, len(a), 0, len(b))
+}
+
+// patienceRange diffs the lines a[aLo:aHi] and b[bLo:bHi].
+func patienceRange(a, b []string, aLo, aHi, bLo, bHi int) []diffEdit {
+	var edits []diffEdit
+	for aLo < aHi && bLo < bHi && a[aLo] == b[bLo] {
+		edits = append(edits, diffEdit{kind: ' ', old: aLo, new: bLo, text: a[aLo]})
+		aLo++
+		bLo++
+	}
+	var suffix []diffEdit
+	for aLo < aHi && bLo < bHi && a[aHi-1] == b[bHi-1] {
+		aHi--
+		bHi--
+		suffix = append([]diffEdit{{kind: ' ', old: aHi, new: bHi, text: a[aHi]}}, suffix...)
+	}
+
+	anchors := uniqueCommonLines(a, b, aLo, aHi, bLo, bHi)
+	if len(anchors) == 0 {
+		edits = append(edits, myersDiff(a[aLo:aHi], b[bLo:bHi], aLo, bLo)...)
+		return append(edits, suffix...)
+	}
+
+	for _, anchor := range anchors {
+		edits = append(edits, patienceRange(a, b, aLo, anchor[0], bLo, anchor[1])...)
+		edits = append(edits, diffEdit{kind: ' ', old: anchor[0], new: anchor[1], text: a[anchor[0]]})
+		aLo, bLo = anchor[0]+1, anchor[1]+1
+	}
+	edits = append(edits, patienceRange(a, b, aLo, aHi, bLo, bHi)...)
+	return append(edits, suffix...)
+}
+
+// uniqueCommonLines returns the longest increasing sequence of the pairs of lines that appear once
+// in both ranges, found with the patience sorting.
+func uniqueCommonLines(a, b []string, aLo, aHi, bLo, bHi int) [][2]int {
+	type occurrence struct {
+		countA, countB int
+		indexA, indexB int
+	}
+	lines := make(map[string]*occurrence)
+	for i := aLo; i < aHi; i++ {
+		o, ok := lines[a[i]]
+		if !ok {
+			o = &occurrence{}
+			lines[a[i]] = o
+		}
+		o.countA++
+		o.indexA = i
+	}
+	for i := bLo; i < bHi; i++ {
+		if o, ok := lines[b[i]]; ok {
+			o.countB++
+			o.indexB = i
+		}
+	}
+
+	var pairs [][2]int
+	for i := aLo; i < aHi; i++ {
+		if o := lines[a[i]]; o.countA == 1 && o.countB == 1 {
+			pairs = append(pairs, [2]int{o.indexA, o.indexB})
+		}
+	}
+	if len(pairs) == 0 {
+		return nil
+	}
+
+	// Each pile keeps the index of its top pair, and each pair the top of the previous pile.
+	var piles []int
+	back := make([]int, len(pairs))
+	for i, pair := range pairs {
+		lo, hi := 0, len(piles)
+		for lo < hi {
+			mid := (lo + hi) / 2
+			if pairs[piles[mid]][1] < pair[1] {
+				lo = mid + 1
+			} else {
+				hi = mid
+			}
+		}
+		back[i] = -1
+		if lo > 0 {
+			back[i] = piles[lo-1]
+		}
+		if lo == len(piles) {
+			piles = append(piles, i)
+		} else {
+			piles[lo] = i
+		}
+	}
+
+	sequence := make([][2]int, len(piles))
+	for i, k := len(piles)-1, piles[len(piles)-1]; k >= 0; i, k = i-1, back[k] {
+		sequence[i] = pairs[k]
+	}
+	return sequence
+}
+
+// diffHunks groups the edits of a script into hunks with context lines around the changes.
+func diffHunks(edits []diffEdit, context int) []diffHunk {
+	var hunks []diffHunk
+	for i := 0; i < len(edits); {
+		if edits[i].kind == ' ' {
+			i++
+			continue
+		}
+
+		start := max(i-context, 0)
+		end := i
+		for end < len(edits) {
+			if edits[end].kind != ' ' {
+				end++
+				continue
+			}
+			// The hunk goes on when the next change is close enough to share its context.
+			next := end
+			for next < len(edits) && edits[next].kind == ' ' {
+				next++
+			}
+			if next == len(edits) || next-end > 2*context {
+				end = min(end+context, len(edits))
+				break
+			}
+			end = next
+		}
+
+		hunk := diffHunk{edits: edits[start:end]}
+		hunk.oldStart, hunk.newStart = edits[start].old+1, edits[start].new+1
+		for _, edit := range hunk.edits {
+			if edit.kind != '+' {
+				hunk.oldLines++
+			}
+			if edit.kind != '-' {
+				hunk.newLines++
+			}
+		}
+		// An empty side starts at the line before, as in diff -u.
+		if hunk.oldLines == 0 {
+			hunk.oldStart--
+		}
+		if hunk.newLines == 0 {
+			hunk.newStart--
+		}
+		hunks = append(hunks, hunk)
+		i = end
+	}
+	return hunks
+}
+
+// header returns the header line of a hunk.
+func (h diffHunk) header() string {
+	span := func(start, lines int) string {
+		if lines == 1 {
+			return strconv.Itoa(start)
+		}
+		return fmt.Sprintf("%d,%d", start, lines)
+	}
+	return fmt.Sprintf("@@ -%s +%s @@", span(h.oldStart, h.oldLines), span(h.newStart, h.newLines))
+}
+
+// lineDiff returns the edit script between two texts, line by line.
+func lineDiff(oldText, newText string) []diffEdit {
+	return patienceDiff(splitLines(oldText), splitLines(newText))
+}
+
+// unifiedDiff returns the unified diff between two texts, without colors.
+func unifiedDiff(oldName, newName, oldText, newText string) string {
+	hunks := diffHunks(lineDiff(oldText, newText), diffContext)
+	if len(hunks) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "--- %s\n+++ %s\n", oldName, newName)
+	for _, hunk := range hunks {
+		b.WriteString(hunk.header() + "\n")
+		for _, edit := range hunk.edits {
+			b.WriteByte(edit.kind)
+			writeDiffLine(&b, edit.text)
+		}
+	}
+	return b.String()
+}
+
+// writeDiffLine writes a line of a diff, with the marker of the missing newline at the end of a file.
+func writeDiffLine(b *strings.Builder, line string) {
+	if strings.HasSuffix(line, "\n") {
+		b.WriteString(line)
+		return
+	}
+	b.WriteString(line + "\n\\ No newline at end of file\n")
+}
+
+// colorDiff returns the hunks of the diff between two texts with colors. The changed words of the
+// lines replaced one by one are highlighted.
+func colorDiff(oldText, newText string) string {
+	var b strings.Builder
+	for _, hunk := range diffHunks(lineDiff(oldText, newText), diffContext) {
+		b.WriteString(magenta(hunk.header()) + "\n")
+		writeColorEdits(&b, hunk.edits)
+	}
+	return b.String()
+}
+
+// writeColorEdits writes edits with colors, highlighting the words changed between a deleted line and
+// the inserted line that replaces it.
+func writeColorEdits(b *strings.Builder, edits []diffEdit) {
+	for i := 0; i < len(edits); {
+		if edits[i].kind == ' ' {
+			b.WriteString(" " + strings.TrimSuffix(edits[i].text, "\n") + "\n")
+			i++
+			continue
+		}
+
+		var deleted, inserted []string
+		for ; i < len(edits) && edits[i].kind == '-'; i++ {
+			deleted = append(deleted, strings.TrimSuffix(edits[i].text, "\n"))
+		}
+		for ; i < len(edits) && edits[i].kind == '+'; i++ {
+			inserted = append(inserted, strings.TrimSuffix(edits[i].text, "\n"))
+		}
+
+		if len(deleted) == len(inserted) {
+			for k := range deleted {
+				oldLine, newLine := wordDiff(deleted[k], inserted[k])
+				b.WriteString(red("-") + oldLine + "\n")
+				inserted[k] = newLine
+			}
+			for _, line := range inserted {
+				b.WriteString(green("+") + line + "\n")
+			}
+			continue
+		}
+
+		for _, line := range deleted {
+			b.WriteString(red("-"+line) + "\n")
+		}
+		for _, line := range inserted {
+			b.WriteString(green("+"+line) + "\n")
+		}
+	}
+}
+
+// diffTokens splits a line into words, runs of spaces and punctuation characters.
+func diffTokens(line string) []string {
+	var tokens []string
+	class := func(r rune) int {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_':
+			return 1
+		case unicode.IsSpace(r):
+			return 2
+		}
+		return 0
+	}
+
+	start := 0
+	for i, r := range line {
+		if i > start {
+			prev, _ := utf8.DecodeLastRuneInString(line[:i])
+			if class(r) == 0 || class(r) != class(prev) {
+				tokens = append(tokens, line[start:i])
+				start = i
+			}
+		}
+	}
+	if start < len(line) {
+		tokens = append(tokens, line[start:])
+	}
+	return tokens
+}
+
+// wordDiff returns the two versions of a line with colors, the changed words being highlighted.
+func wordDiff(oldLine, newLine string) (string, string) {
+	// The consecutive tokens of the same kind are colored together.
+	var edits []diffEdit
+	for _, edit := range myersDiff(diffTokens(oldLine), diffTokens(newLine), 0, 0) {
+		if n := len(edits); n > 0 && edits[n-1].kind == edit.kind {
+			edits[n-1].text += edit.text
+			continue
+		}
+		edits = append(edits, edit)
+	}
+
+	var oldOut, newOut strings.Builder
+	for _, edit := range edits {
+		switch edit.kind {
+		case ' ':
+			oldOut.WriteString(red(edit.text))
+			newOut.WriteString(green(edit.text))
+		case '-':
+			oldOut.WriteString(redHighlight(edit.text))
+		case '+':
+			newOut.WriteString(greenHighlight(edit.text))
+		}
+	}
+	return oldOut.String(), newOut.String()
+}
+
+// sideBySideDiff returns the hunks of the diff between two texts in two columns, as `diff -y`:
+// the changed lines are marked with |, the deleted ones with < and the inserted ones with >.
+func sideBySideDiff(oldText, newText string, width int) string {
+	column := (width - 3) / 2
+
+	cell := func(text string) string {
+		text = strings.ReplaceAll(strings.TrimSuffix(text, "\n"), "\t", "    ")
+		if utf8.RuneCountInString(text) > column {
+			text = string([]rune(text)[:column])
+		}
+		return text + strings.Repeat(" ", column-utf8.RuneCountInString(text))
+	}
+
+	var b strings.Builder
+	for _, hunk := range diffHunks(lineDiff(oldText, newText), diffContext) {
+		b.WriteString(magenta(hunk.header()) + "\n")
+
+		edits := hunk.edits
+		for i := 0; i < len(edits); {
+			if edits[i].kind == ' ' {
+				b.WriteString(cell(edits[i].text) + "   " + strings.TrimRight(cell(edits[i].text), " ") + "\n")
+				i++
+				continue
+			}
+
+			var deleted, inserted []string
+			for ; i < len(edits) && edits[i].kind == '-'; i++ {
+				deleted = append(deleted, edits[i].text)
+			}
+			for ; i < len(edits) && edits[i].kind == '+'; i++ {
+				inserted = append(inserted, edits[i].text)
+			}
+
+			for k := 0; k < max(len(deleted), len(inserted)); k++ {
+				switch {
+				case k < len(deleted) && k < len(inserted):
+					b.WriteString(red(cell(deleted[k])) + " " + blue("|") + " " + green(strings.TrimRight(cell(inserted[k]), " ")) + "\n")
+				case k < len(deleted):
+					b.WriteString(red(cell(deleted[k])) + " " + red("<") + "\n")
+				default:
+					b.WriteString(strings.Repeat(" ", column) + " " + green(">") + " " + green(strings.TrimRight(cell(inserted[k]), " ")) + "\n")
+				}
+			}
+		}
+	}
+	return b.String()
+}
+
+// terminalWidth returns the width of the terminal from the COLUMNS variable, or the width of `diff -y`.
+func terminalWidth() int {
+	if columns, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && columns > 20 {
+		return columns
+	}
+	return sideBySideWidth
+}
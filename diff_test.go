@@ -0,0 +1,375 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/fatih/color"
+)
+
+// replayDiffEdits returns the two sequences described by an edit script.
+func replayDiffEdits(edits []diffEdit) (a, b []string) {
+	for _, edit := range edits {
+		if edit.kind != '+' {
+			a = append(a, edit.text)
+		}
+		if edit.kind != '-' {
+			b = append(b, edit.text)
+		}
+	}
+	return a, b
+}
+
+// changedDiffEdits returns the number of deletions and insertions of an edit script.
+func changedDiffEdits(edits []diffEdit) int {
+	n := 0
+	for _, edit := range edits {
+		if edit.kind != ' ' {
+			n++
+		}
+	}
+	return n
+}
+
+// setNoColor sets color.NoColor for the duration of a test.
+func setNoColor(t *testing.T, noColor bool) {
+	t.Helper()
+	previous := color.NoColor
+	color.NoColor = noColor
+	t.Cleanup(func() { color.NoColor = previous })
+}
+
+func TestSplitLines(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []string
+	}{
+		{"empty", "", nil},
+		{"one line", "a\n", []string{"a\n"}},
+		{"without final newline", "a\nb", []string{"a\n", "b"}},
+		{"empty lines", "\n\n", []string{"\n", "\n"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := splitLines(tt.text)
+			if len(got) == 0 && len(tt.want) == 0 {
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitLines() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMyersDiff(t *testing.T) {
+	tests := []struct {
+		name        string
+		a, b        string
+		wantChanged int
+	}{
+		{"equal", "abc", "abc", 0},
+		{"empty", "", "", 0},
+		{"all inserted", "", "abc", 3},
+		{"all deleted", "abc", "", 3},
+		{"one replaced", "abc", "axc", 2},
+		{"classic example", "abcabba", "cbabac", 5},
+		{"nothing in common", "abc", "xyz", 6},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := strings.Split(tt.a, ""), strings.Split(tt.b, "")
+			if tt.a == "" {
+				a = nil
+			}
+			if tt.b == "" {
+				b = nil
+			}
+
+			edits := myersDiff(a, b, 0, 0)
+			gotA, gotB := replayDiffEdits(edits)
+			if strings.Join(gotA, "") != tt.a || strings.Join(gotB, "") != tt.b {
+				t.Errorf("myersDiff() replays %q, %q, want %q, %q", gotA, gotB, tt.a, tt.b)
+			}
+			if got := changedDiffEdits(edits); got != tt.wantChanged {
+				t.Errorf("myersDiff() changes %d lines, want %d", got, tt.wantChanged)
+			}
+			for _, edit := range edits {
+				if (edit.kind != '+' && a[edit.old] != edit.text) || (edit.kind != '-' && b[edit.new] != edit.text) {
+					t.Errorf("myersDiff() edit %+v does not point to its line", edit)
+				}
+			}
+		})
+	}
+}
+
+func TestPatienceDiff(t *testing.T) {
+	tests := []struct {
+		name    string
+		a, b    []string
+		wantOps string
+	}{
+		{
+			name:    "equal",
+			a:       []string{"a\n", "b\n"},
+			b:       []string{"a\n", "b\n"},
+			wantOps: "  ",
+		},
+		{
+			name:    "line inserted in the middle",
+			a:       []string{"a\n", "b\n"},
+			b:       []string{"a\n", "x\n", "b\n"},
+			wantOps: " + ",
+		},
+		{
+			// The unique lines stay aligned, the braces shared by both functions do not.
+			name:    "function added before another one",
+			a:       []string{"func A() {\n", "}\n"},
+			b:       []string{"func B() {\n", "}\n", "\n", "func A() {\n", "}\n"},
+			wantOps: "+++  ",
+		},
+		{
+			name:    "lines moved",
+			a:       []string{"a\n", "b\n", "c\n"},
+			b:       []string{"c\n", "a\n", "b\n"},
+			wantOps: "+  -",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			edits := patienceDiff(tt.a, tt.b)
+			gotA, gotB := replayDiffEdits(edits)
+			if !reflect.DeepEqual(gotA, tt.a) || !reflect.DeepEqual(gotB, tt.b) {
+				t.Errorf("patienceDiff() replays %q, %q, want %q, %q", gotA, gotB, tt.a, tt.b)
+			}
+
+			var ops strings.Builder
+			for _, edit := range edits {
+				ops.WriteByte(edit.kind)
+			}
+			if ops.String() != tt.wantOps {
+				t.Errorf("patienceDiff() = %q, want %q", ops.String(), tt.wantOps)
+			}
+		})
+	}
+}
+
+func TestDiffHunks(t *testing.T) {
+	lines := func(n int, changed map[int]string) (string, string) {
+		var oldText, newText strings.Builder
+		for i := 1; i <= n; i++ {
+			line := strings.Repeat("l", i) + "\n"
+			oldText.WriteString(line)
+			if text, ok := changed[i]; ok {
+				newText.WriteString(text)
+				continue
+			}
+			newText.WriteString(line)
+		}
+		return oldText.String(), newText.String()
+	}
+
+	tests := []struct {
+		name    string
+		n       int
+		changed map[int]string
+		want    []string
+	}{
+		{"no change", 5, nil, nil},
+		{"line replaced", 10, map[int]string{5: "x\n"}, []string{"@@ -2,7 +2,7 @@"}},
+		{"line deleted at the start", 5, map[int]string{1: ""}, []string{"@@ -1,4 +1,3 @@"}},
+		{"line inserted at the end", 2, map[int]string{2: "ll\nx\n"}, []string{"@@ -1,2 +1,3 @@"}},
+		{"close changes", 20, map[int]string{5: "x\n", 11: "y\n"}, []string{"@@ -2,13 +2,13 @@"}},
+		{"distant changes", 20, map[int]string{5: "x\n", 15: "y\n"}, []string{"@@ -2,7 +2,7 @@", "@@ -12,7 +12,7 @@"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			oldText, newText := lines(tt.n, tt.changed)
+			var got []string
+			for _, hunk := range diffHunks(lineDiff(oldText, newText), diffContext) {
+				got = append(got, hunk.header())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("diffHunks() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiffHunkHeader(t *testing.T) {
+	tests := []struct {
+		name string
+		hunk diffHunk
+		want string
+	}{
+		{"several lines", diffHunk{oldStart: 3, oldLines: 4, newStart: 3, newLines: 5}, "@@ -3,4 +3,5 @@"},
+		{"one line", diffHunk{oldStart: 1, oldLines: 1, newStart: 1, newLines: 1}, "@@ -1 +1 @@"},
+		{"empty side", diffHunk{oldStart: 0, oldLines: 0, newStart: 1, newLines: 2}, "@@ -0,0 +1,2 @@"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.hunk.header(); got != tt.want {
+				t.Errorf("header() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		name             string
+		oldText, newText string
+		want             string
+	}{
+		{
+			name:    "equal",
+			oldText: "a\nb\n",
+			newText: "a\nb\n",
+			want:    "",
+		},
+		{
+			name:    "line replaced",
+			oldText: "a\nb\nc\n",
+			newText: "a\nx\nc\n",
+			want:    "--- f.orig\n+++ f\n@@ -1,3 +1,3 @@\n a\n-b\n+x\n c\n",
+		},
+		{
+			name:    "new file",
+			oldText: "",
+			newText: "a\n",
+			want:    "--- f.orig\n+++ f\n@@ -0,0 +1 @@\n+a\n",
+		},
+		{
+			name:    "without final newline",
+			oldText: "a\nb",
+			newText: "a\nb\n",
+			want:    "--- f.orig\n+++ f\n@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+b\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := unifiedDiff("f.orig", "f", tt.oldText, tt.newText); got != tt.want {
+				t.Errorf("unifiedDiff() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiffTokens(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		want []string
+	}{
+		{"empty", "", nil},
+		{"words and spaces", "return a  + b_1", []string{"return", " ", "a", "  ", "+", " ", "b_1"}},
+		{"punctuation", "f(x)", []string{"f", "(", "x", ")"}},
+		{"unicode", "é := \"à\"", []string{"é", " ", ":", "=", " ", "\"", "à", "\""}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := diffTokens(tt.line); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("diffTokens() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWordDiff(t *testing.T) {
+	setNoColor(t, false)
+
+	tests := []struct {
+		name             string
+		oldLine, newLine string
+		wantOld, wantNew string
+	}{
+		{
+			name:    "equal",
+			oldLine: "return a",
+			newLine: "return a",
+			wantOld: red("return a"),
+			wantNew: green("return a"),
+		},
+		{
+			name:    "word replaced",
+			oldLine: "return a + b",
+			newLine: "return a - b",
+			wantOld: red("return a ") + redHighlight("+") + red(" b"),
+			wantNew: green("return a ") + greenHighlight("-") + green(" b"),
+		},
+		{
+			name:    "words added",
+			oldLine: "f(x)",
+			newLine: "f(x, y)",
+			wantOld: red("f(x") + red(")"),
+			wantNew: green("f(x") + greenHighlight(", y") + green(")"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotOld, gotNew := wordDiff(tt.oldLine, tt.newLine)
+			if gotOld != tt.wantOld || gotNew != tt.wantNew {
+				t.Errorf("wordDiff() = %q, %q, want %q, %q", gotOld, gotNew, tt.wantOld, tt.wantNew)
+			}
+		})
+	}
+}
+
+func TestSideBySideDiff(t *testing.T) {
+	setNoColor(t, true)
+
+	tests := []struct {
+		name             string
+		oldText, newText string
+		want             string
+	}{
+		{
+			name:    "equal",
+			oldText: "a\n",
+			newText: "a\n",
+			want:    "",
+		},
+		{
+			name:    "line replaced",
+			oldText: "a\nb\n",
+			newText: "a\nc\n",
+			want:    "@@ -1,2 +1,2 @@\na            a\nb          | c\n",
+		},
+		{
+			name:    "lines deleted and inserted",
+			oldText: "a\nb\nc\n",
+			newText: "x\ny\nz\nw\n",
+			want:    "@@ -1,3 +1,4 @@\na          | x\nb          | y\nc          | z\n           > w\n",
+		},
+		{
+			name:    "line deleted",
+			oldText: "a\nb\n",
+			newText: "a\n",
+			want:    "@@ -1,2 +1 @@\na            a\nb          <\n",
+		},
+		{
+			name:    "long line cut",
+			oldText: "abcdefghijklmnop\n",
+			newText: "\tx\n",
+			want:    "@@ -1 +1 @@\nabcdefghij |     x\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sideBySideDiff(tt.oldText, tt.newText, 23); got != tt.want {
+				t.Errorf("sideBySideDiff() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
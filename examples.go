@@ -0,0 +1,326 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/doc"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// exampleFileName is the file where the examples of a package are written, as in the standard library.
+const exampleFileName = "example_test.go"
+
+// runExamplesCommand implements `goia examples [path]`.
+func runExamplesCommand(args *appArgs, cmdArgs []string) error {
+	flags := flag.NewFlagSet("examples", flag.ExitOnError)
+	if err := flags.Parse(cmdArgs); err != nil {
+		return err
+	}
+
+	path := "."
+	if flags.NArg() > 0 {
+		path = flags.Arg(0)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		path = filepath.Dir(path)
+	}
+
+	j, err := newCommandJob(args, path)
+	if err != nil {
+		return err
+	}
+	defer j.closeSandbox()
+
+	absDir, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+
+	return j.generateExamples(absDir)
+}
+
+// exampleNames returns the names of the examples of a declaration, without the Example prefix,
+// or none if the declaration is not part of the exported API.
+func exampleNames(decl ast.Decl) []string {
+	switch d := decl.(type) {
+	case *ast.FuncDecl:
+		if !d.Name.IsExported() {
+			return nil
+		}
+		if d.Recv == nil || len(d.Recv.List) == 0 {
+			if strings.HasPrefix(d.Name.Name, "Test") || strings.HasPrefix(d.Name.Name, "Benchmark") {
+				return nil
+			}
+			return []string{d.Name.Name}
+		}
+
+		if recvType := receiverTypeName(d.Recv); ast.IsExported(recvType) {
+			return []string{recvType + "_" + d.Name.Name}
+		}
+
+		return nil
+
+	case *ast.GenDecl:
+		var names []string
+		for _, spec := range d.Specs {
+			if typeSpec, ok := spec.(*ast.TypeSpec); ok && typeSpec.Name.IsExported() {
+				names = append(names, typeSpec.Name.Name)
+			}
+		}
+		return names
+	}
+	return nil
+}
+
+// exampleSubject returns the name documented by an example, without its lowercase suffix.
+func exampleSubject(name string) string {
+	if i := strings.LastIndex(name, "_"); i >= 0 && i+1 < len(name) && unicode.IsLower(rune(name[i+1])) {
+		return name[:i]
+	}
+	return name
+}
+
+// packageExamples returns the examples of the test files of a folder.
+func packageExamples(absDir string) ([]*doc.Example, error) {
+	matches, err := filepath.Glob(filepath.Join(absDir, "*_test.go"))
+	if err != nil {
+		return nil, err
+	}
+
+	fs := token.NewFileSet()
+	var files []*ast.File
+	for _, match := range matches {
+		node, err := parser.ParseFile(fs, match, nil, parser.ParseComments)
+		if err != nil {
+			continue
+		}
+		files = append(files, node)
+	}
+	return doc.Examples(files...), nil
+}
+
+// undocumentedAPI returns the exported declarations of the folder that have no example.
+func (j *job) undocumentedAPI(absDir string) ([]string, error) {
+	sourceFiles, err := goSourceFiles(absDir)
+	if err != nil {
+		return nil, err
+	}
+
+	examples, err := packageExamples(absDir)
+	if err != nil {
+		return nil, err
+	}
+	documented := make(map[string]bool)
+	for _, example := range examples {
+		documented[exampleSubject(example.Name)] = true
+	}
+
+	var names []string
+	for _, path := range sourceFiles {
+		node, err := parser.ParseFile(token.NewFileSet(), path, nil, 0)
+		if err != nil {
+			return nil, err
+		}
+		if node.Name.Name == "main" {
+			return nil, errors.New(j.t("the examples are only rendered for library packages, not for main packages"))
+		}
+
+		for _, decl := range node.Decls {
+			for _, name := range exampleNames(decl) {
+				if !documented[name] {
+					names = append(names, name)
+				}
+			}
+		}
+	}
+
+	sort.Strings(names)
+	return names, nil
+}
+
+// createExampleFile creates the example file of a folder in the external test package.
+func (j *job) createExampleFile(absDir string) (string, error) {
+	path := filepath.Join(absDir, exampleFileName)
+	rel, err := filepath.Rel(j.fileDir, path)
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(path); err == nil {
+		return rel, nil
+	}
+
+	pkgName := j.packageNameForFile(strings.TrimSuffix(rel, "_test.go") + ".go")
+	content := fmt.Sprintf("package %s_test\n\n", pkgName)
+	return rel, os.WriteFile(path, []byte(content), 0o644)
+}
+
+// getPromptToAskExamples returns a prompt asking for the examples of the given declarations.
+func (j *job) getPromptToAskExamples(absDir string, names []string) (string, error) {
+	sourceFiles, err := goSourceFiles(absDir)
+	if err != nil {
+		return "", err
+	}
+
+	var sources strings.Builder
+	for _, path := range sourceFiles {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		rel, err := filepath.Rel(j.fileDir, path)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&sources, "**%s**\n```go\n%s\n```\n\n", rel, data)
+	}
+
+	pkgName := j.packageNameForFile(j.currentTestFileName)
+	if node, err := parser.ParseFile(token.NewFileSet(), filepath.Join(j.fileDir, j.currentTestFileName), nil, parser.PackageClauseOnly); err == nil {
+		pkgName = node.Name.Name
+	}
+
+	var list strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&list, "- Example%s\n", name)
+	}
+
+	return j.t("Here is the code of a Go package") + " :\n\n" + sources.String() +
+		fmt.Sprintf(j.t("Write the following godoc examples in the file %s, in the package %s, importing the package %s"),
+			j.currentTestFileName, pkgName, j.packageImportPathOf(j.currentTestFileName)) + ":\n\n" + list.String() + "\n" +
+		j.t("Each example shows a typical use of the API, prints its results with fmt and ends with a // Output: comment containing the exact printed output") + ". " +
+		j.t("The output must be deterministic: do not print maps, times, pointers or random values") + ". " +
+		j.t("Do not modify the source files") + ".\n\n" +
+		j.t("Strictly use the following format for each part") + ": `**<folder/file.go>** <code ici>`.\n\n" +
+		j.t("Reply without comment or explanation"), nil
+}
+
+// generateExamples asks the model for the examples of the exported API of a folder and repairs
+// them until their recorded output matches the output of `go test -run Example`.
+func (j *job) generateExamples(absDir string) error {
+	names, err := j.undocumentedAPI(absDir)
+	if err != nil {
+		return err
+	}
+	if len(names) == 0 {
+		log.Info(j.t("The exported API already has examples"))
+		return nil
+	}
+
+	relDir, err := filepath.Rel(j.fileDir, absDir)
+	if err != nil {
+		return err
+	}
+
+	if j.currentTestFileName, err = j.createExampleFile(absDir); err != nil {
+		return err
+	}
+	if err := j.loadCurrentFiles(); err != nil {
+		return err
+	}
+
+	// The examples document the code as it is.
+	sourceFiles, err := goSourceFiles(absDir)
+	if err != nil {
+		return err
+	}
+	j.frozenFiles = make(map[string]bool)
+	for _, path := range sourceFiles {
+		if rel, err := filepath.Rel(j.fileDir, path); err == nil {
+			j.frozenFiles[rel] = true
+		}
+	}
+	defer func() { j.frozenFiles = nil }()
+
+	prompt, err := j.getPromptToAskExamples(absDir, names)
+	if err != nil {
+		return err
+	}
+
+	check := func() (string, error) {
+		if prompt, err := j.compileTestsPrompt(); err != nil || prompt != "" {
+			return prompt, err
+		}
+
+		examples, err := packageExamples(absDir)
+		if err != nil {
+			return "", err
+		}
+
+		wanted := make(map[string]bool)
+		for _, name := range names {
+			wanted[name] = true
+		}
+
+		var run, missing, withoutOutput []string
+		for _, example := range examples {
+			if !wanted[exampleSubject(example.Name)] {
+				continue
+			}
+			delete(wanted, exampleSubject(example.Name))
+			run = append(run, "Example"+example.Name)
+			if example.Output == "" && !example.EmptyOutput {
+				withoutOutput = append(withoutOutput, "Example"+example.Name)
+			}
+		}
+		for name := range wanted {
+			missing = append(missing, "Example"+name)
+		}
+		sort.Strings(missing)
+
+		var problems []string
+		if len(missing) > 0 {
+			problems = append(problems, j.t("The following examples are missing")+": "+strings.Join(missing, ", "))
+		}
+		if len(withoutOutput) > 0 {
+			problems = append(problems, j.t("The following examples have no // Output: comment, so they are not run")+": "+strings.Join(withoutOutput, ", "))
+		}
+
+		var output string
+		if len(run) > 0 {
+			result, err := j.runCommand(nil, nil, "go", "test", "-count=1", "-run", failedTestsRunPattern(run), "./"+filepath.ToSlash(relDir))
+			if err != nil {
+				if result == nil {
+					return "", err
+				}
+				output = result.Output()
+				problems = append(problems, j.t("The output of the following examples does not match their // Output: comment"))
+			}
+		}
+
+		if len(problems) == 0 {
+			log.Infof(j.t("Examples verified")+": %s", strings.Join(run, ", "))
+			return "", nil
+		}
+
+		if err := j.loadCurrentFiles(); err != nil {
+			return "", err
+		}
+
+		repair := strings.Join(problems, ".\n") + ".\n\n"
+		if output != "" {
+			repair += j.t("Error") + " : " + output + "\n\n"
+		}
+		return repair +
+			fmt.Sprintf(j.t("Here is the file %s"), j.currentTestFileName) + " :\n\n" + string(j.currentSrcTest) + "\n\n" +
+			j.t("Fix the examples so that each // Output: comment contains the exact output printed by the example, without modifying the source files") + ".\n\n" +
+			j.t("Strictly use the following format for each part") + ": `**<folder/file.go>** <code ici>`.\n\n" +
+			j.t("Reply without comment or explanation"), nil
+	}
+
+	return j.repairLoop(prompt, check)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExampleSubject(t *testing.T) {
+	tests := map[string]string{
+		"":                    "",
+		"Parse":               "Parse",
+		"Parse_second":        "Parse",
+		"Parser_Next":         "Parser_Next",
+		"Parser_Next_withEOF": "Parser_Next",
+		"Parse_":              "Parse_",
+	}
+
+	for name, want := range tests {
+		if got := exampleSubject(name); got != want {
+			t.Errorf("exampleSubject(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestUndocumentedAPI(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   map[string]string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "exported declarations without example",
+			files: map[string]string{
+				"p/p.go": "package p\n\n" +
+					"type Parser struct{}\ntype state int\n" +
+					"func Parse() {}\nfunc (p *Parser) Next() {}\nfunc (s state) Next() {}\nfunc helper() {}\n" +
+					"func TestLike() {}\n",
+				"p/example_test.go": "package p_test\n\n" +
+					"func ExampleParse() {}\nfunc ExampleParser_Next_eof() {}\n",
+			},
+			want: []string{"Parser"},
+		},
+		{
+			name:    "main package",
+			files:   map[string]string{"p/main.go": "package main\n\nfunc Run() {}\n"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := writeModule(t, tt.files)
+			j := &job{fileDir: dir}
+
+			got, err := j.undocumentedAPI(dir + "/p")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("undocumentedAPI() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("undocumentedAPI() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
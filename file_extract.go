@@ -2,13 +2,11 @@ package main
 
 import (
 	"bufio"
-	"bytes"
 	"fmt"
 	"go/ast"
-	"go/parser"
-	"go/printer"
-	"go/token"
+	"go/types"
 	"io/ioutil"
+	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
@@ -27,7 +25,7 @@ func (j *job) isTestFile(testFileName string) bool {
 	return strings.HasSuffix(testFileName, "_test.go")
 }
 
-var regFindNameAndCode = regexp.MustCompile("\\*\\*(.*?)\\*\\*\\s```go\\s*(?s)(.*?)\\s*```")
+var regFindNameAndCode = regexp.MustCompile("\\*\\*(.*?)\\*\\*\\s```(?:go|diff)\\s*(?s)(.*?)\\s*```")
 
 func (j *job) splitFilesAndCode(response string) map[string]string {
 	var filesNameAndCode = map[string]string{}
@@ -116,6 +114,9 @@ func (j *job) splitFileNameAndCode(response string) (fileName string, code strin
 // extractBackticks extracts Go code from a string surrounded by backticks.
 func (j *job) extractBackticks(code string) string {
 	// Si la chaîne commence et se termine par des backticks, on les supprime.
+	if strings.HasPrefix(code, "```diff") && strings.HasSuffix(code, "```") {
+		return code[7 : len(code)-3]
+	}
 	if strings.HasPrefix(code, "```go") && strings.HasSuffix(code, "```") {
 		// Supprimer les backticks au début et à la fin
 		return code[5 : len(code)-3]
@@ -128,160 +129,6 @@ func (j *job) extractBackticks(code string) string {
 	return code
 }
 
-// extractFunctionsFromCode extracts function declarations from Go code as a string.
-func (j *job) extractFunctionsFromCode(code string) ([]*ast.FuncDecl, error) {
-
-	if !strings.HasPrefix(code, "package") {
-		// Ajouter "package main" au début du code
-		code = "package main\n\nimport \"fmt\"\n\n" + code
-	}
-
-	fs := token.NewFileSet()
-	node, err := parser.ParseFile(fs, "", code, parser.ParseComments)
-	if err != nil {
-		return nil, fmt.Errorf(j.t("error parsing functions from code")+": %v", err)
-	}
-
-	var funcs []*ast.FuncDecl
-	for _, decl := range node.Decls {
-		funcDecl, ok := decl.(*ast.FuncDecl)
-		if ok {
-			funcs = append(funcs, funcDecl)
-		}
-	}
-	return funcs, nil
-}
-
-// extractStructsFromCode extracts struct declarations from Go code as a string.
-func (j *job) extractStructsFromCode(code string) ([]*ast.TypeSpec, error) {
-
-	if !strings.HasPrefix(code, "package") {
-		// Ajouter "package main" au début du code
-		code = "package main\n\nimport \"fmt\"\n\n" + code
-	}
-
-	fs := token.NewFileSet()
-	node, err := parser.ParseFile(fs, "", code, parser.ParseComments)
-	if err != nil {
-		return nil, fmt.Errorf(j.t("error parsing structs from code")+": %v", err)
-	}
-
-	var structs []*ast.TypeSpec
-	for _, decl := range node.Decls {
-		genDecl, ok := decl.(*ast.GenDecl)
-		if ok {
-			for _, spec := range genDecl.Specs {
-				typeSpec, ok := spec.(*ast.TypeSpec)
-				if ok {
-					if _, isStruct := typeSpec.Type.(*ast.StructType); isStruct {
-						structs = append(structs, typeSpec)
-					}
-				}
-			}
-		}
-	}
-	return structs, nil
-}
-
-// extractInterfacesFromCode extracts interface declarations from Go code as a string.
-func (j *job) extractInterfacesFromCode(code string) ([]*ast.TypeSpec, error) {
-	if !strings.HasPrefix(code, "package") {
-		// Ajouter "package main" au début du code
-		code = "package main\n\nimport \"fmt\"\n\n" + code
-	}
-
-	fs := token.NewFileSet()
-	node, err := parser.ParseFile(fs, "", code, parser.ParseComments)
-	if err != nil {
-		return nil, fmt.Errorf(j.t("error parsing interfaces from code : ")+red(code)+": %v", err)
-	}
-
-	var interfaces []*ast.TypeSpec
-	for _, decl := range node.Decls {
-		genDecl, ok := decl.(*ast.GenDecl)
-		if ok {
-			for _, spec := range genDecl.Specs {
-				typeSpec, ok := spec.(*ast.TypeSpec)
-				if ok {
-					if _, isInterface := typeSpec.Type.(*ast.InterfaceType); isInterface {
-						interfaces = append(interfaces, typeSpec)
-					}
-				}
-			}
-		}
-	}
-	return interfaces, nil
-}
-
-// extractConstsFromCode extracts const declarations from Go code as a string.
-func (j *job) extractConstsFromCode(code string) ([]*ast.GenDecl, error) {
-
-	if !strings.HasPrefix(code, "package") {
-		// Ajouter "package main" au début du code
-		code = "package main\n\nimport \"fmt\"\n\n" + code
-	}
-
-	fs := token.NewFileSet()
-	node, err := parser.ParseFile(fs, "", code, parser.ParseComments)
-	if err != nil {
-		return nil, fmt.Errorf(j.t("error parsing consts from code")+": %v", err)
-	}
-
-	var consts []*ast.GenDecl
-	for _, decl := range node.Decls {
-		genDecl, ok := decl.(*ast.GenDecl)
-		if ok && genDecl.Tok == token.CONST {
-			consts = append(consts, genDecl)
-		}
-	}
-	return consts, nil
-}
-
-// extractVarsFromCode extracts var declarations from Go code as a string.
-func (j *job) extractVarsFromCode(code string) ([]*ast.GenDecl, error) {
-	if !strings.HasPrefix(code, "package") {
-		// Ajouter "package main" au début du code
-		code = "package main\n\nimport \"fmt\"\n\n" + code
-	}
-
-	fs := token.NewFileSet()
-	node, err := parser.ParseFile(fs, "", code, parser.ParseComments)
-	if err != nil {
-		return nil, fmt.Errorf(j.t("error parsing variables from code")+": %v", err)
-	}
-
-	var vars []*ast.GenDecl
-	for _, decl := range node.Decls {
-		genDecl, ok := decl.(*ast.GenDecl)
-		if ok && genDecl.Tok == token.VAR {
-			vars = append(vars, genDecl)
-		}
-	}
-	return vars, nil
-}
-
-// extractImportsFromCode extracts import statements from a Go code as a string.
-func (j *job) extractImportsFromCode(fromFile, code string) ([]string, error) {
-	if !strings.HasPrefix(code, "package") {
-		// Ajouter "package main" au début du code
-		code = "package main\n\nimport \"fmt\"\n\n" + code
-	}
-
-	fmt.Println(fmt.Sprintf("code from: %s", fromFile), magenta(code), "end")
-	// Parser le fichier Go pour en extraire l'AST
-	fs := token.NewFileSet()
-	node, err := parser.ParseFile(fs, "", []byte(code), parser.ImportsOnly)
-	if err != nil {
-		return nil, fmt.Errorf(j.t("error parsing imports")+": %v", err)
-	}
-
-	var imports []string
-	for _, imp := range node.Imports {
-		imports = append(imports, strings.Trim(imp.Path.Value, `"`))
-	}
-	return imports, nil
-}
-
 // extractLineNumber extracts the line number from an error message.
 func (j *job) extractLineNumber(errorMessage string) (int, error) {
 	// Expression régulière pour capturer le numéro de ligne
@@ -302,86 +149,46 @@ func (j *job) extractLineNumber(errorMessage string) (int, error) {
 	return lineNumber, nil
 }
 
-// extractFunctionFromLine extracts the code of a function from the line number.
-func (j *job) extractFunctionFromLine(lineNumber int) (string, error) {
-	data, err := ioutil.ReadFile(j.fileDir + "/" + j.currentSourceFileName)
+// extractFunctionFromLine extracts the code of the declaration containing the line number of a file.
+func (j *job) extractFunctionFromLine(fileName string, lineNumber int) (string, error) {
+	_, code, err := j.extractDeclarationFromLine(j.fileDir+"/"+fileName, lineNumber)
 	if err != nil {
-		return "", fmt.Errorf(j.t("error reading file")+": %v", err)
+		return "", err
 	}
+	return code, nil
+}
 
-	// Créer un fichier tokeniseur
-	fs := token.NewFileSet()
-	node, err := parser.ParseFile(fs, j.fileDir+"/"+j.currentSourceFileName, data, parser.ParseComments)
-	if err != nil {
-		return "", fmt.Errorf(j.t("error parsing file")+": %v", err)
-	}
+// extractUnusedImports extracts unused imports from an error message, grouped by file.
+func (j *job) extractUnusedImports(errorMessage string) (map[string][]string, error) {
+	re := regexp.MustCompile(`^"([^"]+)" imported (?:as \w+ )?and not used`)
 
-	// Parcours de l'AST (arbre syntaxique abstrait) pour trouver la fonction
-	for _, decl := range node.Decls {
-		funcDecl, ok := decl.(*ast.FuncDecl)
-		if !ok {
+	unusedImports := make(map[string][]string)
+	for _, d := range parseDiagnostics(errorMessage) {
+		if d.Category != diagnosticUnusedImport {
 			continue
 		}
-
-		// Vérifier si la ligne d'erreur est dans cette fonction
-		funcStartLine := fs.Position(funcDecl.Pos()).Line
-		funcEndLine := fs.Position(funcDecl.End()).Line
-
-		// Si la ligne d'erreur est dans cette fonction, retourner le code de la fonction
-		if lineNumber >= funcStartLine && lineNumber <= funcEndLine {
-			var functionCode bytes.Buffer
-			// Utiliser le printer Go pour imprimer le code de la fonction
-			err := printer.Fprint(&functionCode, fs, funcDecl)
-			if err != nil {
-				return "", fmt.Errorf(j.t("error printing function")+": %v", err)
-			}
-			return functionCode.String(), nil
-		}
-	}
-
-	// Si la ligne ne fait pas partie d'une fonction, retourner les 10 lignes avant et après
-	lines := bytes.Split(data, []byte("\n"))
-	startLine := max(0, lineNumber-10)
-	endLine := min(len(lines), lineNumber+10)
-
-	var surroundingCode bytes.Buffer
-	for i := startLine; i < endLine; i++ {
-		surroundingCode.Write(lines[i])
-		surroundingCode.WriteString("\n")
-	}
-
-	return surroundingCode.String(), nil
-}
-
-// extractUnusedImports extracts unused imports from an error message.
-func (j *job) extractUnusedImports(errorMessage string) ([]string, error) {
-	// Regex pour trouver les imports inutilisés dans les erreurs
-	re := regexp.MustCompile(`\./.*:\d+:\d+: "([^"]+)" imported and not used`)
-	matches := re.FindAllStringSubmatch(errorMessage, -1)
-
-	if len(matches) == 0 {
-		return nil, fmt.Errorf("aucun import inutilisé trouvé")
-	}
-
-	// Extraire les imports inutilisés
-	var unusedImports []string
-	for _, match := range matches {
-		if len(match) > 1 {
-			unusedImports = append(unusedImports, match[1])
+		if match := re.FindStringSubmatch(d.Message); match != nil {
+			file := filepath.Clean(d.File)
+			unusedImports[file] = append(unusedImports[file], match[1])
 		}
 	}
 
 	return unusedImports, nil
 }
 
-// extractErrorForPrompt extracts the code from the function containing the error to display in the prompt.
+// extractErrorForPrompt extracts the code of every declaration containing an error to display in the prompt.
 func (j *job) extractErrorForPrompt(output string) (string, error) {
+	if diagnostics := parseDiagnostics(output); len(diagnostics) > 0 {
+		fmt.Println(j.t("Number of errors found")+": ", len(diagnostics))
+		return j.diagnosticsForPrompt(diagnostics)
+	}
+
 	errorLine, err := j.extractLineNumber(output)
 	if err != nil {
 		return "", err
 	}
 	fmt.Println(j.t("Error line number")+": ", errorLine)
-	funcCode, err := j.extractFunctionFromLine(errorLine)
+	funcCode, err := j.extractFunctionFromLine(j.currentSourceFileName, errorLine)
 	if err != nil {
 		return "", fmt.Errorf(j.t("error extracting function")+": %v", err)
 	}
@@ -398,6 +205,7 @@ func (j *job) readFileContent(file string) ([]byte, error) {
 	if err != nil {
 		return nil, fmt.Errorf(j.t("error reading file")+" %s: %v", j.fileDir+"/"+file, err)
 	}
+	j.recordBase(file, data)
 	// Retourner le contenu sous forme de chaîne
 	return data, nil
 }
@@ -407,63 +215,62 @@ func extractFunctionDetails(funcDecl *ast.FuncDecl) string {
 	var builder strings.Builder
 	builder.WriteString("func ")
 
-	// Ajouter le nom de la struct si c'est une méthode
+	// Ajouter le type du receveur, avec ses paramètres de type
 	if funcDecl.Recv != nil {
 		for _, recv := range funcDecl.Recv.List {
-			// Type du receveur (struct)
 			builder.WriteString(fmt.Sprintf("(%s) ", exprToString(recv.Type)))
 		}
 	}
 
-	// Ajouter le nom de la fonction
+	// Ajouter le nom de la fonction et ses paramètres de type avec leurs contraintes
 	builder.WriteString(funcDecl.Name.Name)
-
-	// Ajouter les paramètres
-	builder.WriteString("(")
-	if funcDecl.Type.Params != nil {
-		params := []string{}
-		for _, param := range funcDecl.Type.Params.List {
-			paramType := exprToString(param.Type)
-			for range param.Names {
-				params = append(params, paramType)
-			}
-			// Si aucun nom, on ajoute juste le type
-			if len(param.Names) == 0 {
-				params = append(params, paramType)
+	if funcDecl.Type.TypeParams != nil {
+		typeParams := []string{}
+		for _, param := range funcDecl.Type.TypeParams.List {
+			names := []string{}
+			for _, name := range param.Names {
+				names = append(names, name.Name)
 			}
+			typeParams = append(typeParams, strings.Join(names, ", ")+" "+exprToString(param.Type))
 		}
-		builder.WriteString(strings.Join(params, ", "))
+		builder.WriteString("[" + strings.Join(typeParams, ", ") + "]")
 	}
+
+	// Ajouter les paramètres
+	builder.WriteString("(")
+	builder.WriteString(strings.Join(fieldTypes(funcDecl.Type.Params), ", "))
 	builder.WriteString(")")
 
 	// Ajouter les résultats
 	if funcDecl.Type.Results != nil {
-		results := []string{}
-		for _, result := range funcDecl.Type.Results.List {
-			results = append(results, exprToString(result.Type))
-		}
 		builder.WriteString("(")
-		builder.WriteString(strings.Join(results, ", "))
+		builder.WriteString(strings.Join(fieldTypes(funcDecl.Type.Results), ", "))
 		builder.WriteString(") { ... }")
 	}
 
 	return builder.String()
 }
 
-// exprToString Utility function to convert expression to string.
+// fieldTypes returns the type of each parameter or result of a list, repeated for the grouped names.
+func fieldTypes(fields *ast.FieldList) []string {
+	list := []string{}
+	if fields == nil {
+		return list
+	}
+	for _, field := range fields.List {
+		fieldType := exprToString(field.Type)
+		for range max(1, len(field.Names)) {
+			list = append(list, fieldType)
+		}
+	}
+	return list
+}
+
+// exprToString renders a type expression as in the source on one line: maps, channels, variadics,
+// arrays, generic instantiations, function types and interface literals.
 func exprToString(expr ast.Expr) string {
-	switch t := expr.(type) {
-	case *ast.Ident:
-		return t.Name
-	case *ast.SelectorExpr:
-		return fmt.Sprintf("%s.%s", exprToString(t.X), t.Sel.Name)
-	case *ast.StarExpr:
-		return fmt.Sprintf("*%s", exprToString(t.X))
-	case *ast.ArrayType:
-		return fmt.Sprintf("[]%s", exprToString(t.Elt))
-	case *ast.FuncType:
-		return "func" // Simplifié ici
-	default:
-		return fmt.Sprintf("%T", t)
+	if expr == nil {
+		return ""
 	}
+	return types.ExprString(expr)
 }
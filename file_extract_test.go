@@ -0,0 +1,154 @@
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"testing"
+)
+
+func TestExprToString(t *testing.T) {
+	tests := []struct {
+		name string
+		expr string
+		want string
+	}{
+		{"ident", "int", ""},
+		{"selector", "io.Reader", ""},
+		{"pointer", "*bytes.Buffer", ""},
+		{"slice", "[]string", ""},
+		{"array", "[4]byte", ""},
+		{"map", "map[string][]int", ""},
+		{"channel", "chan<- error", ""},
+		{"receive channel", "<-chan struct{}", ""},
+		{"function", "func(int, ...string) (bool, error)", ""},
+		{"generic instantiation", "Pair[string, *T]", ""},
+		{"interface literal", "interface{ Len() int }", "interface{Len() int}"},
+		{"constraint", "~int | ~string", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expr, err := parser.ParseExpr(tt.expr)
+			if err != nil {
+				t.Fatalf("ParseExpr() error = %v", err)
+			}
+			want := tt.want
+			if want == "" {
+				want = tt.expr
+			}
+			if got := exprToString(expr); got != want {
+				t.Errorf("exprToString() = %q, want %q", got, want)
+			}
+		})
+	}
+
+	if got := exprToString(nil); got != "" {
+		t.Errorf("exprToString(nil) = %q, want an empty string", got)
+	}
+}
+
+func TestExtractFunctionDetails(t *testing.T) {
+	tests := []struct {
+		name string
+		decl string
+		want string
+	}{
+		{
+			name: "without result",
+			decl: "func Run(ctx context.Context, name string) {}",
+			want: "func Run(context.Context, string)",
+		},
+		{
+			name: "grouped parameters and results",
+			decl: "func Split(a, b []byte) (x, y int, err error) { return }",
+			want: "func Split([]byte, []byte)(int, int, error) { ... }",
+		},
+		{
+			name: "variadic, map and channel",
+			decl: "func Send(ch chan<- map[string]int, values ...int) error { return nil }",
+			want: "func Send(chan<- map[string]int, ...int)(error) { ... }",
+		},
+		{
+			name: "type parameters with constraints",
+			decl: "func Map[T, U any, K comparable](s []T, f func(T) U) map[K]U { return nil }",
+			want: "func Map[T, U any, K comparable]([]T, func(T) U)(map[K]U) { ... }",
+		},
+		{
+			name: "union constraint",
+			decl: "func Sum[N ~int | ~float64](values ...N) N { return 0 }",
+			want: "func Sum[N ~int | ~float64](...N)(N) { ... }",
+		},
+		{
+			name: "method of a generic type",
+			decl: "func (p *Pair[K, V]) Swap() *Pair[V, K] { return nil }",
+			want: "func (*Pair[K, V]) Swap()(*Pair[V, K]) { ... }",
+		},
+		{
+			name: "interface literal parameter",
+			decl: "func Size(v interface{ Len() int }) int { return v.Len() }",
+			want: "func Size(interface{Len() int})(int) { ... }",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node, err := parser.ParseFile(token.NewFileSet(), "m.go", "package m\n\n"+tt.decl+"\n", 0)
+			if err != nil {
+				t.Fatalf("ParseFile() error = %v", err)
+			}
+			if got := extractFunctionDetails(node.Decls[0].(*ast.FuncDecl)); got != tt.want {
+				t.Errorf("extractFunctionDetails() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitFileNameAndCode(t *testing.T) {
+	tests := []struct {
+		name        string
+		currentFile string
+		response    string
+		wantFile    string
+		wantCode    string
+	}{
+		{
+			name:        "test file named by the reply",
+			currentFile: "a/a_test.go",
+			response:    "MODIFY: TestA (test file)\nCODE: ```go\nfunc TestA(t *testing.T) {}\n```",
+			wantFile:    "a/a_test.go",
+			wantCode:    "\nfunc TestA(t *testing.T) {}\n",
+		},
+		{
+			name:        "source file named by the reply",
+			currentFile: "a/a_test.go",
+			response:    "MODIFY: A (source file, not test file)\nCODE: ```go\nfunc A() {}\n```",
+			wantFile:    "a/a.go",
+			wantCode:    "\nfunc A() {}\n",
+		},
+		{
+			name:        "reply without file",
+			currentFile: "a/a_test.go",
+			response:    "func TestA(t *testing.T) {}",
+			wantFile:    "a/a_test.go",
+			wantCode:    "func TestA(t *testing.T) {}",
+		},
+		{
+			name:        "source file being written",
+			currentFile: "a/a.go",
+			response:    "func A() {}",
+			wantFile:    "a/a.go",
+			wantCode:    "func A() {}",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			j := &job{currentFileName: tt.currentFile}
+			gotFile, gotCode := j.splitFileNameAndCode(tt.response)
+			if gotFile != tt.wantFile || gotCode != tt.wantCode {
+				t.Errorf("splitFileNameAndCode() = %q, %q, want %q, %q", gotFile, gotCode, tt.wantFile, tt.wantCode)
+			}
+		})
+	}
+}
@@ -7,7 +7,6 @@ import (
 	"go/ast"
 	"go/format"
 	"go/parser"
-	"go/printer"
 	"go/token"
 	"os"
 	"path/filepath"
@@ -17,22 +16,14 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
-// addImport adds an import to the list of existing imports.
-func addImport(existingImports []string, newImport string) []string {
-	for _, imp := range existingImports {
-		if imp == newImport {
-			return existingImports // Ne rien faire si l'import existe déjà
-		}
-	}
-	return append(existingImports, newImport)
-}
-
 // removeUnusedImports removes unused imports from the source code.
 func (j *job) removeUnusedImports(unusedImports []string, currentFileName string) error {
 	var data []byte
-	if currentFileName == j.currentSourceFileName {
+	if sameFileName(currentFileName, j.currentSourceFileName) {
+		currentFileName = j.currentSourceFileName
 		data = j.currentSrcSource
-	} else if currentFileName == j.currentTestFileName {
+	} else if sameFileName(currentFileName, j.currentTestFileName) {
+		currentFileName = j.currentTestFileName
 		data = j.currentSrcTest
 	}
 
@@ -45,6 +36,9 @@ func (j *job) removeUnusedImports(unusedImports []string, currentFileName string
 		return fmt.Errorf("impossible de parser le fichier: %w", err)
 	}
 
+	// Les commentaires des imports supprimés sont supprimés avec eux.
+	comments := ast.NewCommentMap(fs, node, node.Comments)
+
 	// Convertir la liste des imports inutilisés en un map pour faciliter la recherche
 	unusedMap := make(map[string]struct{})
 	for _, imp := range unusedImports {
@@ -87,6 +81,7 @@ func (j *job) removeUnusedImports(unusedImports []string, currentFileName string
 
 	// Mettre à jour les déclarations du fichier AST
 	node.Decls = newDecls
+	node.Comments = keepDirectives(node.Comments, comments.Filter(node).Comments())
 
 	formattedCode, err := j.nodeToBytes(fs, node)
 	if err != nil {
@@ -97,15 +92,9 @@ func (j *job) removeUnusedImports(unusedImports []string, currentFileName string
 }
 
 // stepFixCode updates the source code with OpenAI imports and declarations.
+// The declarations are merged into the text of the file, so that its comments, build constraints and
+// directives are kept, and the declarations of the response keep their comments.
 func (j *job) stepFixCode(currentFileName, openAIResponse string) ([]byte, error) {
-
-	openAIResponse = strings.TrimSpace(openAIResponse)
-
-	openAIImports, err := j.extractImportsFromCode("openAI", openAIResponse)
-	if err != nil {
-		return nil, fmt.Errorf(j.t("error extracting OpenAI imports")+": %v", err)
-	}
-
 	var data []byte
 	if currentFileName == j.currentSourceFileName {
 		data = j.currentSrcSource
@@ -113,267 +102,82 @@ func (j *job) stepFixCode(currentFileName, openAIResponse string) ([]byte, error
 		data = j.currentSrcTest
 	}
 
-	existingImports, err := j.extractImportsFromCode("local", string(data))
-	if err != nil {
-		return nil, fmt.Errorf(j.t("error extracting existing imports")+": %v", err)
-	}
-
-	// Ajouter les imports OpenAI manquants aux imports existants
-	for _, newImport := range openAIImports {
-		existingImports = addImport(existingImports, newImport)
-	}
-
-	// Créer un fichier tokeniseur
-	fs := token.NewFileSet()
-	node, err := parser.ParseFile(fs, j.fileDir+"/"+currentFileName, data, parser.ParseComments)
-	if err != nil {
-		return nil, fmt.Errorf(j.t("error parsing file")+": %v", err)
-	}
+	return j.mergeCode(currentFileName, data, openAIResponse)
+}
 
-	// Mettre à jour les imports dans le fichier
-	// Recréer la section d'import avec les nouveaux imports
-	importDecl := &ast.GenDecl{
-		Tok:   token.IMPORT,
-		Specs: make([]ast.Spec, 0, len(existingImports)),
-	}
+// mergeCode merges the declarations of a response into the content of a file.
+func (j *job) mergeCode(currentFileName string, data []byte, openAIResponse string) ([]byte, error) {
+	openAIResponse = strings.TrimSpace(openAIResponse)
 
-	for _, imp := range existingImports {
-		importDecl.Specs = append(importDecl.Specs, &ast.ImportSpec{
-			Path: &ast.BasicLit{Value: fmt.Sprintf("%q", imp)},
-		})
-	}
+	// The cases of the table-driven tests are merged into the existing tables.
+	data, mergedTests := j.mergeTestTables(data, openAIResponse)
 
-	// Remplacer la section des imports dans le fichier
-	if len(node.Decls) > 0 {
-		// Remplacer la première déclaration par importDecl
-		node.Decls[0] = importDecl
-	} else {
-		// Si node.Decls est vide, ajoutez importDecl en tant que première déclaration
-		node.Decls = append(node.Decls, importDecl)
-	}
-
-	interfaces, err := j.extractInterfacesFromCode(openAIResponse)
+	m, err := j.newDeclMerger(currentFileName, data, openAIResponse)
 	if err != nil {
-		return nil, fmt.Errorf(j.t("error extracting interfaces")+": %v", err)
+		return nil, err
 	}
 
-	for _, openAIInterface := range interfaces {
-		found := false // Indique si l'interface OpenAI a été trouvée dans les déclarations existantes
-
-		for _, decl := range node.Decls[1:] {
-			genDecl, ok := decl.(*ast.GenDecl)
-			if ok && genDecl.Tok == token.TYPE {
-				for _, spec := range genDecl.Specs {
-					typeSpec, ok := spec.(*ast.TypeSpec)
-					if ok && typeSpec.Name.Name == openAIInterface.Name.Name {
-						// Remplacer l'interface existante par celle d'OpenAI
-						typeSpec.Type = openAIInterface.Type
-						found = true
-						break
-					}
-				}
-			}
-			if found {
-				break
-			}
-		}
-
-		// Si l'interface n'a pas été trouvée dans les déclarations existantes, l'ajouter
-		if !found {
-			genDecl := &ast.GenDecl{
-				Tok: token.TYPE,
-				Specs: []ast.Spec{&ast.TypeSpec{
-					Name: openAIInterface.Name,
-					Type: openAIInterface.Type,
-				}},
-			}
-			node.Decls = append(node.Decls, genDecl)
+	m.mergeImports()
+	m.mergeTypes()
+	for _, tok := range []token.Token{token.CONST, token.VAR} {
+		for _, names := range m.mergeValues(tok) {
+			log.Warnf(j.t("The declaration %s %s of the response does not declare all the names of the existing declarations it replaces, it is not merged"), tok, names)
 		}
 	}
 
-	constants, err := j.extractConstsFromCode(openAIResponse)
-	if err != nil {
-		return nil, fmt.Errorf(j.t("error extracting constants")+": %v", err)
-	}
-
-	for _, genConst := range constants {
-		found := false // Indique si le groupe de constantes a été trouvé dans les déclarations existantes
-
-		// Rechercher si le groupe de constantes `genConst` est déjà dans les déclarations
-		for _, decl := range node.Decls[1:] {
-			existingGenDecl, ok := decl.(*ast.GenDecl)
-			if ok && existingGenDecl.Tok == token.CONST {
-				for _, openAISpec := range genConst.Specs {
-					openAIConst, ok := openAISpec.(*ast.ValueSpec)
-					if !ok {
-						continue
-					}
-
-					for _, spec := range existingGenDecl.Specs {
-						valueSpec, ok := spec.(*ast.ValueSpec)
-						if ok && len(valueSpec.Names) > 0 && valueSpec.Names[0].Name == openAIConst.Names[0].Name {
-							// Remplacer la valeur de la constante existante
-							valueSpec.Values = openAIConst.Values
-							found = true
-							break
-						}
-					}
-
-					if found {
-						break
-					}
-				}
-			}
-
-			if found {
-				break
-			}
-		}
+	// Les fonctions sont identifiées par le type de leur receveur et leur nom.
+	matcher := j.newFuncMatcher(currentFileName, m.node.Decls, openAIResponse)
 
-		// Si aucun des noms de constantes dans `genConst` n'a été trouvé, ajouter le groupe entier
-		if !found {
-			node.Decls = append(node.Decls, genConst)
+	for _, decl := range m.newNode.Decls {
+		openAIFunc, ok := decl.(*ast.FuncDecl)
+		if !ok || openAIFunc.Body == nil {
+			continue
 		}
-	}
-
-	vars, err := j.extractVarsFromCode(openAIResponse)
-	if err != nil {
-		return nil, fmt.Errorf(j.t("error extracting variables")+": %v", err)
-	}
-
-	for _, genVar := range vars {
-		found := false // Indique si le groupe de constantes a été trouvé dans les déclarations existantes
-
-		// Rechercher si le groupe de constantes `genConst` est déjà dans les déclarations
-		for _, decl := range node.Decls[1:] {
-			existingGenDecl, ok := decl.(*ast.GenDecl)
-			if ok && existingGenDecl.Tok == token.VAR {
-				for _, openAISpec := range genVar.Specs {
-					openAIVar, ok := openAISpec.(*ast.ValueSpec)
-					if !ok {
-						continue
-					}
-
-					for _, spec := range existingGenDecl.Specs {
-						valueSpec, ok := spec.(*ast.ValueSpec)
-						if ok && len(valueSpec.Names) > 0 && valueSpec.Names[0].Name == openAIVar.Names[0].Name {
-							// Remplacer la valeur de la constante existante
-							valueSpec.Values = openAIVar.Values
-							found = true
-							break
-						}
-					}
-
-					if found {
-						break
-					}
-				}
-			}
-
-			if found {
-				break
-			}
+		if openAIFunc.Recv == nil && mergedTests[openAIFunc.Name.Name] {
+			continue
 		}
 
-		if !found {
-			node.Decls = append(node.Decls, genVar)
+		funcDecl, candidates, ambiguous := matcher.match(openAIFunc)
+		if ambiguous {
+			j.reportAmbiguousFunc(openAIFunc, candidates)
+			continue
 		}
-	}
 
-	structs, err := j.extractStructsFromCode(openAIResponse)
-	if err != nil {
-		return nil, fmt.Errorf(j.t("error extracting structures")+": %v", err)
-	}
-
-	for _, openAIStruct := range structs {
-		found := false // Indique si la struct d'OpenAI a été trouvée dans les déclarations existantes
-
-		for _, decl := range node.Decls[1:] {
-			genDecl, ok := decl.(*ast.GenDecl)
-			if ok && genDecl.Tok == token.TYPE {
-				// Parcourir les spécifications de type dans la déclaration
-				for _, spec := range genDecl.Specs {
-					typeSpec, ok := spec.(*ast.TypeSpec)
-					if ok {
-						_, ok := typeSpec.Type.(*ast.StructType)
-						// Vérifier si c'est bien une struct et que le nom correspond
-						if ok && typeSpec.Name.Name == openAIStruct.Name.Name {
-							// Remplacer la struct existante par celle d'OpenAI
-							typeSpec.Type = openAIStruct.Type
-							found = true
-							break
-						}
-					}
-				}
-			}
-			if found {
-				break
-			}
+		// Si la fonction n'a pas été trouvée dans les déclarations existantes, l'ajouter.
+		if funcDecl == nil {
+			// Ajouter la fonction OpenAI à la liste des fonctions créées.
+			j.listFunctionsCreated = append(j.listFunctionsCreated, extractFunctionDetails(openAIFunc))
+			m.add(openAIFunc.Doc, m.newText(openAIFunc.Pos(), openAIFunc.End()))
+			continue
 		}
 
-		// Si la struct n'a pas été trouvée dans les déclarations existantes, l'ajouter.
-		if !found {
-			genDecl := &ast.GenDecl{
-				Tok: token.TYPE,
-				Specs: []ast.Spec{&ast.TypeSpec{
-					Name: openAIStruct.Name,
-					Type: openAIStruct.Type,
-				}},
-			}
-			node.Decls = append(node.Decls, genDecl)
+		j.listFunctionsUpdated = append(j.listFunctionsUpdated, extractFunctionDetails(funcDecl))
+		// Remplacer le corps de la fonction existante par celui d'OpenAI.
+		m.replace(funcDecl.Body.Pos(), funcDecl.Body.End(), m.newText(openAIFunc.Body.Pos(), openAIFunc.Body.End()))
+		// Le corps utilise les noms du receveur, des paramètres et des paramètres de type de la réponse.
+		start, newStart := funcDecl.Name.Pos(), openAIFunc.Name.Pos()
+		if funcDecl.Recv != nil && openAIFunc.Recv != nil {
+			start, newStart = funcDecl.Recv.Pos(), openAIFunc.Recv.Pos()
 		}
+		m.replace(start, funcDecl.Type.End(), m.newText(newStart, openAIFunc.Type.End()))
+		m.addDoc(funcDecl.Pos(), funcDecl.Doc, openAIFunc.Doc)
 	}
 
-	funcs, err := j.extractFunctionsFromCode(openAIResponse)
+	result, err := m.bytes()
 	if err != nil {
-		return nil, fmt.Errorf(j.t("error extracting functions")+": %v", err)
-	}
-
-	// Pour chaque déclaration dans le fichier, traiter les fonctions.
-	for _, openAIFunc := range funcs {
-		found := false // Indique si la fonction OpenAI a été trouvée dans les déclarations existantes.
-
-		for _, decl := range node.Decls[1:] {
-			funcDecl, ok := decl.(*ast.FuncDecl)
-			if ok {
-				// Si la fonction est complète, vérifier si elle correspond à celle d'OpenAI.
-				if isCompleteFunction(funcDecl) && funcDecl.Name.Name == openAIFunc.Name.Name {
-					fullFuncName := extractFunctionDetails(funcDecl)
-					j.listFunctionsUpdated = append(j.listFunctionsUpdated, fullFuncName)
-					// Remplacer le corps de la fonction existante par celui d'OpenAI.
-					funcDecl.Body = openAIFunc.Body
-					found = true
-					break
-				}
-			}
-		}
-
-		// Si la fonction n'a pas été trouvée dans les déclarations existantes, l'ajouter.
-		if !found {
-			fullFuncName := extractFunctionDetails(openAIFunc)
-			// Ajouter la fonction OpenAI à la liste des fonctions mises à jour.
-			j.listFunctionsCreated = append(j.listFunctionsCreated, fullFuncName)
-			node.Decls = append(node.Decls, openAIFunc)
-		}
+		return nil, fmt.Errorf(j.t("error while formatting file")+": %v", err)
 	}
-
-	return j.nodeToBytes(fs, node)
+	return result, nil
 }
 
 // nodeToBytes converts an AST node into a byte array.
+// The comments of the file must have been filtered with an ast.CommentMap when nodes were removed.
 func (j *job) nodeToBytes(fs *token.FileSet, node *ast.File) ([]byte, error) {
 	var modifiedFile bytes.Buffer
 
-	// Écrire le package
-	fmt.Fprintf(&modifiedFile, "package %s", node.Name.Name)
-	modifiedFile.WriteString("\n\n")
-
-	// Écrire les déclarations
-	for _, decl := range node.Decls {
-		if err := printer.Fprint(&modifiedFile, fs, decl); err != nil {
-			return nil, fmt.Errorf(j.t("error writing modified declaration")+": %v", err)
-		}
-		modifiedFile.WriteString("\n\n")
+	// Le fichier est écrit en entier pour garder ses commentaires et ses directives.
+	if err := format.Node(&modifiedFile, fs, node); err != nil {
+		return nil, fmt.Errorf(j.t("error writing modified declaration")+": %v", err)
 	}
 
 	// Appliquer un formatage Go standard au code généré
@@ -418,9 +222,29 @@ func (j *job) createAndWriteFile(currentFileName string) error {
 	return nil
 }
 
+// writeResponse writes the code built from a response of the model, once reviewed when -review is set.
+// The rewrites made by goia itself go through writeFile and are not reviewed.
+func (j *job) writeResponse(currentFileName string, res []byte) error {
+	if j.args.review && j.source != fileSourceStdin {
+		if src, err := j.fileContent(currentFileName); err == nil && !bytes.Equal(src, res) {
+			res = j.reviewFile(currentFileName, src, res)
+		}
+	}
+	return j.writeFile(currentFileName, res)
+}
+
 // writeFile writes the contents of the modified file to the original file, stdout, or a destination file.
 func (j *job) writeFile(currentFileName string, res []byte) error {
 
+	// Les modifications faites sur le disque pendant la session sont fusionnées.
+	if j.args.write && j.source != fileSourceStdin {
+		merged, err := j.mergeDiskChanges(currentFileName, res)
+		if err != nil {
+			return err
+		}
+		res = merged
+	}
+
 	var src []byte
 	if currentFileName == j.currentSourceFileName {
 		src = j.currentSrcSource
@@ -432,6 +256,8 @@ func (j *job) writeFile(currentFileName string, res []byte) error {
 
 	out := os.Stdout
 	if !bytes.Equal(src, res) {
+		j.filesChanged = append(j.filesChanged, currentFileName)
+
 		if j.args.listOnly {
 			_, _ = fmt.Fprintln(out, j.fileDir+"/"+currentFileName)
 		}
@@ -440,7 +266,11 @@ func (j *job) writeFile(currentFileName string, res []byte) error {
 			if j.source == fileSourceStdin {
 				return errors.New("can't use -w on stdin")
 			}
-			return os.WriteFile(j.fileDir+"/"+currentFileName, res, 0o644)
+			if err := os.WriteFile(j.fileDir+"/"+currentFileName, res, 0o644); err != nil {
+				return err
+			}
+			j.recordBase(currentFileName, res)
+			return nil
 		}
 
 		if j.args.diffOnly {
@@ -449,12 +279,7 @@ func (j *job) writeFile(currentFileName string, res []byte) error {
 				j.currentFileName = "stdin.go" // because <standard input>.orig looks silly
 			}
 
-			data, err := diff(src, res, j.fileDir+"/"+currentFileName)
-			if err != nil {
-				return fmt.Errorf("computing diff: %v", err)
-			}
-
-			_, _ = out.Write(data)
+			_, _ = out.Write(diff(src, res, j.fileDir+"/"+currentFileName, j.args.sideBySide))
 		}
 	}
 
@@ -0,0 +1,116 @@
+package main
+
+import "testing"
+
+func TestMergeCode(t *testing.T) {
+	src := `package p
+
+// Sum returns the sum of the numbers.
+func Sum(nums []int) int {
+	return 0
+}
+
+type T struct{ n int }
+
+func (t *T) Get() int {
+	return 0
+}
+`
+
+	tests := []struct {
+		name     string
+		response string
+		want     string
+	}{
+		{
+			name:     "signature of a function",
+			response: "func Sum(values ...int) (total int) {\n\tfor _, v := range values {\n\t\ttotal += v\n\t}\n\treturn total\n}",
+			want: `package p
+
+// Sum returns the sum of the numbers.
+func Sum(values ...int) (total int) {
+	for _, v := range values {
+		total += v
+	}
+	return total
+}
+
+type T struct{ n int }
+
+func (t *T) Get() int {
+	return 0
+}
+`,
+		},
+		{
+			name:     "generic function",
+			response: "func Sum[N int | float64](nums []N) N {\n\tvar s N\n\treturn s\n}",
+			want: `package p
+
+// Sum returns the sum of the numbers.
+func Sum[N int | float64](nums []N) N {
+	var s N
+	return s
+}
+
+type T struct{ n int }
+
+func (t *T) Get() int {
+	return 0
+}
+`,
+		},
+		{
+			name:     "receiver of a method",
+			response: "func (x *T) Get() int {\n\treturn x.n\n}",
+			want: `package p
+
+// Sum returns the sum of the numbers.
+func Sum(nums []int) int {
+	return 0
+}
+
+type T struct{ n int }
+
+func (x *T) Get() int {
+	return x.n
+}
+`,
+		},
+		{
+			name:     "new function",
+			response: "// Max returns the maximum.\nfunc Max(a, b int) int {\n\treturn max(a, b)\n}",
+			want: `package p
+
+// Sum returns the sum of the numbers.
+func Sum(nums []int) int {
+	return 0
+}
+
+type T struct{ n int }
+
+func (t *T) Get() int {
+	return 0
+}
+
+// Max returns the maximum.
+func Max(a, b int) int {
+	return max(a, b)
+}
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			j := &job{fileDir: t.TempDir()}
+			got, err := j.mergeCode("p.go", []byte(src), tt.response)
+			if err != nil {
+				t.Fatalf("mergeCode() error = %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("mergeCode() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
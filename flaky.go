@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// defaultFlakyTimeout is the timeout of each rerun of the failed tests when none is configured.
+const defaultFlakyTimeout = time.Minute
+
+// FlakyConfig configures the reruns of the failed tests before asking the model to fix them.
+type FlakyConfig struct {
+	// Reruns is the number of times the failed tests are rerun, 0 disables the detection.
+	Reruns int `yaml:"reruns"`
+	// Timeout is the timeout of each rerun.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// Merge merges the given FlakyConfig with this one.
+func (cfg *FlakyConfig) Merge(newCfg FlakyConfig) {
+	if newCfg.Reruns != 0 {
+		cfg.Reruns = newCfg.Reruns
+	}
+	if newCfg.Timeout != 0 {
+		cfg.Timeout = newCfg.Timeout
+	}
+}
+
+// testClass is the classification of a failed test after its reruns.
+type testClass string
+
+const (
+	testDeterministic testClass = "deterministic"
+	testFlaky         testClass = "flaky"
+	testTimeout       testClass = "timeout"
+	// testUnknown is a test that never ran again, e.g. after a panic of another test.
+	testUnknown testClass = "unknown"
+)
+
+// testStats counts the results of the reruns of a test.
+type testStats struct {
+	runs     int
+	passes   int
+	fails    int
+	timeouts int
+}
+
+// class returns the classification of the test from its results.
+func (s *testStats) class() testClass {
+	switch {
+	case s.runs == 0:
+		return testUnknown
+	case s.timeouts > 0:
+		return testTimeout
+	case s.passes > 0 && s.fails > 0:
+		return testFlaky
+	case s.runs > 0 && s.fails == 0:
+		// The test never failed again.
+		return testFlaky
+	default:
+		return testDeterministic
+	}
+}
+
+// testEvent is an event printed by `go test -json`.
+type testEvent struct {
+	Action  string
+	Package string
+	Test    string
+	Output  string
+}
+
+// failedTestsRunPattern returns the `-run` pattern selecting the top-level tests of the given tests.
+func failedTestsRunPattern(failedTests []string) string {
+	var names []string
+	for _, name := range failedTests {
+		parent := strings.SplitN(name, "/", 2)[0]
+		names = append(names, regexp.QuoteMeta(parent))
+	}
+	return "^(" + strings.Join(removeDuplicates(names), "|") + ")$"
+}
+
+// testID identifies a test in the module, as tests of different packages can have the same name.
+func testID(pkg, name string) string {
+	return pkg + "." + name
+}
+
+var regFailedTestLine = regexp.MustCompile(`^\s*--- FAIL: ([\w\/]+)`)
+
+// failedTestPackages returns the packages where each test failed, from the output of `go test`:
+// the failures of a package are printed before its FAIL line.
+func failedTestPackages(output string) map[string][]string {
+	packages := make(map[string][]string)
+	var pending []string
+	for _, line := range strings.Split(output, "\n") {
+		if match := regFailedTestLine.FindStringSubmatch(line); match != nil {
+			pending = append(pending, match[1])
+			continue
+		}
+		if rest, ok := strings.CutPrefix(line, "FAIL\t"); ok {
+			pkg := strings.Fields(rest)[0]
+			for _, name := range pending {
+				packages[name] = append(packages[name], pkg)
+			}
+			pending = nil
+		}
+	}
+	return packages
+}
+
+// rerunFailedTests reruns the failed tests of each package in a shuffled order and counts their results
+// by test ID. The reruns of a package are done by a single `go test -count` so that its tests are built once.
+func (j *job) rerunFailedTests(failedByPackage map[string][]string) (map[string]*testStats, error) {
+	timeout := j.flakyConfig.Timeout
+	if timeout == 0 {
+		timeout = defaultFlakyTimeout
+	}
+
+	pkgs := make([]string, 0, len(failedByPackage))
+	stats := make(map[string]*testStats)
+	for pkg, names := range failedByPackage {
+		pkgs = append(pkgs, pkg)
+		for _, name := range names {
+			stats[testID(pkg, name)] = &testStats{}
+		}
+	}
+	sort.Strings(pkgs)
+
+	log.Infof("%d reruns of the failed tests", j.flakyConfig.Reruns)
+
+	for _, pkg := range pkgs {
+		// The timeout of `go test` applies to the whole test binary, hence to all the reruns.
+		result, err := j.runCommand(nil, nil, "go", "test", "-json", fmt.Sprintf("-count=%d", j.flakyConfig.Reruns), "-shuffle=on",
+			"-timeout", (timeout * time.Duration(j.flakyConfig.Reruns)).String(), "-run", failedTestsRunPattern(failedByPackage[pkg]), pkg)
+		if result == nil {
+			return nil, err
+		}
+		countTestEvents(result.Stdout, stats)
+	}
+	return stats, nil
+}
+
+// countTestEvents counts the results of the given tests, keyed by test ID, from the output of `go test -json`.
+func countTestEvents(output string, stats map[string]*testStats) {
+	running := make(map[string]struct{})
+	timedOut := false
+
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var event testEvent
+		if json.Unmarshal(scanner.Bytes(), &event) != nil {
+			continue
+		}
+
+		if strings.Contains(event.Output, "panic: test timed out") {
+			timedOut = true
+		}
+
+		id := testID(event.Package, event.Test)
+		s, ok := stats[id]
+		if !ok {
+			continue
+		}
+
+		switch event.Action {
+		case "run":
+			running[id] = struct{}{}
+		case "pass":
+			s.runs++
+			s.passes++
+			delete(running, id)
+		case "fail":
+			s.runs++
+			s.fails++
+			delete(running, id)
+		}
+	}
+
+	// The tests still running when the test binary panicked are the ones that timed out.
+	if timedOut {
+		for id := range running {
+			stats[id].runs++
+			stats[id].timeouts++
+		}
+	}
+}
+
+// filterFlakyTests reruns the failed tests, found in the output of `go test`, and returns the ones that
+// fail deterministically in at least one package. The flaky tests and the tests that timed out are kept
+// for the final report.
+func (j *job) filterFlakyTests(output string, failedTests []string) ([]string, error) {
+	if j.flakyConfig.Reruns <= 0 || len(failedTests) == 0 {
+		return failedTests, nil
+	}
+
+	packages := failedTestPackages(output)
+	failedByPackage := make(map[string][]string)
+	for _, name := range failedTests {
+		for _, pkg := range packages[name] {
+			failedByPackage[pkg] = append(failedByPackage[pkg], name)
+		}
+	}
+
+	stats, err := j.rerunFailedTests(failedByPackage)
+	if err != nil {
+		return nil, err
+	}
+
+	var deterministic []string
+	for _, name := range failedTests {
+		if len(packages[name]) == 0 {
+			log.Warnf(j.t("Test %s did not run again, its stability is unknown"), name)
+			deterministic = append(deterministic, name)
+			continue
+		}
+
+		// The test is sent to the model when it fails deterministically in one of its packages.
+		send := false
+		for _, pkg := range packages[name] {
+			id := testID(pkg, name)
+			switch class := stats[id].class(); class {
+			case testUnknown:
+				// Nothing contradicts the first failure, the test is still sent to the model.
+				log.Warnf(j.t("Test %s did not run again, its stability is unknown"), id)
+				send = true
+			case testDeterministic:
+				send = true
+			default:
+				log.Warnf(j.t("Test %s is %s, it will not be sent to the model")+" (%d/%d)", id, class, stats[id].fails+stats[id].timeouts, stats[id].runs)
+				j.unstableTests[id] = class
+			}
+		}
+		if send {
+			deterministic = append(deterministic, name)
+		}
+	}
+
+	return deterministic, nil
+}
+
+// unstableTestsReport returns the report of the flaky tests and the tests that timed out.
+func (j *job) unstableTestsReport() string {
+	if len(j.unstableTests) == 0 {
+		return ""
+	}
+
+	var names []string
+	for name := range j.unstableTests {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	report := j.t("Unstable tests that were not sent to the model") + ":\n"
+	for _, name := range names {
+		report += fmt.Sprintf("  - %s (%s)\n", name, j.unstableTests[name])
+	}
+	return report
+}
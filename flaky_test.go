@@ -0,0 +1,156 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTestStatsClass(t *testing.T) {
+	tests := []struct {
+		name  string
+		stats testStats
+		want  testClass
+	}{
+		{"never ran", testStats{}, testUnknown},
+		{"always fails", testStats{runs: 3, fails: 3}, testDeterministic},
+		{"passes and fails", testStats{runs: 3, passes: 1, fails: 2}, testFlaky},
+		{"always passes", testStats{runs: 3, passes: 3}, testFlaky},
+		{"timed out", testStats{runs: 2, fails: 1, timeouts: 1}, testTimeout},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.stats.class(); got != tt.want {
+				t.Errorf("class() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCountTestEvents(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   map[string]testStats
+	}{
+		{
+			name: "several runs",
+			output: `{"Action":"run","Package":"m/a","Test":"TestA"}` + "\n" +
+				`{"Action":"fail","Package":"m/a","Test":"TestA"}` + "\n" +
+				`{"Action":"run","Package":"m/a","Test":"TestB"}` + "\n" +
+				`{"Action":"pass","Package":"m/a","Test":"TestB"}` + "\n" +
+				`{"Action":"run","Package":"m/a","Test":"TestOther"}` + "\n" +
+				`{"Action":"pass","Package":"m/a","Test":"TestOther"}` + "\n" +
+				`{"Action":"run","Package":"m/a","Test":"TestA"}` + "\n" +
+				`{"Action":"fail","Package":"m/a","Test":"TestA"}` + "\n" +
+				`{"Action":"run","Package":"m/a","Test":"TestB"}` + "\n" +
+				`{"Action":"fail","Package":"m/a","Test":"TestB"}` + "\n",
+			want: map[string]testStats{
+				"m/a.TestA": {runs: 2, fails: 2},
+				"m/a.TestB": {runs: 2, passes: 1, fails: 1},
+				"m/b.TestA": {},
+			},
+		},
+		{
+			name: "same name in two packages",
+			output: `{"Action":"run","Package":"m/a","Test":"TestA"}` + "\n" +
+				`{"Action":"fail","Package":"m/a","Test":"TestA"}` + "\n" +
+				`{"Action":"run","Package":"m/b","Test":"TestA"}` + "\n" +
+				`{"Action":"pass","Package":"m/b","Test":"TestA"}` + "\n",
+			want: map[string]testStats{
+				"m/a.TestA": {runs: 1, fails: 1},
+				"m/a.TestB": {},
+				"m/b.TestA": {runs: 1, passes: 1},
+			},
+		},
+		{
+			name: "timeout",
+			output: `{"Action":"run","Package":"m/a","Test":"TestA"}` + "\n" +
+				`{"Action":"output","Package":"m/a","Output":"panic: test timed out after 1m0s\n"}` + "\n" +
+				"not json\n",
+			want: map[string]testStats{
+				"m/a.TestA": {runs: 1, timeouts: 1},
+				"m/a.TestB": {},
+				"m/b.TestA": {},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stats := map[string]*testStats{"m/a.TestA": {}, "m/a.TestB": {}, "m/b.TestA": {}}
+			countTestEvents(tt.output, stats)
+
+			got := make(map[string]testStats)
+			for name, s := range stats {
+				got[name] = *s
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("countTestEvents() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFailedTestPackages(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   map[string][]string
+	}{
+		{
+			name:   "no failure",
+			output: "ok  \texample.com/m/a\t0.01s\n",
+			want:   map[string][]string{},
+		},
+		{
+			name: "same name in two packages",
+			output: "--- FAIL: TestParse (0.00s)\n    a_test.go:9: failed\nFAIL\nFAIL\texample.com/m/a\t0.01s\n" +
+				"ok  \texample.com/m/c\t0.01s\n" +
+				"--- FAIL: TestParse (0.00s)\n    --- FAIL: TestParse/empty (0.00s)\n--- FAIL: TestB (0.00s)\nFAIL\nFAIL\texample.com/m/b\t0.02s\nFAIL\n",
+			want: map[string][]string{
+				"TestParse":       {"example.com/m/a", "example.com/m/b"},
+				"TestParse/empty": {"example.com/m/b"},
+				"TestB":           {"example.com/m/b"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := failedTestPackages(tt.output); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("failedTestPackages() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilterFlakyTests(t *testing.T) {
+	dir := writeModule(t, map[string]string{
+		"go.mod":      "module example.com/m\n\ngo 1.22\n",
+		"a/a_test.go": "package a\n\nimport \"testing\"\n\nfunc TestParse(t *testing.T) { t.Fatal(\"failed\") }\n",
+		"b/b_test.go": "package b\n\nimport \"testing\"\n\nfunc TestParse(t *testing.T) {}\n",
+	})
+	// The first run failed in both packages, the test of b passes on the reruns.
+	output := "--- FAIL: TestParse (0.00s)\nFAIL\nFAIL\texample.com/m/a\t0.01s\n" +
+		"--- FAIL: TestParse (0.00s)\nFAIL\nFAIL\texample.com/m/b\t0.01s\nFAIL\n"
+
+	j := &job{fileDir: dir, flakyConfig: FlakyConfig{Reruns: 2}, unstableTests: map[string]testClass{}}
+	got, err := j.filterFlakyTests(output, []string{"TestParse"})
+	if err != nil {
+		t.Fatalf("filterFlakyTests() error = %v", err)
+	}
+	if want := []string{"TestParse"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("filterFlakyTests() = %v, want %v", got, want)
+	}
+	if want := map[string]testClass{"example.com/m/b.TestParse": testFlaky}; !reflect.DeepEqual(j.unstableTests, want) {
+		t.Errorf("filterFlakyTests() unstable tests = %v, want %v", j.unstableTests, want)
+	}
+}
+
+func TestFailedTestsRunPattern(t *testing.T) {
+	got := failedTestsRunPattern([]string{"TestA/sub_1", "TestA/sub_2", "TestB.x"})
+	if want := `^(TestA|TestB\.x)$`; got != want {
+		t.Errorf("failedTestsRunPattern() = %q, want %q", got, want)
+	}
+}
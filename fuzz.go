@@ -0,0 +1,390 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// defaultFuzzTime is the duration of the fuzzing when none is given.
+const defaultFuzzTime = 30 * time.Second
+
+// fuzzableTypes are the parameter types supported by the Go fuzzing engine.
+var fuzzableTypes = map[string]bool{
+	"string": true, "[]byte": true, "bool": true, "byte": true, "rune": true,
+	"int": true, "int8": true, "int16": true, "int32": true, "int64": true,
+	"uint": true, "uint8": true, "uint16": true, "uint32": true, "uint64": true,
+	"float32": true, "float64": true,
+}
+
+var (
+	regFuzzFailingInput = regexp.MustCompile(`Failing input written to (testdata/fuzz/\S+)`)
+	regFuzzCorpus       = regexp.MustCompile("(?s)CORPUS:\\s*(?:```(?:json)?)?\\s*(\\[.*\\])")
+)
+
+// fuzzTarget is a function to fuzz.
+type fuzzTarget struct {
+	file       string
+	name       string
+	fuzzName   string
+	paramTypes []string
+	code       string
+}
+
+// runFuzzCommand implements `goia fuzz [-fuzztime duration] <func> [path]`.
+func runFuzzCommand(args *appArgs, cmdArgs []string) error {
+	flags := flag.NewFlagSet("fuzz", flag.ExitOnError)
+	fuzzTime := flags.Duration("fuzztime", defaultFuzzTime, "maximum duration of the fuzzing")
+	if err := flags.Parse(cmdArgs); err != nil {
+		return err
+	}
+
+	if flags.NArg() < 1 {
+		return errors.New("usage: goia fuzz [-fuzztime duration] <func> [path]")
+	}
+
+	path := "."
+	if flags.NArg() > 1 {
+		path = flags.Arg(1)
+	}
+
+	j, err := newCommandJob(args, path)
+	if err != nil {
+		return err
+	}
+	defer j.closeSandbox()
+
+	target, err := j.findFuzzTarget(path, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	return j.fuzz(target, *fuzzTime)
+}
+
+// findFuzzTarget finds the function to fuzz in the Go files of the given path.
+func (j *job) findFuzzTarget(path, funcName string) (*fuzzTarget, error) {
+	files, err := goSourceFiles(path)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, file := range files {
+		fs := token.NewFileSet()
+		node, err := parser.ParseFile(fs, file, nil, parser.ParseComments)
+		if err != nil {
+			continue
+		}
+
+		for _, decl := range node.Decls {
+			funcDecl, ok := decl.(*ast.FuncDecl)
+			if !ok || funcDecl.Recv != nil || funcDecl.Name.Name != funcName {
+				continue
+			}
+
+			target := &fuzzTarget{
+				name:     funcName,
+				fuzzName: "Fuzz" + upperFirst(funcName),
+			}
+
+			for _, param := range funcDecl.Type.Params.List {
+				paramType := exprToString(param.Type)
+				if !fuzzableTypes[paramType] {
+					return nil, fmt.Errorf(j.t("parameter of type %s of %s cannot be fuzzed"), paramType, funcName)
+				}
+				for range max(1, len(param.Names)) {
+					target.paramTypes = append(target.paramTypes, paramType)
+				}
+			}
+			if len(target.paramTypes) == 0 {
+				return nil, fmt.Errorf(j.t("function %s has no input to fuzz"), funcName)
+			}
+
+			if target.file, err = filepath.Rel(j.fileDir, file); err != nil {
+				return nil, err
+			}
+			if target.code, err = j.extractFunctionFromLine(target.file, fs.Position(funcDecl.Pos()).Line); err != nil {
+				return nil, err
+			}
+
+			return target, nil
+		}
+	}
+
+	return nil, fmt.Errorf(j.t("function %s not found"), funcName)
+}
+
+// goSourceFiles returns the non test Go files of a path, which is either a file or a folder.
+func goSourceFiles(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if !info.IsDir() {
+		absPath, err := filepath.Abs(path)
+		return []string{absPath}, err
+	}
+
+	matches, err := filepath.Glob(filepath.Join(path, "*.go"))
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, match := range matches {
+		if strings.HasSuffix(match, "_test.go") {
+			continue
+		}
+		absPath, err := filepath.Abs(match)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, absPath)
+	}
+	return files, nil
+}
+
+// upperFirst returns the string with its first letter in upper case.
+func upperFirst(s string) string {
+	if s == "" {
+		return s
+	}
+	r := []rune(s)
+	r[0] = unicode.ToUpper(r[0])
+	return string(r)
+}
+
+// getPromptToAskFuzzTarget returns a prompt asking for a fuzz target and its seed corpus.
+func (j *job) getPromptToAskFuzzTarget(target *fuzzTarget) string {
+	return j.t("I have some Golang code") + ":\n\n" + target.code + "\n\n" +
+		fmt.Sprintf(j.t("Write a Go fuzz test named %s for the function %s, in the test file %s"), target.fuzzName, target.name, j.currentTestFileName) + ". " +
+		j.t("The fuzz test must check properties that hold for any input, such as the absence of panics, round trips or invariants") + ".\n\n" +
+		j.t("Strictly use the following format for each part") + ": `**<folder/file.go>** <code ici>`.\n\n" +
+		fmt.Sprintf(j.t("Then give a seed corpus as a JSON array of arrays of arguments of types (%s), in the form"), strings.Join(target.paramTypes, ", ")) +
+		": \"CORPUS: [[...], [...]]\".\n\n" +
+		j.t("Reply without comment or explanation")
+}
+
+// writeFuzzCorpus writes the seed corpus given by the model in testdata/fuzz/<FuzzName>.
+func (j *job) writeFuzzCorpus(target *fuzzTarget, response string) error {
+	matches := regFuzzCorpus.FindStringSubmatch(response)
+	if matches == nil {
+		log.Warn(j.t("The response contains no seed corpus"))
+		return nil
+	}
+
+	var seeds [][]json.RawMessage
+	if err := json.Unmarshal([]byte(matches[1]), &seeds); err != nil {
+		return fmt.Errorf(j.t("error decoding the seed corpus")+": %v", err)
+	}
+
+	dir := filepath.Join(j.fileDir, filepath.Dir(target.file), "testdata", "fuzz", target.fuzzName)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	for i, seed := range seeds {
+		if len(seed) != len(target.paramTypes) {
+			continue
+		}
+
+		content := "go test fuzz v1\n"
+		valid := true
+		for k, raw := range seed {
+			value, err := fuzzCorpusValue(target.paramTypes[k], raw)
+			if err != nil {
+				valid = false
+				break
+			}
+			content += value + "\n"
+		}
+		if !valid {
+			continue
+		}
+
+		if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("seed-%d", i)), []byte(content), 0o644); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// fuzzCorpusValue encodes a JSON value in the format of the Go fuzzing corpus files.
+func fuzzCorpusValue(paramType string, raw json.RawMessage) (string, error) {
+	switch paramType {
+	case "string", "[]byte":
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s(%s)", paramType, strconv.Quote(s)), nil
+	case "bool":
+		var b bool
+		if err := json.Unmarshal(raw, &b); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("bool(%t)", b), nil
+	case "rune", "byte":
+		var s string
+		if err := json.Unmarshal(raw, &s); err == nil && len([]rune(s)) == 1 {
+			return fmt.Sprintf("%s(%s)", paramType, strconv.QuoteRune([]rune(s)[0])), nil
+		}
+		fallthrough
+	default:
+		var n json.Number
+		if err := json.Unmarshal(raw, &n); err != nil {
+			return "", err
+		}
+
+		var err error
+		switch {
+		case strings.HasPrefix(paramType, "float"):
+			_, err = strconv.ParseFloat(n.String(), 64)
+		case strings.HasPrefix(paramType, "uint") || paramType == "byte":
+			_, err = strconv.ParseUint(n.String(), 10, 64)
+		default:
+			_, err = strconv.ParseInt(n.String(), 10, 64)
+		}
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s(%s)", paramType, n.String()), nil
+	}
+}
+
+// runFuzzing runs the fuzz target and returns the output and the path of the failing input, if any.
+func (j *job) runFuzzing(target *fuzzTarget, fuzzTime time.Duration) (string, string, error) {
+	pkgDir := "./" + filepath.ToSlash(filepath.Dir(target.file))
+
+	log.Infof(j.t("Fuzzing %s for %s"), target.fuzzName, fuzzTime)
+	result, err := j.runCommand(nil, nil, "go", "test", "-run", "^$",
+		"-fuzz", "^"+target.fuzzName+"$", "-fuzztime", fuzzTime.String(), pkgDir)
+	if result == nil {
+		return "", "", err
+	}
+
+	output := result.Output()
+	if err == nil {
+		return output, "", nil
+	}
+
+	matches := regFuzzFailingInput.FindStringSubmatch(output)
+	if matches == nil {
+		return output, "", fmt.Errorf(j.t("the fuzzing failed without failing input")+": %s", output)
+	}
+
+	// In a sandbox, the failing input is written in the working copy.
+	if err := j.copyFromSandbox(filepath.Join(filepath.Dir(target.file), "testdata", "fuzz", target.fuzzName)); err != nil {
+		return output, "", err
+	}
+
+	return output, filepath.Join(filepath.Dir(target.file), matches[1]), nil
+}
+
+// fuzzCrasherPrompt returns a prompt asking to fix the code for the failing input and to add a regression test.
+func (j *job) fuzzCrasherPrompt(target *fuzzTarget, output, crasher string) (string, error) {
+	input, err := os.ReadFile(filepath.Join(j.fileDir, crasher))
+	if err != nil {
+		return "", err
+	}
+
+	funcCode := target.code
+	for _, frame := range j.moduleFrames(parseStackTrace(output)) {
+		if strings.HasSuffix(frame.File, "_test.go") {
+			continue
+		}
+		if _, code, err := j.extractDeclarationFromLine(frame.File, frame.Line); err == nil {
+			funcCode = code
+			break
+		}
+	}
+
+	return fmt.Sprintf(j.t("The fuzz test %s found an input that makes the following code fail"), target.fuzzName) + ":\n\n" +
+		funcCode + "\n\n" +
+		j.t("Minimized failing input") + " :\n\n" + string(input) + "\n\n" +
+		j.t("Error") + " : " + output + "\n\n" +
+		fmt.Sprintf(j.t("Fix the source file %s and add a regression test for this input in the test file %s"), target.file, j.currentTestFileName) + ".\n\n" +
+		j.t("Strictly use the following format for each part") + ": `**<folder/file.go>** <code ici>`.\n\n" +
+		j.t("Reply without comment or explanation"), nil
+}
+
+// fuzz generates a fuzz target for the function, runs it and fixes the code if a failing input is found.
+func (j *job) fuzz(target *fuzzTarget, fuzzTime time.Duration) error {
+	j.currentSourceFileName = target.file
+	j.currentTestFileName = strings.TrimSuffix(target.file, ".go") + "_test.go"
+	j.fileName = target.file
+	if err := j.loadCurrentFiles(); err != nil {
+		return err
+	}
+
+	prompt := j.getPromptToAskFuzzTarget(target)
+	log.Infof("\nprompt: "+blue("%s")+"\n\n", prompt)
+
+	response, err := j.callIA(prompt)
+	if err != nil {
+		return err
+	}
+	log.Infof("API response:\n\n"+green("\"%s\"")+"\n\n", response)
+
+	if err := j.applyResponse(regFuzzCorpus.ReplaceAllString(response, "")); err != nil {
+		return err
+	}
+	if err := j.writeFuzzCorpus(target, response); err != nil {
+		return err
+	}
+
+	// The fuzz target must compile before fuzzing.
+	if prompt, err := j.compileTestsPrompt(); err != nil {
+		return err
+	} else if prompt != "" {
+		if err := j.repairLoop(prompt, j.compileTestsPrompt); err != nil {
+			return err
+		}
+	}
+
+	output, crasher, err := j.runFuzzing(target, fuzzTime)
+	if err != nil {
+		return err
+	}
+	if crasher == "" {
+		log.Info(j.t("No failing input found"))
+		return nil
+	}
+
+	log.Infof(j.t("Failing input found")+": %s", crasher)
+	prompt, err = j.fuzzCrasherPrompt(target, output, crasher)
+	if err != nil {
+		return err
+	}
+
+	// The failing input stays in testdata, so `go test` replays it as a regression test.
+	return j.repairLoop(prompt, func() (string, error) {
+		if prompt, err := j.compileTestsPrompt(); err != nil || prompt != "" {
+			return prompt, err
+		}
+
+		result, err := j.runCommand(nil, nil, "go", "test", "-count=1", "./"+filepath.ToSlash(filepath.Dir(target.file)))
+		if err == nil {
+			return "", nil
+		}
+		if result == nil {
+			return "", err
+		}
+		return j.fuzzCrasherPrompt(target, result.Output(), crasher)
+	})
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFuzzCorpusValue(t *testing.T) {
+	tests := []struct {
+		paramType string
+		raw       string
+		want      string
+		wantErr   bool
+	}{
+		{"string", `"a\"b"`, `string("a\"b")`, false},
+		{"[]byte", `"xyz"`, `[]byte("xyz")`, false},
+		{"bool", `true`, `bool(true)`, false},
+		{"rune", `"é"`, `rune('é')`, false},
+		{"byte", `65`, `byte(65)`, false},
+		{"int", `-12`, `int(-12)`, false},
+		{"uint8", `-1`, "", true},
+		{"float64", `1.5`, `float64(1.5)`, false},
+		{"int64", `1.5`, "", true},
+		{"string", `3`, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.paramType+" "+tt.raw, func(t *testing.T) {
+			got, err := fuzzCorpusValue(tt.paramType, json.RawMessage(tt.raw))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("fuzzCorpusValue() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("fuzzCorpusValue() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteFuzzCorpus(t *testing.T) {
+	dir := t.TempDir()
+	j := &job{fileDir: dir}
+	target := &fuzzTarget{file: filepath.Join("p", "p.go"), fuzzName: "FuzzParse", paramTypes: []string{"string", "int"}}
+
+	response := "**p/p_test.go** ...\n\nCORPUS:\n```json\n[[\"a\", 1], [\"b\"], [\"c\", \"x\"], [\"d\", 4]]\n```"
+	if err := j.writeFuzzCorpus(target, response); err != nil {
+		t.Fatalf("writeFuzzCorpus() error = %v", err)
+	}
+
+	want := map[string]string{
+		"seed-0": "go test fuzz v1\nstring(\"a\")\nint(1)\n",
+		"seed-3": "go test fuzz v1\nstring(\"d\")\nint(4)\n",
+	}
+	if got := treeFiles(t, filepath.Join(dir, "p", "testdata", "fuzz", "FuzzParse")); !reflect.DeepEqual(got, want) {
+		t.Errorf("writeFuzzCorpus() wrote %v, want %v", got, want)
+	}
+}
+
+func TestRegFuzzFailingInput(t *testing.T) {
+	output := "--- FAIL: FuzzParse (0.02s)\n" +
+		"    --- FAIL: FuzzParse (0.00s)\n" +
+		"        testing.go:1591: panic: boom\n\n" +
+		"    Failing input written to testdata/fuzz/FuzzParse/771e938e4458e983\n" +
+		"    To re-run:\n"
+
+	matches := regFuzzFailingInput.FindStringSubmatch(output)
+	if matches == nil || matches[1] != "testdata/fuzz/FuzzParse/771e938e4458e983" {
+		t.Errorf("regFuzzFailingInput = %q", matches)
+	}
+}
+
+func TestUpperFirst(t *testing.T) {
+	tests := map[string]string{"": "", "parse": "Parse", "Parse": "Parse", "été": "Été"}
+	for in, want := range tests {
+		if got := upperFirst(in); got != want {
+			t.Errorf("upperFirst(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
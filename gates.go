@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// GatesConfig configures the validation gates run after each successful build.
+type GatesConfig struct {
+	// Vet runs `go vet` on the changed packages.
+	Vet bool `yaml:"vet"`
+	// Staticcheck runs `staticcheck` on the changed packages if it is installed.
+	Staticcheck bool `yaml:"staticcheck"`
+	// Race runs the tests of the changed packages with the race detector.
+	Race bool `yaml:"race"`
+	// Gofmt checks that the changed files are formatted with gofmt.
+	Gofmt bool `yaml:"gofmt"`
+	// Commands are shell commands run in the module folder that must succeed.
+	Commands []string `yaml:"commands"`
+}
+
+// Merge merges the given GatesConfig with this one.
+func (cfg *GatesConfig) Merge(newCfg GatesConfig) {
+	if newCfg.Vet {
+		cfg.Vet = newCfg.Vet
+	}
+	if newCfg.Staticcheck {
+		cfg.Staticcheck = newCfg.Staticcheck
+	}
+	if newCfg.Race {
+		cfg.Race = newCfg.Race
+	}
+	if newCfg.Gofmt {
+		cfg.Gofmt = newCfg.Gofmt
+	}
+	if len(newCfg.Commands) > 0 {
+		cfg.Commands = append(cfg.Commands, newCfg.Commands...)
+	}
+}
+
+// enabled checks whether at least one gate is enabled.
+func (cfg GatesConfig) enabled() bool {
+	return cfg.Vet || cfg.Staticcheck || cfg.Race || cfg.Gofmt || len(cfg.Commands) > 0
+}
+
+// gateFinding is the failure of a validation gate.
+type gateFinding struct {
+	Gate        string
+	Output      string
+	Diagnostics []diagnostic
+}
+
+// vetDiagnostic is a diagnostic printed by `go vet -json`.
+type vetDiagnostic struct {
+	Posn    string `json:"posn"`
+	Message string `json:"message"`
+}
+
+// parseVetJSON parses the output of `go vet -json`, made of one JSON object per package,
+// mapping the analyzers to their diagnostics.
+func parseVetJSON(output string) ([]diagnostic, error) {
+	var diagnostics []diagnostic
+
+	decoder := json.NewDecoder(strings.NewReader(output))
+	for {
+		var packages map[string]map[string]json.RawMessage
+		if err := decoder.Decode(&packages); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		for _, analyzers := range packages {
+			for analyzer, raw := range analyzers {
+				var vetDiagnostics []vetDiagnostic
+				// An analyzer that failed reports an error object instead of a list.
+				if err := json.Unmarshal(raw, &vetDiagnostics); err != nil {
+					continue
+				}
+
+				for _, vd := range vetDiagnostics {
+					for _, d := range parseDiagnostics(vd.Posn + ": " + vd.Message) {
+						d.Category = diagnosticCategory("vet/" + analyzer)
+						diagnostics = append(diagnostics, d)
+					}
+				}
+			}
+		}
+	}
+
+	return diagnostics, nil
+}
+
+// runGoVet runs `go vet` on the given packages.
+func (j *job) runGoVet(patterns []string) (*gateFinding, error) {
+	cmd := exec.Command("go", append([]string{"vet", "-json"}, patterns...)...)
+	cmd.Dir = j.fileDir
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+
+	diagnostics, parseErr := parseVetJSON(stdout.String())
+	if parseErr != nil {
+		return nil, fmt.Errorf(j.t("error decoding go vet output")+": %v", parseErr)
+	}
+
+	// Errors preventing the analysis, such as type errors, are printed as text.
+	if err != nil {
+		diagnostics = append(diagnostics, parseDiagnostics(stderr.String())...)
+	}
+
+	if len(diagnostics) == 0 && err == nil {
+		return nil, nil
+	}
+
+	return &gateFinding{Gate: "go vet", Output: stdout.String() + stderr.String(), Diagnostics: diagnostics}, nil
+}
+
+// runStaticcheck runs `staticcheck` on the given packages, if it is installed.
+func (j *job) runStaticcheck(patterns []string) (*gateFinding, error) {
+	if _, err := exec.LookPath("staticcheck"); err != nil {
+		log.Warn(j.t("staticcheck is not installed, the gate is skipped"))
+		return nil, nil
+	}
+
+	cmd := exec.Command("staticcheck", patterns...)
+	cmd.Dir = j.fileDir
+
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+
+	if err := cmd.Run(); err == nil {
+		return nil, nil
+	}
+
+	return &gateFinding{Gate: "staticcheck", Output: out.String(), Diagnostics: parseDiagnostics(out.String())}, nil
+}
+
+// cgoEnabled checks whether cgo is enabled for the module, as the race detector requires it.
+func (j *job) cgoEnabled() bool {
+	cmd := exec.Command("go", "env", "CGO_ENABLED")
+	cmd.Dir = j.fileDir
+	out, err := cmd.Output()
+	return err == nil && strings.TrimSpace(string(out)) == "1"
+}
+
+// raceDetected checks whether the output of `go test -race` reports a data race.
+func raceDetected(output string) bool {
+	return strings.Contains(output, "WARNING: DATA RACE") || strings.Contains(output, "race detected during execution of test")
+}
+
+// runRaceDetector runs the tests of the given packages with the race detector.
+// The gate is skipped without cgo, as there is nothing the model could fix. Only the data races are
+// reported, the failing tests are left to the test step and its reruns of the flaky tests.
+func (j *job) runRaceDetector(patterns []string) (*gateFinding, error) {
+	if !j.cgoEnabled() {
+		log.Warn(j.t("cgo is not enabled, the race detector gate is skipped"))
+		return nil, nil
+	}
+
+	result, err := j.runCommand(nil, nil, "go", append([]string{"test", "-race", "-count=1"}, patterns...)...)
+	if err == nil {
+		return nil, nil
+	}
+	if result == nil {
+		return nil, err
+	}
+	if !raceDetected(result.Output()) {
+		return nil, nil
+	}
+
+	return &gateFinding{Gate: "go test -race", Output: result.Output(), Diagnostics: parseDiagnostics(result.Output())}, nil
+}
+
+// runGofmtCheck lists the changed files that are not formatted with gofmt.
+func (j *job) runGofmtCheck() (*gateFinding, error) {
+	var files []string
+	for _, path := range j.changedFilePaths() {
+		if _, err := os.Stat(path); err == nil && filepath.Ext(path) == ".go" {
+			files = append(files, path)
+		}
+	}
+
+	if len(files) == 0 {
+		return nil, nil
+	}
+
+	out, err := exec.Command("gofmt", append([]string{"-l"}, files...)...).CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf(j.t("error running gofmt")+": %v - %s", err, out)
+	}
+
+	var diagnostics []diagnostic
+	for _, file := range strings.Fields(string(out)) {
+		diagnostics = append(diagnostics, diagnostic{
+			File:     file,
+			Line:     1,
+			Message:  j.t("file is not formatted with gofmt"),
+			Category: "gofmt",
+		})
+	}
+
+	if len(diagnostics) == 0 {
+		return nil, nil
+	}
+
+	return &gateFinding{Gate: "gofmt", Output: string(out), Diagnostics: diagnostics}, nil
+}
+
+// runCustomGate runs a shell command in the module folder.
+func (j *job) runCustomGate(command string) (*gateFinding, error) {
+	result, err := j.runCommand(nil, nil, "sh", "-c", command)
+	if err == nil {
+		return nil, nil
+	}
+	if result == nil {
+		return nil, err
+	}
+
+	return &gateFinding{Gate: command, Output: result.Output(), Diagnostics: parseDiagnostics(result.Output())}, nil
+}
+
+// runGates runs the configured validation gates on the changed packages and returns their findings.
+func (j *job) runGates() ([]gateFinding, error) {
+	if !j.gatesConfig.enabled() {
+		return nil, nil
+	}
+
+	patterns, err := j.packagesToBuild()
+	if err != nil || len(patterns) == 0 {
+		patterns = []string{"./..."}
+	}
+
+	type gate struct {
+		enabled bool
+		run     func() (*gateFinding, error)
+	}
+
+	gates := []gate{
+		{enabled: j.gatesConfig.Gofmt, run: j.runGofmtCheck},
+		{enabled: j.gatesConfig.Vet, run: func() (*gateFinding, error) { return j.runGoVet(patterns) }},
+		{enabled: j.gatesConfig.Staticcheck, run: func() (*gateFinding, error) { return j.runStaticcheck(patterns) }},
+		{enabled: j.gatesConfig.Race, run: func() (*gateFinding, error) { return j.runRaceDetector(patterns) }},
+	}
+	for _, command := range j.gatesConfig.Commands {
+		gates = append(gates, gate{enabled: true, run: func() (*gateFinding, error) { return j.runCustomGate(command) }})
+	}
+
+	var findings []gateFinding
+	for _, g := range gates {
+		if !g.enabled {
+			continue
+		}
+
+		finding, err := g.run()
+		if err != nil {
+			return nil, err
+		}
+		if finding != nil {
+			log.Infof("------------------------------------ gate %s (failed): \n\n %s", finding.Gate, finding.Output)
+			findings = append(findings, *finding)
+		}
+	}
+
+	return findings, nil
+}
+
+// gatesPrompt returns a repair prompt for the findings of the validation gates.
+func (j *job) gatesPrompt(findings []gateFinding) (string, error) {
+	var diagnostics []diagnostic
+	var outputs strings.Builder
+
+	for _, finding := range findings {
+		diagnostics = append(diagnostics, finding.Diagnostics...)
+		if len(finding.Diagnostics) == 0 {
+			outputs.WriteString(finding.Gate + ":\n" + finding.Output + "\n\n")
+		}
+	}
+
+	funcCode, err := j.diagnosticsForPrompt(diagnostics)
+	if err != nil {
+		return "", err
+	}
+
+	prompt := j.t("The code builds, but the following checks reported problems") + ":\n\n" + funcCode + "\n\n"
+	if outputs.Len() > 0 {
+		prompt += j.t("Error") + " : " + outputs.String()
+	}
+	prompt += j.t("responds without adding comments or explanations") + "\n\n" +
+		j.declOpsPrompt() + ".\n\n" +
+		j.t("Generates a concise response that specifies the file to modify in the form: \"MODIFY: <function or section name> (source file, not test file)\"") + "." +
+		j.t("Then provide the corrected code in the form: \"CODE: <corrected code>\"") + "."
+
+	return prompt, nil
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseVetJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		output  string
+		want    []diagnostic
+		wantErr bool
+	}{
+		{
+			name:   "no package",
+			output: "",
+			want:   nil,
+		},
+		{
+			name:   "package without diagnostic",
+			output: "{\n\t\"example.com/m/a\": {}\n}\n",
+			want:   nil,
+		},
+		{
+			name: "diagnostics of several packages",
+			output: `{"example.com/m/a": {"printf": [{"posn": "/m/a/a.go:5:2", "message": "fmt.Sprintf format %d has arg s of wrong type string"}]}}` + "\n" +
+				`{"example.com/m/b": {"unreachable": [{"posn": "/m/b/b.go:9:2", "message": "unreachable code"}]}}` + "\n",
+			want: []diagnostic{
+				{File: "/m/a/a.go", Line: 5, Col: 2, Message: "fmt.Sprintf format %d has arg s of wrong type string", Category: "vet/printf"},
+				{File: "/m/b/b.go", Line: 9, Col: 2, Message: "unreachable code", Category: "vet/unreachable"},
+			},
+		},
+		{
+			name:   "analyzer failure",
+			output: `{"example.com/m/a": {"printf": {"error": "analysis skipped"}}}`,
+			want:   nil,
+		},
+		{
+			name:    "invalid json",
+			output:  "{",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseVetJSON(tt.output)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseVetJSON() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseVetJSON() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGatesConfigEnabled(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  GatesConfig
+		want bool
+	}{
+		{"nothing", GatesConfig{}, false},
+		{"vet", GatesConfig{Vet: true}, true},
+		{"race", GatesConfig{Race: true}, true},
+		{"command", GatesConfig{Commands: []string{"make lint"}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.cfg.enabled(); got != tt.want {
+				t.Errorf("enabled() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunGatesWithoutGate(t *testing.T) {
+	// The folder does not exist: listing its packages would fail.
+	j := &job{fileDir: "/nonexistent/goia"}
+	findings, err := j.runGates()
+	if err != nil || findings != nil {
+		t.Errorf("runGates() = %v, %v, want no finding", findings, err)
+	}
+}
+
+func TestRunRaceDetectorWithoutCgo(t *testing.T) {
+	t.Setenv("CGO_ENABLED", "0")
+
+	dir := writeModule(t, map[string]string{
+		"go.mod":      "module example.com/m\n\ngo 1.22\n",
+		"a_test.go":   "package m\n\nimport \"testing\"\n\nfunc TestA(t *testing.T) {}\n",
+		"a/a.go":      "package a\n",
+		"a/a_test.go": "package a\n",
+	})
+
+	j := &job{fileDir: dir}
+	finding, err := j.runRaceDetector([]string{"./..."})
+	if err != nil || finding != nil {
+		t.Errorf("runRaceDetector() = %v, %v, want the gate skipped", finding, err)
+	}
+}
+
+func TestRaceDetected(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   bool
+	}{
+		{"passing tests", "ok  \texample.com/m\t0.01s\n", false},
+		{"failing test", "--- FAIL: TestA (0.00s)\n    a_test.go:9: got 1, want 2\nFAIL\n", false},
+		{"data race", "==================\nWARNING: DATA RACE\nWrite at 0x00c000 by goroutine 8:\n", true},
+		{"race failing a test", "--- FAIL: TestA (0.00s)\n    testing.go:1465: race detected during execution of test\n", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := raceDetected(tt.output); got != tt.want {
+				t.Errorf("raceDetected() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunRaceDetector(t *testing.T) {
+	j := &job{fileDir: t.TempDir()}
+	if !j.cgoEnabled() {
+		t.Skip("the race detector requires cgo")
+	}
+
+	tests := []struct {
+		name     string
+		test     string
+		wantRace bool
+	}{
+		{
+			name: "failing test without race",
+			test: "func TestA(t *testing.T) { t.Fatal(\"failed\") }\n",
+		},
+		{
+			name:     "data race",
+			test:     "func TestA(t *testing.T) {\n\tn := 0\n\tdone := make(chan bool)\n\tgo func() { n++; done <- true }()\n\tn++\n\t<-done\n}\n",
+			wantRace: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := writeModule(t, map[string]string{
+				"go.mod":    "module example.com/m\n\ngo 1.22\n",
+				"a_test.go": "package m\n\nimport \"testing\"\n\n" + tt.test,
+			})
+
+			j := &job{fileDir: dir}
+			finding, err := j.runRaceDetector([]string{"./..."})
+			if err != nil {
+				t.Fatalf("runRaceDetector() error = %v", err)
+			}
+			if (finding != nil) != tt.wantRace {
+				t.Errorf("runRaceDetector() = %v, want a finding %v", finding, tt.wantRace)
+			}
+		})
+	}
+}
+
+func TestRunGofmtCheck(t *testing.T) {
+	dir := writeModule(t, map[string]string{
+		"go.mod":         "module example.com/m\n\ngo 1.22\n",
+		"ok.go":          "package m\n\nfunc OK() {}\n",
+		"unformatted.go": "package m\n\nfunc  Bad( ) {\n}\n",
+	})
+
+	tests := []struct {
+		name    string
+		changed []string
+		want    int
+	}{
+		{"formatted file", []string{"ok.go"}, 0},
+		{"unformatted file", []string{"ok.go", "unformatted.go"}, 1},
+		{"removed file", []string{"missing.go"}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			j := &job{fileDir: dir, filesChanged: tt.changed}
+			finding, err := j.runGofmtCheck()
+			if err != nil {
+				t.Fatalf("runGofmtCheck() error = %v", err)
+			}
+			got := 0
+			if finding != nil {
+				got = len(finding.Diagnostics)
+			}
+			if got != tt.want {
+				t.Errorf("runGofmtCheck() found %d files, want %d", got, tt.want)
+			}
+		})
+	}
+}
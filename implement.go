@@ -0,0 +1,890 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// runImplementCommand implements `goia implement --from-tests [path]`.
+func runImplementCommand(args *appArgs, cmdArgs []string) error {
+	flags := flag.NewFlagSet("implement", flag.ExitOnError)
+	fromTests := flags.Bool("from-tests", false, "implement the package until its tests pass, without changing them")
+	if err := flags.Parse(cmdArgs); err != nil {
+		return err
+	}
+
+	if !*fromTests {
+		return errors.New("usage: goia implement --from-tests [path]")
+	}
+
+	path := "."
+	if flags.NArg() > 0 {
+		path = flags.Arg(0)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		path = filepath.Dir(path)
+	}
+
+	j, err := newCommandJob(args, path)
+	if err != nil {
+		return err
+	}
+	defer j.closeSandbox()
+
+	absDir, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+
+	return j.implementFromTests(absDir)
+}
+
+// freezeTestFiles reads the test files of a folder, which must never be changed.
+func (j *job) freezeTestFiles(absDir string) (map[string][]byte, error) {
+	matches, err := filepath.Glob(filepath.Join(absDir, "*_test.go"))
+	if err != nil {
+		return nil, err
+	}
+
+	frozen := make(map[string][]byte)
+	for _, match := range matches {
+		data, err := os.ReadFile(match)
+		if err != nil {
+			return nil, err
+		}
+
+		rel, err := filepath.Rel(j.fileDir, match)
+		if err != nil {
+			return nil, err
+		}
+		frozen[rel] = data
+	}
+
+	return frozen, nil
+}
+
+// restoreFrozenFiles rewrites the frozen test files changed since they were frozen.
+func (j *job) restoreFrozenFiles(frozen map[string][]byte) error {
+	for file, content := range frozen {
+		path := filepath.Join(j.fileDir, file)
+		current, err := os.ReadFile(path)
+		if err == nil && bytes.Equal(current, content) {
+			continue
+		}
+
+		log.Warnf(j.t("The test file %s is frozen, it is restored"), file)
+		if err := os.WriteFile(path, content, 0o644); err != nil {
+			return err
+		}
+		if err := j.reloadFile(file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// implementFromTests generates the stubs of the declarations referenced by the tests of the folder,
+// then asks the model to implement them until the tests pass.
+func (j *job) implementFromTests(absDir string) error {
+	relDir, err := filepath.Rel(j.fileDir, absDir)
+	if err != nil {
+		return err
+	}
+
+	frozen, err := j.freezeTestFiles(absDir)
+	if err != nil {
+		return err
+	}
+	if len(frozen) == 0 {
+		return fmt.Errorf(j.t("no test file found in %s"), relDir)
+	}
+
+	j.frozenFiles = make(map[string]bool)
+	for file := range frozen {
+		j.frozenFiles[file] = true
+	}
+
+	stubs, err := j.testStubs(absDir)
+	if err != nil {
+		return err
+	}
+
+	for _, stub := range stubs {
+		log.Infof(j.t("Stubs generated in %s")+":\n\n%s", stub.file, magenta(stub.code))
+
+		j.currentSourceFileName = stub.file
+		if _, err := os.Stat(filepath.Join(j.fileDir, stub.file)); errors.Is(err, os.ErrNotExist) {
+			if err := j.createGoFile(stub.file); err != nil {
+				return err
+			}
+		}
+		if err := j.loadCurrentFiles(); err != nil {
+			return err
+		}
+		if err := j.fixCodeAndWriteFile(stub.file, stub.code); err != nil {
+			return err
+		}
+	}
+
+	check := func() (string, error) {
+		if err := j.restoreFrozenFiles(frozen); err != nil {
+			return "", err
+		}
+
+		result, err := j.runCommand(nil, nil, "go", "test", "-count=1", "./"+filepath.ToSlash(relDir))
+		if err == nil {
+			log.Info(j.t("The tests pass"))
+			return "", nil
+		}
+		if result == nil {
+			return "", err
+		}
+		return j.implementPrompt(absDir, frozen, result.Output())
+	}
+
+	prompt, err := check()
+	if err != nil || prompt == "" {
+		return err
+	}
+
+	return j.repairLoop(prompt, check)
+}
+
+// implementPrompt returns a prompt asking to implement the source files of the folder so that its tests pass.
+func (j *job) implementPrompt(absDir string, frozen map[string][]byte, output string) (string, error) {
+	var tests, sources strings.Builder
+
+	var testFiles []string
+	for file := range frozen {
+		testFiles = append(testFiles, file)
+	}
+	sort.Strings(testFiles)
+	for _, file := range testFiles {
+		fmt.Fprintf(&tests, "**%s**\n```go\n%s\n```\n\n", file, frozen[file])
+	}
+
+	sourceFiles, err := goSourceFiles(absDir)
+	if err != nil {
+		return "", err
+	}
+	for _, path := range sourceFiles {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		rel, err := filepath.Rel(j.fileDir, path)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&sources, "**%s**\n```go\n%s\n```\n\n", rel, data)
+	}
+
+	return j.t("Implement the source code so that the following tests pass") + ". " +
+		j.t("The test files are frozen: never modify them, only modify or create source files") + ".\n\n" +
+		j.t("Tests") + " :\n\n" + tests.String() +
+		j.t("Source files") + " :\n\n" + sources.String() +
+		j.t("Error") + " : " + output + "\n\n" +
+		j.t("Strictly use the following format for each part") + ": `**<folder/file.go>** <code ici>`.\n\n" +
+		j.t("Reply without comment or explanation"), nil
+}
+
+// stubFile is the code of the stubs to add to a source file.
+type stubFile struct {
+	file string
+	code string
+}
+
+// stubCollector infers the declarations referenced by the tests but missing from the package.
+type stubCollector struct {
+	fs      *token.FileSet
+	pkg     *types.Package
+	info    *types.Info
+	files   map[string]*ast.File
+	decls   map[string]map[string]string
+	imports map[string]map[string]string
+	current string
+}
+
+// sourceImporter returns an importer type-checking the imported packages from their sources. Its build
+// context runs the go command in the module of the files, which resolves the imports with its vendor
+// directory or the module cache, instead of the module of the working directory.
+func (j *job) sourceImporter(fs *token.FileSet) types.ImporterFrom {
+	ctxt := build.Default
+	if dir, err := filepath.Abs(j.fileDir); err == nil {
+		ctxt.Dir = dir
+	}
+	// The files using cgo are left out, their pure Go versions are checked instead.
+	ctxt.CgoEnabled = false
+	return &srcImporter{ctxt: ctxt, fs: fs, packages: make(map[string]*types.Package)}
+}
+
+// srcImporter imports packages from their sources, found with its own build context.
+type srcImporter struct {
+	ctxt     build.Context
+	fs       *token.FileSet
+	packages map[string]*types.Package
+}
+
+// Import imports a package.
+func (p *srcImporter) Import(path string) (*types.Package, error) {
+	return p.ImportFrom(path, p.ctxt.Dir, 0)
+}
+
+// ImportFrom imports a package from a folder. Only the signatures of the functions are checked.
+func (p *srcImporter) ImportFrom(path, dir string, mode types.ImportMode) (*types.Package, error) {
+	if path == "unsafe" {
+		return types.Unsafe, nil
+	}
+
+	bp, err := p.ctxt.Import(path, dir, 0)
+	if err != nil {
+		return nil, err
+	}
+	if pkg, ok := p.packages[bp.ImportPath]; ok {
+		if pkg == nil {
+			return nil, fmt.Errorf("import cycle through package %q", bp.ImportPath)
+		}
+		return pkg, nil
+	}
+	// The package is marked while it is checked, to detect the import cycles.
+	p.packages[bp.ImportPath] = nil
+
+	var files []*ast.File
+	for _, name := range bp.GoFiles {
+		file, err := parser.ParseFile(p.fs, filepath.Join(bp.Dir, name), nil, parser.ParseComments)
+		if err != nil {
+			delete(p.packages, bp.ImportPath)
+			return nil, err
+		}
+		files = append(files, file)
+	}
+
+	var hardErr error
+	conf := types.Config{
+		Importer:         p,
+		IgnoreFuncBodies: true,
+		Sizes:            types.SizesFor(p.ctxt.Compiler, p.ctxt.GOARCH),
+		Error: func(err error) {
+			if typeErr, ok := err.(types.Error); hardErr == nil && (!ok || !typeErr.Soft) {
+				hardErr = err
+			}
+		},
+	}
+	pkg, _ := conf.Check(bp.ImportPath, p.fs, files, nil)
+	if hardErr != nil {
+		delete(p.packages, bp.ImportPath)
+		return nil, fmt.Errorf("type-checking package %q failed: %v", bp.ImportPath, hardErr)
+	}
+
+	p.packages[bp.ImportPath] = pkg
+	return pkg, nil
+}
+
+// packageImporter imports the package being checked from memory and the other packages from their sources.
+type packageImporter struct {
+	pkg      *types.Package
+	importer types.ImporterFrom
+}
+
+// Import imports a package.
+func (p *packageImporter) Import(path string) (*types.Package, error) {
+	return p.ImportFrom(path, "", 0)
+}
+
+// ImportFrom imports a package from a folder.
+func (p *packageImporter) ImportFrom(path, dir string, mode types.ImportMode) (*types.Package, error) {
+	if path == p.pkg.Path() {
+		return p.pkg, nil
+	}
+	return p.importer.ImportFrom(path, dir, mode)
+}
+
+// packageImportPath returns the import path of the package of a folder.
+func (j *job) packageImportPath(absDir string) string {
+	if packages, err := j.listPackages(); err == nil {
+		for _, pkg := range packages {
+			if pkg.Dir == absDir {
+				return pkg.ImportPath
+			}
+		}
+	}
+
+	rel, err := filepath.Rel(j.fileDir, absDir)
+	if err != nil || rel == "." {
+		return j.modulePath
+	}
+	return j.modulePath + "/" + filepath.ToSlash(rel)
+}
+
+// testStubs type-checks the package of a folder with its tests and returns the stubs of the
+// functions, methods and types used by the tests but not declared, with the inferred signatures.
+func (j *job) testStubs(absDir string) ([]stubFile, error) {
+	matches, err := filepath.Glob(filepath.Join(absDir, "*.go"))
+	if err != nil {
+		return nil, err
+	}
+
+	c := &stubCollector{
+		fs: token.NewFileSet(),
+		info: &types.Info{
+			Types: make(map[ast.Expr]types.TypeAndValue),
+			Defs:  make(map[*ast.Ident]types.Object),
+			Uses:  make(map[*ast.Ident]types.Object),
+		},
+		files:   make(map[string]*ast.File),
+		decls:   make(map[string]map[string]string),
+		imports: make(map[string]map[string]string),
+	}
+
+	var pkgFiles, xtestFiles []*ast.File
+	pkgName := ""
+	for _, match := range matches {
+		file, err := parser.ParseFile(c.fs, match, nil, parser.ParseComments)
+		if err != nil {
+			return nil, err
+		}
+		c.files[match] = file
+
+		if j.isTestFile(match) && strings.HasSuffix(file.Name.Name, "_test") {
+			xtestFiles = append(xtestFiles, file)
+			continue
+		}
+		pkgFiles = append(pkgFiles, file)
+		if !j.isTestFile(match) || pkgName == "" {
+			pkgName = file.Name.Name
+		}
+	}
+
+	var typeErrors []types.Error
+	conf := types.Config{
+		Importer: j.sourceImporter(c.fs),
+		Error: func(err error) {
+			if typeErr, ok := err.(types.Error); ok {
+				typeErrors = append(typeErrors, typeErr)
+			}
+		},
+	}
+
+	importPath := j.packageImportPath(absDir)
+	c.pkg, _ = conf.Check(importPath, c.fs, pkgFiles, c.info)
+	if c.pkg == nil {
+		c.pkg = types.NewPackage(importPath, pkgName)
+	}
+	if pkgName == "" && len(xtestFiles) > 0 {
+		pkgName = strings.TrimSuffix(xtestFiles[0].Name.Name, "_test")
+		c.pkg.SetName(pkgName)
+	}
+
+	if len(xtestFiles) > 0 {
+		conf.Importer = &packageImporter{pkg: c.pkg, importer: conf.Importer.(types.ImporterFrom)}
+		_, _ = conf.Check(importPath+"_test", c.fs, xtestFiles, c.info)
+	}
+
+	for _, typeErr := range typeErrors {
+		position := c.fs.Position(typeErr.Pos)
+		file, ok := c.files[position.Filename]
+		if !ok || !j.isTestFile(position.Filename) {
+			continue
+		}
+
+		rel, err := filepath.Rel(j.fileDir, position.Filename)
+		if err != nil {
+			return nil, err
+		}
+		c.current = j.getSourceFileName(rel)
+
+		c.addStub(typeErr.Msg, nodePath(file, typeErr.Pos))
+	}
+
+	var stubs []stubFile
+	for file, decls := range c.decls {
+		var code strings.Builder
+		fmt.Fprintf(&code, "package %s\n\n", pkgName)
+
+		if imports := c.imports[file]; len(imports) > 0 {
+			code.WriteString("import (\n")
+			for importPath := range imports {
+				fmt.Fprintf(&code, "\t%q\n", importPath)
+			}
+			code.WriteString(")\n\n")
+		}
+
+		var names []string
+		for name := range decls {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			code.WriteString(decls[name] + "\n\n")
+		}
+
+		formatted, err := format.Source([]byte(code.String()))
+		if err != nil {
+			return nil, fmt.Errorf(j.t("error while formatting file")+": %v", err)
+		}
+		stubs = append(stubs, stubFile{file: file, code: string(formatted)})
+	}
+
+	sort.Slice(stubs, func(a, b int) bool { return stubs[a].file < stubs[b].file })
+	return stubs, nil
+}
+
+// nodePath returns the nodes of the file containing the position, from the file to the innermost node.
+func nodePath(file *ast.File, pos token.Pos) []ast.Node {
+	var path []ast.Node
+	ast.Inspect(file, func(n ast.Node) bool {
+		if n == nil || pos < n.Pos() || pos >= n.End() {
+			return false
+		}
+		path = append(path, n)
+		return true
+	})
+	return path
+}
+
+// addStub adds the stub fixing a type error of a test, if it is an undefined function, method or type.
+func (c *stubCollector) addStub(msg string, path []ast.Node) {
+	if len(path) < 2 {
+		return
+	}
+
+	ident, ok := path[len(path)-1].(*ast.Ident)
+	if !ok {
+		return
+	}
+
+	var expr ast.Expr = ident
+	parents := path[:len(path)-1]
+	sel, isSel := parents[len(parents)-1].(*ast.SelectorExpr)
+	if isSel && sel.Sel == ident {
+		expr = sel
+		parents = parents[:len(parents)-1]
+	}
+	if len(parents) == 0 {
+		return
+	}
+
+	switch {
+	case strings.HasPrefix(msg, "undefined: "):
+		if isSel && !c.isPackageQualifier(sel) {
+			return
+		}
+		c.addUndefinedStub(ident.Name, expr, parents)
+
+	case strings.Contains(msg, "has no field or method") && isSel:
+		c.addMethodStub(sel, parents)
+	}
+}
+
+// isPackageQualifier reports whether the selector is qualified by the package under test.
+func (c *stubCollector) isPackageQualifier(sel *ast.SelectorExpr) bool {
+	x, ok := sel.X.(*ast.Ident)
+	if !ok {
+		return false
+	}
+	pkgName, ok := c.info.Uses[x].(*types.PkgName)
+	return ok && pkgName.Imported().Path() == c.pkg.Path()
+}
+
+// addDecl adds a stub declaration to the source file of the current test file.
+func (c *stubCollector) addDecl(key, code string) {
+	if c.decls[c.current] == nil {
+		c.decls[c.current] = make(map[string]string)
+	}
+	if _, ok := c.decls[c.current][key]; !ok {
+		c.decls[c.current][key] = code
+	}
+}
+
+// addUndefinedStub adds the stub of an undefined function, type or variable.
+func (c *stubCollector) addUndefinedStub(name string, expr ast.Expr, parents []ast.Node) {
+	if c.pkg.Scope().Lookup(name) != nil {
+		return
+	}
+
+	switch parent := parents[len(parents)-1].(type) {
+	case *ast.CallExpr:
+		if parent.Fun == expr {
+			params, results := c.signature(parent, parents)
+			c.addDecl(name, fmt.Sprintf("func %s(%s)%s {\n\tpanic(\"not implemented\")\n}", name, params, results))
+			return
+		}
+
+	case *ast.CompositeLit:
+		if parent.Type == expr {
+			c.addDecl(name, fmt.Sprintf("type %s struct {\n%s}", name, c.structFields(parent)))
+			return
+		}
+
+	case *ast.StarExpr, *ast.Field, *ast.ArrayType, *ast.MapType, *ast.ChanType, *ast.ValueSpec:
+		c.addDecl(name, fmt.Sprintf("type %s struct{}", name))
+		return
+	}
+
+	typ := c.expectedType(expr, parents)
+	if typ == "" {
+		typ = "any"
+		if strings.HasPrefix(name, "Err") {
+			typ = "error"
+		}
+	}
+	c.addDecl(name, fmt.Sprintf("var %s %s", name, typ))
+}
+
+// addMethodStub adds the stub of a method called on a type of the package.
+func (c *stubCollector) addMethodStub(sel *ast.SelectorExpr, parents []ast.Node) {
+	call, ok := parents[len(parents)-1].(*ast.CallExpr)
+	if !ok || call.Fun != sel {
+		return
+	}
+
+	recvType := c.info.TypeOf(sel.X)
+	if recvType == nil {
+		return
+	}
+	if ptr, ok := recvType.(*types.Pointer); ok {
+		recvType = ptr.Elem()
+	}
+
+	named, ok := recvType.(*types.Named)
+	if !ok || named.Obj().Pkg() == nil || named.Obj().Pkg().Path() != c.pkg.Path() {
+		return
+	}
+
+	_, isStruct := named.Underlying().(*types.Struct)
+	c.addMethod(named.Obj().Name(), isStruct, sel, call, parents)
+}
+
+// addMethod adds the stub of a method of the given type from one of its calls.
+func (c *stubCollector) addMethod(typeName string, pointer bool, sel *ast.SelectorExpr, call *ast.CallExpr, parents []ast.Node) {
+	recv := strings.ToLower(typeName[:1]) + " " + typeName
+	if pointer {
+		recv = strings.ToLower(typeName[:1]) + " *" + typeName
+	}
+
+	params, results := c.signature(call, parents)
+	c.addDecl(typeName+"."+sel.Sel.Name, fmt.Sprintf("func (%s) %s(%s)%s {\n\tpanic(\"not implemented\")\n}", recv, sel.Sel.Name, params, results))
+}
+
+// typeString returns the type as written in the package under test, recording the imports it needs.
+func (c *stubCollector) typeString(t types.Type) string {
+	if t == nil {
+		return "any"
+	}
+	if basic, ok := t.(*types.Basic); ok {
+		if basic.Kind() == types.Invalid || basic.Kind() == types.UntypedNil {
+			return "any"
+		}
+	}
+
+	return types.TypeString(types.Default(t), func(p *types.Package) string {
+		if p.Path() == c.pkg.Path() {
+			return ""
+		}
+		if c.imports[c.current] == nil {
+			c.imports[c.current] = make(map[string]string)
+		}
+		c.imports[c.current][p.Path()] = p.Name()
+		return p.Name()
+	})
+}
+
+// signature returns the parameters and the results of a function from one of its calls.
+func (c *stubCollector) signature(call *ast.CallExpr, parents []ast.Node) (string, string) {
+	var params []string
+	for i, arg := range call.Args {
+		params = append(params, fmt.Sprintf("%s %s", paramName(arg, i), c.typeString(c.info.TypeOf(arg))))
+	}
+
+	results := c.resultTypes(call, parents[:len(parents)-1])
+	switch len(results) {
+	case 0:
+		return strings.Join(params, ", "), ""
+	case 1:
+		return strings.Join(params, ", "), " " + results[0]
+	default:
+		return strings.Join(params, ", "), " (" + strings.Join(results, ", ") + ")"
+	}
+}
+
+// paramName returns a parameter name for an argument of a call.
+func paramName(arg ast.Expr, i int) string {
+	if ident, ok := arg.(*ast.Ident); ok && ident.Name != "nil" && ident.Name != "true" && ident.Name != "false" {
+		return ident.Name
+	}
+	return fmt.Sprintf("arg%d", i)
+}
+
+// structFields returns the fields of a struct from a keyed composite literal.
+func (c *stubCollector) structFields(lit *ast.CompositeLit) string {
+	var fields strings.Builder
+	for _, elt := range lit.Elts {
+		kv, ok := elt.(*ast.KeyValueExpr)
+		if !ok {
+			continue
+		}
+		if key, ok := kv.Key.(*ast.Ident); ok {
+			fmt.Fprintf(&fields, "\t%s %s\n", key.Name, c.typeString(c.info.TypeOf(kv.Value)))
+		}
+	}
+	return fields.String()
+}
+
+// resultTypes infers the results of a call from the way they are used.
+func (c *stubCollector) resultTypes(call *ast.CallExpr, parents []ast.Node) []string {
+	if len(parents) == 0 {
+		return nil
+	}
+
+	switch parent := parents[len(parents)-1].(type) {
+	case *ast.ExprStmt, *ast.GoStmt, *ast.DeferStmt:
+		return nil
+
+	case *ast.AssignStmt:
+		if len(parent.Rhs) != 1 {
+			break
+		}
+
+		var results []string
+		for _, lhs := range parent.Lhs {
+			results = append(results, c.assignedType(lhs, parent.Tok, call, parents))
+		}
+		return results
+
+	case *ast.ValueSpec:
+		if parent.Type != nil && len(parent.Values) == 1 {
+			return []string{c.typeString(c.info.TypeOf(parent.Type))}
+		}
+		var results []string
+		for _, name := range parent.Names {
+			results = append(results, c.assignedType(name, token.DEFINE, call, parents))
+		}
+		return results
+	}
+
+	if typ := c.expectedType(call, parents); typ != "" {
+		return []string{typ}
+	}
+	return []string{"any"}
+}
+
+// assignedType infers the type of a variable assigned by a call, from its later uses.
+// The variables assigned by a NewXxx constructor and used as receivers get a *Xxx type.
+func (c *stubCollector) assignedType(lhs ast.Expr, tok token.Token, call *ast.CallExpr, parents []ast.Node) string {
+	ident, ok := lhs.(*ast.Ident)
+	if tok != token.DEFINE || !ok {
+		return c.typeString(c.info.TypeOf(lhs))
+	}
+
+	uses := c.usePaths(c.info.Defs[ident], parents)
+	for _, usePath := range uses {
+		if typ := c.expectedType(usePath[len(usePath)-1].(ast.Expr), usePath[:len(usePath)-1]); typ != "" {
+			return typ
+		}
+	}
+
+	if typeName := strings.TrimPrefix(calleeName(call), "New"); typeName != calleeName(call) && typeName != "" {
+		if typ := c.constructedType(typeName, uses); typ != "" {
+			return typ
+		}
+	}
+
+	switch ident.Name {
+	case "err":
+		return "error"
+	case "ok":
+		return "bool"
+	}
+	return "any"
+}
+
+// usePaths returns the paths of the uses of an object in the outermost function of the given nodes.
+func (c *stubCollector) usePaths(obj types.Object, parents []ast.Node) [][]ast.Node {
+	if obj == nil || len(parents) == 0 {
+		return nil
+	}
+
+	file, ok := parents[0].(*ast.File)
+	if !ok {
+		return nil
+	}
+
+	var paths [][]ast.Node
+	for _, parent := range parents {
+		fn, ok := parent.(*ast.FuncDecl)
+		if !ok {
+			continue
+		}
+
+		ast.Inspect(fn, func(n ast.Node) bool {
+			if use, ok := n.(*ast.Ident); ok && c.info.Uses[use] == obj {
+				paths = append(paths, nodePath(file, use.Pos()))
+			}
+			return true
+		})
+		break
+	}
+	return paths
+}
+
+// constructedType adds the stubs of the type built by a constructor and of the methods called on
+// the variable holding it, and returns the pointer type, if the variable is used as a receiver.
+func (c *stubCollector) constructedType(typeName string, uses [][]ast.Node) string {
+	if c.pkg.Scope().Lookup(typeName) != nil {
+		return ""
+	}
+
+	found := false
+	for _, usePath := range uses {
+		if len(usePath) < 3 {
+			continue
+		}
+
+		sel, ok := usePath[len(usePath)-2].(*ast.SelectorExpr)
+		if !ok || sel.X != usePath[len(usePath)-1] {
+			continue
+		}
+		found = true
+
+		if call, ok := usePath[len(usePath)-3].(*ast.CallExpr); ok && call.Fun == sel {
+			c.addMethod(typeName, true, sel, call, usePath[:len(usePath)-2])
+		}
+	}
+
+	if !found {
+		return ""
+	}
+
+	c.addDecl(typeName, fmt.Sprintf("type %s struct{}", typeName))
+	return "*" + typeName
+}
+
+// calleeName returns the name of the function called.
+func calleeName(call *ast.CallExpr) string {
+	switch fun := call.Fun.(type) {
+	case *ast.Ident:
+		return fun.Name
+	case *ast.SelectorExpr:
+		return fun.Sel.Name
+	}
+	return ""
+}
+
+// expectedType infers the type expected for an expression from its parent.
+func (c *stubCollector) expectedType(expr ast.Expr, parents []ast.Node) string {
+	if len(parents) == 0 {
+		return ""
+	}
+
+	typeOf := func(e ast.Expr) string {
+		t := c.info.TypeOf(e)
+		if t == nil {
+			return ""
+		}
+		if basic, ok := t.(*types.Basic); ok && (basic.Kind() == types.Invalid || basic.Kind() == types.UntypedNil) {
+			return ""
+		}
+		if iface, ok := t.Underlying().(*types.Interface); ok && iface.Empty() {
+			return ""
+		}
+		return c.typeString(t)
+	}
+
+	switch parent := parents[len(parents)-1].(type) {
+	case *ast.ParenExpr:
+		return c.expectedType(parent, parents[:len(parents)-1])
+
+	case *ast.BinaryExpr:
+		other := parent.X
+		if other == expr {
+			other = parent.Y
+		}
+		switch parent.Op {
+		case token.LAND, token.LOR:
+			return "bool"
+		}
+		return typeOf(other)
+
+	case *ast.UnaryExpr:
+		if parent.Op == token.NOT {
+			return "bool"
+		}
+
+	case *ast.IfStmt, *ast.ForStmt:
+		return "bool"
+
+	case *ast.AssignStmt:
+		if parent.Tok == token.ASSIGN && len(parent.Lhs) == len(parent.Rhs) {
+			for i, rhs := range parent.Rhs {
+				if rhs == expr {
+					return typeOf(parent.Lhs[i])
+				}
+			}
+		}
+
+	case *ast.CallExpr:
+		sig, ok := c.info.TypeOf(parent.Fun).(*types.Signature)
+		if !ok {
+			return ""
+		}
+		for i, arg := range parent.Args {
+			if arg != expr {
+				continue
+			}
+			param := paramType(sig, i)
+			if iface, ok := param.Underlying().(*types.Interface); ok && iface.Empty() {
+				// Comparison helpers, such as reflect.DeepEqual, take values of the same type.
+				for k, other := range parent.Args {
+					if k != i && paramType(sig, k) == param {
+						if typ := typeOf(other); typ != "" {
+							return typ
+						}
+					}
+				}
+				return ""
+			}
+			return c.typeString(param)
+		}
+	}
+
+	return ""
+}
+
+// paramType returns the type of the i-th argument of a call to a function of the given signature.
+func paramType(sig *types.Signature, i int) types.Type {
+	params := sig.Params()
+	if params.Len() == 0 {
+		return types.Typ[types.Invalid]
+	}
+	if sig.Variadic() && i >= params.Len()-1 {
+		// The variadic parameter of append([]byte, string...) is a string.
+		slice, ok := params.At(params.Len() - 1).Type().(*types.Slice)
+		if !ok {
+			return types.Typ[types.Invalid]
+		}
+		return slice.Elem()
+	}
+	if i >= params.Len() {
+		return types.Typ[types.Invalid]
+	}
+	return params.At(i).Type()
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"go/build"
+	"go/token"
+	"go/types"
+	"testing"
+)
+
+func TestParamType(t *testing.T) {
+	byteSlice := types.NewSlice(types.Typ[types.Byte])
+	newParams := func(params ...types.Type) *types.Tuple {
+		var vars []*types.Var
+		for _, typ := range params {
+			vars = append(vars, types.NewParam(token.NoPos, nil, "", typ))
+		}
+		return types.NewTuple(vars...)
+	}
+
+	tests := []struct {
+		name string
+		sig  *types.Signature
+		i    int
+		want types.Type
+	}{
+		{"no parameter", types.NewSignatureType(nil, nil, nil, nil, nil, false), 0, types.Typ[types.Invalid]},
+		{"parameter", types.NewSignatureType(nil, nil, nil, newParams(types.Typ[types.Int]), nil, false), 0, types.Typ[types.Int]},
+		{"too many arguments", types.NewSignatureType(nil, nil, nil, newParams(types.Typ[types.Int]), nil, false), 1, types.Typ[types.Invalid]},
+		{"variadic", types.NewSignatureType(nil, nil, nil, newParams(types.Typ[types.Int], byteSlice), nil, true), 3, types.Typ[types.Byte]},
+		{"append of a string", types.NewSignatureType(nil, nil, nil, newParams(byteSlice, types.Typ[types.String]), nil, true), 1, types.Typ[types.Invalid]},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := paramType(tt.sig, tt.i); got != tt.want {
+				t.Errorf("paramType() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTestStubs(t *testing.T) {
+	dir := writeModule(t, map[string]string{
+		"go.mod": "module example.com/m\n\ngo 1.22\n",
+		"p/p.go": "package p\n",
+		"p/p_test.go": `package p
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	_ = append([]byte("a"), Suffix()...)
+	n, err := Parse([]byte("abc"), 3)
+	if err != nil || n != "abc" {
+		t.Fail()
+	}
+}
+`,
+	})
+
+	j := &job{fileDir: dir, modulePath: "example.com/m"}
+	stubs, err := j.testStubs(dir + "/p")
+	if err != nil {
+		t.Fatalf("testStubs() error = %v", err)
+	}
+
+	want := "package p\n\nfunc Parse(arg0 []byte, arg1 int) (string, error) {\n\tpanic(\"not implemented\")\n}\n\n" +
+		"func Suffix() any {\n\tpanic(\"not implemented\")\n}\n"
+	if len(stubs) != 1 || stubs[0].file != "p/p.go" || stubs[0].code != want {
+		t.Errorf("testStubs() = %+v, want %q", stubs, want)
+	}
+}
+
+func TestSourceImporter(t *testing.T) {
+	dir := writeModule(t, map[string]string{
+		"go.mod":   "module example.com/m\n\ngo 1.22\n",
+		"a/a.go":   "package a\n\nimport \"strings\"\n\nfunc Upper(s string) string { return strings.ToUpper(s) }\n",
+		"b/b.go":   "package b\n\nimport \"example.com/m/a\"\n\nvar B = a.Upper(\"b\")\n",
+		"bad/x.go": "package bad\n\nvar X int = \"x\"\n",
+	})
+
+	tests := []struct {
+		path     string
+		wantName string
+		wantErr  bool
+	}{
+		{path: "unsafe", wantName: "Pointer"},
+		{path: "strings", wantName: "ToUpper"},
+		{path: "net/http", wantName: "Get"},
+		{path: "example.com/m/a", wantName: "Upper"},
+		{path: "example.com/m/b", wantName: "B"},
+		{path: "example.com/m/missing", wantErr: true},
+		{path: "example.com/m/bad", wantErr: true},
+	}
+
+	defaultDir := build.Default.Dir
+	j := &job{fileDir: dir}
+	imp := j.sourceImporter(token.NewFileSet())
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			pkg, err := imp.ImportFrom(tt.path, dir, 0)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ImportFrom() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && pkg.Scope().Lookup(tt.wantName) == nil {
+				t.Errorf("ImportFrom() = %v, want it to declare %s", pkg, tt.wantName)
+			}
+		})
+	}
+
+	if build.Default.Dir != defaultDir {
+		t.Errorf("build.Default.Dir = %q, want it unchanged %q", build.Default.Dir, defaultDir)
+	}
+}
@@ -1,15 +1,14 @@
 package main
 
 import (
-	"bytes"
 	"flag"
 	"fmt"
 	"log"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"runtime"
 
+	"github.com/fatih/color"
 	logger "github.com/sirupsen/logrus"
 )
 
@@ -20,6 +19,9 @@ type appArgs struct {
 	listOnly bool
 	write    bool
 	diffOnly bool
+	review   bool
+
+	sideBySide bool
 }
 
 // init initializes the logger.
@@ -29,6 +31,13 @@ func init() {
 }
 
 func main() {
+	if os.Getenv(sandboxExecEnv) != "" {
+		if err := execSandboxed(os.Args[1:]); err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, sandboxSetupError+"%v\n", err)
+			os.Exit(sandboxSetupExitCode)
+		}
+	}
+
 	runtime.GOMAXPROCS(runtime.NumCPU())
 
 	if err := run(); err != nil {
@@ -37,12 +46,24 @@ func main() {
 	}
 }
 
+// commands are the modes run by `goia [flags] <command> [arguments]`.
+var commands = map[string]command{
+	"examples":  runExamplesCommand,
+	"fuzz":      runFuzzCommand,
+	"implement": runImplementCommand,
+	"repro":     runReproCommand,
+}
+
 // run executes the program.
 func run() error {
 	var args appArgs
 
 	flag.Usage = func() {
 		_, _ = fmt.Fprintln(flag.CommandLine.Output(), "Usage: goia [flags] [path ...]")
+		_, _ = fmt.Fprintln(flag.CommandLine.Output(), "       goia [flags] examples [path]")
+		_, _ = fmt.Fprintln(flag.CommandLine.Output(), "       goia [flags] fuzz [-fuzztime duration] <func> [path]")
+		_, _ = fmt.Fprintln(flag.CommandLine.Output(), "       goia [flags] implement --from-tests [path]")
+		_, _ = fmt.Fprintln(flag.CommandLine.Output(), "       goia [flags] repro [-trace file] [path]")
 		flag.PrintDefaults()
 		os.Exit(2)
 	}
@@ -53,9 +74,15 @@ func run() error {
 	flag.BoolVar(&args.listOnly, "l", false, "list files whose formatting differs from goia's")
 	flag.BoolVar(&args.write, "w", false, "write result to (source) file instead of stdout")
 	flag.BoolVar(&args.diffOnly, "d", false, "display diffs instead of rewriting files")
+	flag.BoolVar(&args.sideBySide, "y", false, "display the diffs side by side")
+	flag.BoolVar(&args.review, "r", false, "review the changes declaration by declaration before applying them")
 
 	flag.Parse()
 
+	if cmd, ok := commands[flag.Arg(0)]; ok {
+		return cmd(&args, flag.Args()[1:])
+	}
+
 	return process(&args, flag.Args()...)
 }
 
@@ -67,6 +94,7 @@ func process(args *appArgs, paths ...string) error {
 	if err != nil {
 		return err
 	}
+	defer j.closeSandbox()
 
 	if len(paths) == 0 {
 		j.fileName = ""
@@ -120,89 +148,26 @@ func (j *job) processFileFromFolder() error {
 	return nil
 }
 
-// diff returns the difference between two files.
-func diff(b1, b2 []byte, filename string) ([]byte, error) {
-	f1, err := writeTempFile("", "goia", b1)
-	if err != nil {
-		return nil, err
-	}
-
-	defer func() {
-		_ = os.Remove(f1)
-	}()
-
-	f2, err := writeTempFile("", "goia", b2)
-	if err != nil {
-		return nil, err
-	}
-
-	defer func() {
-		_ = os.Remove(f2)
-	}()
-
-	data, err := exec.Command("diff", "-u", f1, f2).CombinedOutput()
-	if err != nil {
-		return nil, err
-	}
-	if len(data) >= 0 {
-		data, err = replaceTempFilename(data, filename)
-	}
-	return data, err
-}
-
-// writeTempFile writes data to a temporary file.
-func writeTempFile(dir string, prefix string, data []byte) (string, error) {
-	f, err := os.CreateTemp(dir, prefix)
-	if err != nil {
-		return "", err
-	}
-
-	_, err = f.Write(data)
-	if err1 := f.Close(); err == nil {
-		err = err1
-	}
-
-	if err != nil {
-		_ = os.Remove(f.Name())
-		return "", err
-	}
-
-	return f.Name(), nil
-}
+// diff returns the unified difference between two files, with colors on a terminal, or side by side.
+func diff(b1, b2 []byte, filename string, sideBySide bool) []byte {
+	// Always print filepath with slash separator.
+	f := filepath.ToSlash(filename)
+	oldText, newText := string(b1), string(b2)
 
-// replaceTempFilename replaces temporary filenames in diff with actual one.
-//
-// --- /tmp/gofmt316145376	2017-02-03 19:13:00.280468375 -0500
-// +++ /tmp/gofmt617882815	2017-02-03 19:13:00.280468375 -0500
-// ...
-// ->
-// diff -u path/to/file.go.orig path/to/file.go
-// --- path/to/file.go.orig	2017-02-03 19:13:00.280468375 -0500
-// +++ path/to/file.go	2017-02-03 19:13:00.280468375 -0500
-// ...
-func replaceTempFilename(diff []byte, filename string) ([]byte, error) {
-	bs := bytes.SplitN(diff, []byte{'\n'}, 3)
-	if len(bs) < 3 {
-		return nil, fmt.Errorf("got unexpected diff for %s", filename)
+	if !sideBySide && color.NoColor {
+		data := unifiedDiff(f+".orig", f, oldText, newText)
+		if data == "" {
+			return nil
+		}
+		return []byte(fmt.Sprintf("diff -u %s %s\n", f+".orig", f) + data)
 	}
 
-	// Preserve timestamps.
-	var t0, t1 []byte
-	if i := bytes.LastIndexByte(bs[0], '\t'); i != -1 {
-		t0 = bs[0][i:]
+	data := colorDiff(oldText, newText)
+	if sideBySide {
+		data = sideBySideDiff(oldText, newText, terminalWidth())
 	}
-	if i := bytes.LastIndexByte(bs[1], '\t'); i != -1 {
-		t1 = bs[1][i:]
+	if data == "" {
+		return nil
 	}
-
-	// Always print filepath with slash separator.
-	f := filepath.ToSlash(filename)
-	bs[0] = []byte(fmt.Sprintf("--- %s%s", f+".orig", t0))
-	bs[1] = []byte(fmt.Sprintf("+++ %s%s", f, t1))
-
-	// Insert diff header.
-	header := fmt.Sprintf("diff -u %s %s", f+".orig", f)
-	bs = append([][]byte{[]byte(header)}, bs...)
-
-	return bytes.Join(bs, []byte{'\n'}), nil
+	return []byte(color.New(color.Bold).Sprintf("diff -u %s %s\n--- %s\n+++ %s", f+".orig", f, f+".orig", f) + "\n" + data)
 }
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/manifoldco/promptui"
+	log "github.com/sirupsen/logrus"
+)
+
+// declUnit is a top-level declaration of a file with its doc comment, keyed for the three-way merge.
+type declUnit struct {
+	key        string
+	start, end int
+	text       string
+}
+
+// mergeSide is a version of a file split into its header, its import declarations and its other declarations.
+type mergeSide struct {
+	header  string
+	imports string
+	units   map[string]declUnit
+	keys    []string
+
+	// importBlock spans the import declarations, it is empty when there are none.
+	importBlock declUnit
+}
+
+// unitKey returns the key of a declaration: the function or method key, or the keyword and the first name
+// of the declaration.
+func unitKey(decl ast.Decl) string {
+	switch d := decl.(type) {
+	case *ast.FuncDecl:
+		return "func " + funcKey(d)
+	case *ast.GenDecl:
+		for _, spec := range d.Specs {
+			switch s := spec.(type) {
+			case *ast.TypeSpec:
+				return d.Tok.String() + " " + s.Name.Name
+			case *ast.ValueSpec:
+				return d.Tok.String() + " " + s.Names[0].Name
+			}
+		}
+		return d.Tok.String()
+	}
+	return ""
+}
+
+// parseMergeSide parses a version of a file for the three-way merge.
+func parseMergeSide(fileName string, src []byte) (*mergeSide, error) {
+	fs := token.NewFileSet()
+	node, err := parser.ParseFile(fs, fileName, src, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+
+	side := &mergeSide{units: make(map[string]declUnit)}
+	headerEnd := len(src)
+
+	var imports []string
+	for _, decl := range node.Decls {
+		start := fs.Position(decl.Pos()).Offset
+		if genDecl, ok := decl.(*ast.GenDecl); ok && genDecl.Doc != nil {
+			start = fs.Position(genDecl.Doc.Pos()).Offset
+		} else if funcDecl, ok := decl.(*ast.FuncDecl); ok && funcDecl.Doc != nil {
+			start = fs.Position(funcDecl.Doc.Pos()).Offset
+		}
+		end := fs.Position(decl.End()).Offset
+		headerEnd = min(headerEnd, start)
+
+		if genDecl, ok := decl.(*ast.GenDecl); ok && genDecl.Tok == token.IMPORT {
+			imports = append(imports, string(src[start:end]))
+			if side.importBlock.key == "" {
+				side.importBlock = declUnit{key: "import", start: start}
+			}
+			side.importBlock.end = end
+			continue
+		}
+
+		// The declarations with the same key, like the init functions, are numbered.
+		key := unitKey(decl)
+		for n := 2; side.units[key].text != ""; n++ {
+			key = unitKey(decl) + "#" + strconv.Itoa(n)
+		}
+		side.units[key] = declUnit{key: key, start: start, end: end, text: string(src[start:end])}
+		side.keys = append(side.keys, key)
+	}
+
+	side.header = string(src[:headerEnd])
+	side.imports = strings.Join(imports, "\n\n")
+	if side.importBlock.key != "" {
+		side.importBlock.text = string(src[side.importBlock.start:side.importBlock.end])
+	}
+	return side, nil
+}
+
+// sameCode compares two pieces of code, ignoring the whitespace.
+func sameCode(a, b string) bool {
+	return strings.Join(strings.Fields(a), " ") == strings.Join(strings.Fields(b), " ")
+}
+
+// mergeConflict is a declaration changed differently on disk and by the model since the base.
+type mergeConflict struct {
+	key           string
+	ours, theirs  string
+	oursDeleted   bool
+	theirsDeleted bool
+}
+
+// merge3 merges at the declaration level the changes made on disk (theirs) and by the model (ours)
+// since the base. The declarations changed on both sides are given to resolve, which returns true to
+// keep the version on disk.
+func (j *job) merge3(fileName string, base, theirs, ours []byte, resolve func(mergeConflict) bool) ([]byte, error) {
+	baseSide, err := parseMergeSide(fileName, base)
+	if err != nil {
+		return nil, err
+	}
+	theirsSide, err := parseMergeSide(fileName, theirs)
+	if err != nil {
+		return nil, err
+	}
+	oursSide, err := parseMergeSide(fileName, ours)
+	if err != nil {
+		return nil, err
+	}
+
+	var edits []textEdit
+	var added []string
+
+	// takeTheirs replaces the declaration of the model by the one on disk.
+	takeTheirs := func(key string) {
+		o, inOurs := oursSide.units[key]
+		t, inTheirs := theirsSide.units[key]
+		switch {
+		case inOurs && inTheirs:
+			edits = append(edits, textEdit{start: o.start, end: o.end, text: t.text})
+		case inOurs:
+			edits = append(edits, textEdit{start: o.start, end: o.end})
+		case inTheirs:
+			added = append(added, t.text)
+		}
+	}
+
+	keys := append([]string{}, oursSide.keys...)
+	for _, key := range theirsSide.keys {
+		if _, ok := oursSide.units[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+
+	same := func(a, b *mergeSide, key string) bool {
+		x, inA := a.units[key]
+		y, inB := b.units[key]
+		return inA == inB && (!inA || sameCode(x.text, y.text))
+	}
+
+	for _, key := range keys {
+		switch {
+		case same(theirsSide, baseSide, key), same(theirsSide, oursSide, key):
+		case same(oursSide, baseSide, key):
+			takeTheirs(key)
+		default:
+			_, inOurs := oursSide.units[key]
+			_, inTheirs := theirsSide.units[key]
+			conflict := mergeConflict{
+				key:  key,
+				ours: oursSide.units[key].text, theirs: theirsSide.units[key].text,
+				oursDeleted: !inOurs, theirsDeleted: !inTheirs,
+			}
+			if resolve(conflict) {
+				takeTheirs(key)
+			}
+		}
+	}
+
+	if sameCode(oursSide.header, baseSide.header) && !sameCode(theirsSide.header, baseSide.header) {
+		edits = append(edits, textEdit{start: 0, end: len(oursSide.header), text: theirsSide.header})
+	}
+
+	merged := applyTextEdits(ours, edits)
+	for _, text := range added {
+		merged = append(merged, []byte("\n\n"+text+"\n")...)
+	}
+
+	// The imports added on disk are kept, the unused ones are removed by the next build.
+	if theirsSide.imports != "" && !sameCode(theirsSide.imports, baseSide.imports) {
+		m, err := j.newDeclMerger(fileName, merged, "package p\n\n"+theirsSide.imports)
+		if err != nil {
+			return nil, err
+		}
+		m.mergeImports()
+		return m.bytes()
+	}
+
+	return format.Source(merged)
+}
+
+// recordBase records the content of a file as read or written by goia, the base of the three-way merge.
+func (j *job) recordBase(fileName string, data []byte) {
+	if fileName == "" || j.source == fileSourceStdin {
+		return
+	}
+	if j.baseContents == nil {
+		j.baseContents = make(map[string][]byte)
+	}
+	j.baseContents[filepath.Clean(fileName)] = data
+}
+
+// reloadFile reads back a file rewritten on disk by goia itself, e.g. by goimports, into the current
+// content and records it as the base, so that the rewrite is not taken for an edit made on disk.
+func (j *job) reloadFile(fileName string) error {
+	if fileName == "" || j.source == fileSourceStdin {
+		return nil
+	}
+
+	data, err := os.ReadFile(filepath.Join(j.fileDir, fileName))
+	if err != nil {
+		return err
+	}
+
+	switch fileName {
+	case j.currentSourceFileName:
+		j.currentSrcSource = data
+	case j.currentTestFileName:
+		j.currentSrcTest = data
+	}
+	j.recordBase(fileName, data)
+	return nil
+}
+
+// mergeDiskChanges merges the changes made on disk since the base into the content written by goia,
+// so that the edits made in an editor during the session are not lost.
+func (j *job) mergeDiskChanges(fileName string, res []byte) ([]byte, error) {
+	base, ok := j.baseContents[filepath.Clean(fileName)]
+	if !ok {
+		return res, nil
+	}
+
+	theirs, err := os.ReadFile(filepath.Join(j.fileDir, fileName))
+	if err != nil || bytes.Equal(theirs, base) || bytes.Equal(theirs, res) {
+		return res, nil
+	}
+
+	log.Warnf(j.t("%s was modified on disk during the session, the changes are merged"), fileName)
+
+	merged, err := j.merge3(fileName, base, theirs, res, func(conflict mergeConflict) bool {
+		return j.resolveConflict(fileName, conflict)
+	})
+	if err != nil {
+		// A version that does not parse is not merged by declaration.
+		log.WithError(err).Warn(j.t("The versions cannot be merged by declaration"))
+		return j.resolveFileConflict(fileName, theirs, res), nil
+	}
+	return merged, nil
+}
+
+// resolveConflict asks which version of a declaration changed both on disk and by the model to keep.
+// The version on disk is kept when the question cannot be asked.
+func (j *job) resolveConflict(fileName string, conflict mergeConflict) bool {
+	version := func(text string, deleted bool) string {
+		if deleted {
+			return j.t("(deleted)")
+		}
+		return text
+	}
+
+	fmt.Printf("\n%s\n\n%s:\n%s\n\n%s:\n%s\n\n",
+		red(fmt.Sprintf(j.t("Conflict in %s on %s"), fileName, conflict.key)),
+		j.t("Your version"), green(version(conflict.theirs, conflict.theirsDeleted)),
+		j.t("Version of the model"), blue(version(conflict.ours, conflict.oursDeleted)))
+
+	return j.chooseTheirs()
+}
+
+// resolveFileConflict asks which version of a whole file to keep.
+func (j *job) resolveFileConflict(fileName string, theirs, ours []byte) []byte {
+	fmt.Printf("\n%s\n\n", red(fmt.Sprintf(j.t("Conflict in %s"), fileName)))
+	if j.chooseTheirs() {
+		return theirs
+	}
+	return ours
+}
+
+// chooseTheirs asks whether to keep the version on disk or the version of the model.
+func (j *job) chooseTheirs() bool {
+	prompt := promptui.Select{
+		Label: j.t("Which version do you keep ?"),
+		Items: []string{j.t("Your version"), j.t("Version of the model")},
+	}
+
+	index, _, err := prompt.Run()
+	if err != nil {
+		log.WithError(err).Warn(j.t("Your version is kept"))
+		return true
+	}
+	return index == 0
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMerge3(t *testing.T) {
+	const base = "package m\n\nfunc A() int { return 1 }\n\nfunc B() int { return 2 }\n"
+
+	tests := []struct {
+		name          string
+		theirs        string
+		ours          string
+		keepTheirs    bool
+		want          string
+		wantConflicts []string
+	}{
+		{
+			name:   "unchanged on disk",
+			theirs: base,
+			ours:   "package m\n\nfunc A() int { return 10 }\n\nfunc B() int { return 2 }\n",
+			want:   "package m\n\nfunc A() int { return 10 }\n\nfunc B() int { return 2 }\n",
+		},
+		{
+			name:   "different declarations changed",
+			theirs: "package m\n\nfunc A() int { return 1 }\n\nfunc B() int { return 20 }\n",
+			ours:   "package m\n\nfunc A() int { return 10 }\n\nfunc B() int { return 2 }\n",
+			want:   "package m\n\nfunc A() int { return 10 }\n\nfunc B() int { return 20 }\n",
+		},
+		{
+			name:   "declaration added on disk",
+			theirs: base + "\nfunc C() int { return 3 }\n",
+			ours:   "package m\n\nfunc A() int { return 10 }\n\nfunc B() int { return 2 }\n",
+			want:   "package m\n\nfunc A() int { return 10 }\n\nfunc B() int { return 2 }\n\nfunc C() int { return 3 }\n",
+		},
+		{
+			name:   "declaration deleted on disk",
+			theirs: "package m\n\nfunc A() int { return 1 }\n",
+			ours:   "package m\n\nfunc A() int { return 10 }\n\nfunc B() int { return 2 }\n",
+			want:   "package m\n\nfunc A() int { return 10 }\n",
+		},
+		{
+			name:          "conflict resolved for the model",
+			theirs:        "package m\n\nfunc A() int { return 100 }\n\nfunc B() int { return 2 }\n",
+			ours:          "package m\n\nfunc A() int { return 10 }\n\nfunc B() int { return 2 }\n",
+			want:          "package m\n\nfunc A() int { return 10 }\n\nfunc B() int { return 2 }\n",
+			wantConflicts: []string{"func A"},
+		},
+		{
+			name:          "conflict resolved for the disk",
+			theirs:        "package m\n\nfunc A() int { return 100 }\n\nfunc B() int { return 2 }\n",
+			ours:          "package m\n\nfunc A() int { return 10 }\n\nfunc B() int { return 2 }\n",
+			keepTheirs:    true,
+			want:          "package m\n\nfunc A() int { return 100 }\n\nfunc B() int { return 2 }\n",
+			wantConflicts: []string{"func A"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var conflicts []string
+			j := &job{}
+			got, err := j.merge3("m.go", []byte(base), []byte(tt.theirs), []byte(tt.ours), func(conflict mergeConflict) bool {
+				conflicts = append(conflicts, conflict.key)
+				return tt.keepTheirs
+			})
+			if err != nil {
+				t.Fatalf("merge3() error = %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("merge3() = %q, want %q", got, tt.want)
+			}
+			if len(conflicts) != len(tt.wantConflicts) || (len(conflicts) > 0 && conflicts[0] != tt.wantConflicts[0]) {
+				t.Errorf("merge3() conflicts = %v, want %v", conflicts, tt.wantConflicts)
+			}
+		})
+	}
+}
+
+func TestMergeDiskChanges(t *testing.T) {
+	const base = "package m\n\nfunc A() int { return 1 }\n\nfunc B() int { return 2 }\n"
+	const ours = "package m\n\nfunc A() int { return 10 }\n\nfunc B() int { return 2 }\n"
+
+	tests := []struct {
+		name    string
+		disk    string
+		noBase  bool
+		reload  bool
+		want    string
+		wantSrc string
+	}{
+		{
+			name: "unchanged on disk",
+			disk: base,
+			want: ours,
+		},
+		{
+			name: "changed on disk",
+			disk: "package m\n\nfunc A() int { return 1 }\n\nfunc B() int { return 20 }\n",
+			want: "package m\n\nfunc A() int { return 10 }\n\nfunc B() int { return 20 }\n",
+		},
+		{
+			name:   "file never read",
+			disk:   "package m\n\nfunc B() int { return 20 }\n",
+			noBase: true,
+			want:   ours,
+		},
+		{
+			name:    "rewritten by goia",
+			disk:    "package m\n\n// A returns 1.\nfunc A() int { return 1 }\n\nfunc B() int { return 2 }\n",
+			reload:  true,
+			want:    ours,
+			wantSrc: "package m\n\n// A returns 1.\nfunc A() int { return 1 }\n\nfunc B() int { return 2 }\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := writeModule(t, map[string]string{"m.go": base})
+			j := &job{fileDir: dir, source: fileSourceFilePath, currentSourceFileName: "m.go"}
+			if !tt.noBase {
+				j.recordBase("m.go", []byte(base))
+			}
+
+			if err := os.WriteFile(filepath.Join(dir, "m.go"), []byte(tt.disk), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if tt.reload {
+				if err := j.reloadFile("m.go"); err != nil {
+					t.Fatalf("reloadFile() error = %v", err)
+				}
+				if string(j.currentSrcSource) != tt.wantSrc {
+					t.Errorf("reloadFile() source = %q, want %q", j.currentSrcSource, tt.wantSrc)
+				}
+			}
+
+			got, err := j.mergeDiskChanges("m.go", []byte(ours))
+			if err != nil {
+				t.Fatalf("mergeDiskChanges() error = %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("mergeDiskChanges() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecordBase(t *testing.T) {
+	tests := []struct {
+		name     string
+		source   fileSource
+		fileName string
+		want     bool
+	}{
+		{"file", fileSourceFilePath, "a/m.go", true},
+		{"stdin", fileSourceStdin, "a/m.go", false},
+		{"no name", fileSourceFilePath, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			j := &job{source: tt.source}
+			j.recordBase(tt.fileName, []byte("package m\n"))
+			if _, got := j.baseContents[filepath.Clean(tt.fileName)]; got != tt.want {
+				t.Errorf("recordBase() recorded = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,515 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// defaultMaxMutants is the maximum number of mutants tested when none is configured.
+	defaultMaxMutants = 50
+	// defaultMutantTimeout is the timeout of the tests of each mutant when none is configured.
+	defaultMutantTimeout = time.Minute
+	// defaultMinMutationScore is the mutation score below which the surviving mutants are sent to the model.
+	// It is below 100 as some mutants cannot be killed, e.g. a flipped `i < n` equivalent to `i != n`.
+	defaultMinMutationScore = 80
+	// maxSurvivorsInPrompt is the maximum number of surviving mutants sent to the model.
+	maxSurvivorsInPrompt = 10
+)
+
+// MutationConfig configures the mutation testing of the source file once its tests pass.
+type MutationConfig struct {
+	// Enabled runs the mutation testing after the tests pass.
+	Enabled bool `yaml:"enabled"`
+	// MaxMutants is the maximum number of mutants tested.
+	MaxMutants int `yaml:"max_mutants"`
+	// MinScore is the percentage of killed mutants below which the tests are strengthened.
+	MinScore float64 `yaml:"min_score"`
+	// Timeout is the timeout of the tests of each mutant.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// Merge merges the given MutationConfig with this one.
+func (cfg *MutationConfig) Merge(newCfg MutationConfig) {
+	if newCfg.Enabled {
+		cfg.Enabled = newCfg.Enabled
+	}
+	if newCfg.MaxMutants != 0 {
+		cfg.MaxMutants = newCfg.MaxMutants
+	}
+	if newCfg.MinScore != 0 {
+		cfg.MinScore = newCfg.MinScore
+	}
+	if newCfg.Timeout != 0 {
+		cfg.Timeout = newCfg.Timeout
+	}
+}
+
+// mutationKind is the kind of change made by a mutant.
+type mutationKind string
+
+const (
+	mutationFlipComparison mutationKind = "flip comparison"
+	mutationFlipLogical    mutationKind = "flip logical operator"
+	mutationNegateCond     mutationKind = "negate condition"
+	mutationChangeConst    mutationKind = "change constant"
+	mutationDropReturn     mutationKind = "drop return"
+	mutationNilError       mutationKind = "return nil error"
+)
+
+// mutantStatus is the result of the tests on a mutant.
+type mutantStatus string
+
+const (
+	mutantKilled   mutantStatus = "killed"
+	mutantSurvived mutantStatus = "survived"
+	// mutantInvalid is a mutant that does not compile, it is not counted in the score.
+	mutantInvalid mutantStatus = "invalid"
+)
+
+// mutant is a small change of the source file that the tests should detect.
+type mutant struct {
+	Kind     mutationKind
+	Line     int
+	Original string
+	Mutated  string
+	Status   mutantStatus
+
+	// apply changes the AST and returns the function restoring it.
+	apply func() (undo func())
+}
+
+// mutationReport is the result of the mutation testing of a file.
+type mutationReport struct {
+	File    string
+	Mutants []*mutant
+}
+
+// comparisonFlips are the operators replacing the comparison operators.
+var comparisonFlips = map[token.Token]token.Token{
+	token.EQL: token.NEQ,
+	token.NEQ: token.EQL,
+	token.LSS: token.GEQ,
+	token.GEQ: token.LSS,
+	token.GTR: token.LEQ,
+	token.LEQ: token.GTR,
+}
+
+// logicalFlips are the operators replacing the logical operators.
+var logicalFlips = map[token.Token]token.Token{
+	token.LAND: token.LOR,
+	token.LOR:  token.LAND,
+}
+
+// mutationCollector lists the mutants of a file.
+type mutationCollector struct {
+	fs      *token.FileSet
+	mutants []*mutant
+}
+
+// nodeString prints a node on one line.
+func (c *mutationCollector) nodeString(node ast.Node) string {
+	var buf bytes.Buffer
+	if err := printer.Fprint(&buf, c.fs, node); err != nil {
+		return ""
+	}
+	return strings.Join(strings.Fields(buf.String()), " ")
+}
+
+// add adds a mutant changing the node returned by current.
+func (c *mutationCollector) add(kind mutationKind, current func() ast.Node, apply func() (undo func())) {
+	m := &mutant{
+		Kind:     kind,
+		Line:     c.fs.Position(current().Pos()).Line,
+		Original: c.nodeString(current()),
+		apply:    apply,
+	}
+
+	undo := apply()
+	m.Mutated = c.nodeString(current())
+	undo()
+
+	c.mutants = append(c.mutants, m)
+}
+
+// collectMutants lists the mutants of the function bodies of a file.
+func collectMutants(fs *token.FileSet, file *ast.File) []*mutant {
+	c := &mutationCollector{fs: fs}
+	for _, decl := range file.Decls {
+		if funcDecl, ok := decl.(*ast.FuncDecl); ok && funcDecl.Body != nil {
+			c.visitFunc(funcDecl.Type, funcDecl.Body)
+		}
+	}
+	return c.mutants
+}
+
+// visitFunc lists the mutants of a function body, the function literals being visited with their own type.
+func (c *mutationCollector) visitFunc(funcType *ast.FuncType, body *ast.BlockStmt) {
+	ast.Inspect(body, func(n ast.Node) bool {
+		switch node := n.(type) {
+		case *ast.FuncLit:
+			c.visitFunc(node.Type, node.Body)
+			return false
+
+		case *ast.BinaryExpr:
+			c.binaryMutant(node)
+
+		case *ast.IfStmt:
+			cond := node.Cond
+			c.add(mutationNegateCond, func() ast.Node { return node.Cond }, func() func() {
+				node.Cond = &ast.UnaryExpr{Op: token.NOT, X: &ast.ParenExpr{X: cond}}
+				return func() { node.Cond = cond }
+			})
+
+		case *ast.BasicLit:
+			c.literalMutant(node)
+
+		case *ast.Ident:
+			if node.Name == "true" || node.Name == "false" {
+				name := node.Name
+				c.add(mutationChangeConst, func() ast.Node { return node }, func() func() {
+					node.Name = map[string]string{"true": "false", "false": "true"}[name]
+					return func() { node.Name = name }
+				})
+			}
+
+		case *ast.BlockStmt:
+			if node != body {
+				c.dropReturnMutants(funcType, &node.List)
+			}
+		case *ast.CaseClause:
+			c.dropReturnMutants(funcType, &node.Body)
+		case *ast.CommClause:
+			c.dropReturnMutants(funcType, &node.Body)
+
+		case *ast.ReturnStmt:
+			c.nilErrorMutant(funcType, node)
+		}
+		return true
+	})
+}
+
+// binaryMutant adds a mutant flipping a comparison or a logical operator.
+func (c *mutationCollector) binaryMutant(node *ast.BinaryExpr) {
+	op := node.Op
+	kind := mutationFlipComparison
+	flipped, ok := comparisonFlips[op]
+	if !ok {
+		kind = mutationFlipLogical
+		if flipped, ok = logicalFlips[op]; !ok {
+			return
+		}
+	}
+
+	c.add(kind, func() ast.Node { return node }, func() func() {
+		node.Op = flipped
+		return func() { node.Op = op }
+	})
+}
+
+// literalMutant adds a mutant changing an integer or a string constant.
+func (c *mutationCollector) literalMutant(node *ast.BasicLit) {
+	value := node.Value
+	var mutated string
+
+	switch node.Kind {
+	case token.INT:
+		n, err := strconv.ParseInt(strings.ReplaceAll(value, "_", ""), 0, 64)
+		if err != nil {
+			return
+		}
+		mutated = strconv.FormatInt(n+1, 10)
+	case token.STRING:
+		if s, err := strconv.Unquote(value); err == nil && s == "" {
+			mutated = strconv.Quote("mutant")
+		} else {
+			mutated = strconv.Quote("")
+		}
+	default:
+		return
+	}
+
+	c.add(mutationChangeConst, func() ast.Node { return node }, func() func() {
+		node.Value = mutated
+		return func() { node.Value = value }
+	})
+}
+
+// dropReturnMutants adds the mutants removing the nested returns of the functions without results.
+func (c *mutationCollector) dropReturnMutants(funcType *ast.FuncType, list *[]ast.Stmt) {
+	if funcType.Results != nil && len(funcType.Results.List) > 0 {
+		return
+	}
+
+	for i, stmt := range *list {
+		if _, ok := stmt.(*ast.ReturnStmt); !ok {
+			continue
+		}
+
+		c.add(mutationDropReturn, func() ast.Node { return (*list)[i] }, func() func() {
+			(*list)[i] = &ast.EmptyStmt{Semicolon: stmt.Pos(), Implicit: true}
+			return func() { (*list)[i] = stmt }
+		})
+	}
+}
+
+// nilErrorMutant adds a mutant returning a nil error instead of the returned error.
+func (c *mutationCollector) nilErrorMutant(funcType *ast.FuncType, node *ast.ReturnStmt) {
+	if funcType.Results == nil || len(node.Results) == 0 {
+		return
+	}
+
+	results := funcType.Results.List
+	if ident, ok := results[len(results)-1].Type.(*ast.Ident); !ok || ident.Name != "error" {
+		return
+	}
+
+	last := len(node.Results) - 1
+	expr := node.Results[last]
+	if ident, ok := expr.(*ast.Ident); ok && ident.Name == "nil" {
+		return
+	}
+	if _, ok := expr.(*ast.CallExpr); ok && len(node.Results) == 1 && funcType.Results.NumFields() > 1 {
+		// return f() with several results.
+		return
+	}
+
+	c.add(mutationNilError, func() ast.Node { return node }, func() func() {
+		node.Results[last] = ast.NewIdent("nil")
+		return func() { node.Results[last] = expr }
+	})
+}
+
+// referencedPackages returns the names used as qualifiers in the file.
+func referencedPackages(file *ast.File) map[string]bool {
+	names := make(map[string]bool)
+	ast.Inspect(file, func(n ast.Node) bool {
+		if sel, ok := n.(*ast.SelectorExpr); ok {
+			if ident, ok := sel.X.(*ast.Ident); ok {
+				names[ident.Name] = true
+			}
+		}
+		return true
+	})
+	return names
+}
+
+// dropLostImports removes the imports whose only uses were removed by a mutant,
+// so that the mutant still compiles. It returns the function restoring them.
+func dropLostImports(file *ast.File, referenced map[string]bool) (restore func()) {
+	current := referencedPackages(file)
+
+	var restores []func()
+	for _, decl := range file.Decls {
+		genDecl, ok := decl.(*ast.GenDecl)
+		if !ok || genDecl.Tok != token.IMPORT {
+			continue
+		}
+
+		specs := genDecl.Specs
+		var kept []ast.Spec
+		for _, spec := range specs {
+			importSpec := spec.(*ast.ImportSpec)
+			name := path.Base(strings.Trim(importSpec.Path.Value, "\"`"))
+			if importSpec.Name != nil {
+				name = importSpec.Name.Name
+			}
+
+			if referenced[name] && !current[name] {
+				continue
+			}
+			kept = append(kept, spec)
+		}
+
+		if len(kept) != len(specs) {
+			genDecl.Specs = kept
+			restores = append(restores, func() { genDecl.Specs = specs })
+		}
+	}
+
+	return func() {
+		for _, restore := range restores {
+			restore()
+		}
+	}
+}
+
+// mutationTimeout returns the timeout of the tests of each mutant.
+func (j *job) mutationTimeout() time.Duration {
+	if j.mutationConfig.Timeout > 0 {
+		return j.mutationConfig.Timeout
+	}
+	return defaultMutantTimeout
+}
+
+// testMutant runs the tests of the package of the file with the mutated content.
+// The file is replaced with an overlay, so the source file is never changed on disk.
+func (j *job) testMutant(fileName string, content []byte) (mutantStatus, error) {
+	tmpDir, err := os.MkdirTemp("", "goia-mutant-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	mutantFile := filepath.Join(tmpDir, filepath.Base(fileName))
+	if err := os.WriteFile(mutantFile, content, 0o644); err != nil {
+		return "", err
+	}
+
+	// The relative path is resolved from the module folder, also inside the sandbox.
+	overlay, err := json.Marshal(map[string]map[string]string{
+		"Replace": {filepath.ToSlash(filepath.Clean(fileName)): mutantFile},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	overlayFile := filepath.Join(tmpDir, "overlay.json")
+	if err := os.WriteFile(overlayFile, overlay, 0o644); err != nil {
+		return "", err
+	}
+
+	result, err := j.runCommand(nil, nil, "go", "test", "-count=1", "-failfast",
+		"-overlay", overlayFile, "-timeout", j.mutationTimeout().String(),
+		"./"+filepath.ToSlash(filepath.Dir(fileName)))
+	if err == nil {
+		return mutantSurvived, nil
+	}
+	if result == nil {
+		return "", err
+	}
+
+	if strings.Contains(result.Output(), "[build failed]") || strings.Contains(result.Output(), "[setup failed]") {
+		return mutantInvalid, nil
+	}
+	return mutantKilled, nil
+}
+
+// runMutationTesting mutates the source file and runs the tests of its package for each mutant.
+func (j *job) runMutationTesting(fileName string) (*mutationReport, error) {
+	fs := token.NewFileSet()
+	file, err := parser.ParseFile(fs, filepath.Join(j.fileDir, fileName), nil, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+
+	mutants := collectMutants(fs, file)
+
+	maxMutants := j.mutationConfig.MaxMutants
+	if maxMutants <= 0 {
+		maxMutants = defaultMaxMutants
+	}
+	if len(mutants) > maxMutants {
+		log.Warnf(j.t("Only %d of the %d mutants are tested"), maxMutants, len(mutants))
+		mutants = mutants[:maxMutants]
+	}
+
+	referenced := referencedPackages(file)
+
+	for i, m := range mutants {
+		undo := m.apply()
+		restoreImports := dropLostImports(file, referenced)
+		var buf bytes.Buffer
+		err := format.Node(&buf, fs, file)
+		restoreImports()
+		undo()
+		if err != nil {
+			m.Status = mutantInvalid
+			continue
+		}
+
+		if m.Status, err = j.testMutant(fileName, buf.Bytes()); err != nil {
+			return nil, err
+		}
+		log.Infof("mutant %d/%d (%s, line %d): %s", i+1, len(mutants), m.Kind, m.Line, m.Status)
+	}
+
+	return &mutationReport{File: fileName, Mutants: mutants}, nil
+}
+
+// score returns the number of killed mutants, the number of valid mutants and the percentage of killed mutants.
+func (r *mutationReport) score() (killed, total int, percent float64) {
+	for _, m := range r.Mutants {
+		switch m.Status {
+		case mutantKilled:
+			killed++
+			total++
+		case mutantSurvived:
+			total++
+		}
+	}
+
+	if total == 0 {
+		return 0, 0, 100
+	}
+	return killed, total, float64(killed) * 100 / float64(total)
+}
+
+// survivors returns the mutants not detected by the tests.
+func (r *mutationReport) survivors() []*mutant {
+	var survivors []*mutant
+	for _, m := range r.Mutants {
+		if m.Status == mutantSurvived {
+			survivors = append(survivors, m)
+		}
+	}
+	return survivors
+}
+
+// mutationPrompt returns a prompt asking to strengthen the tests so that they kill the surviving mutants.
+func (j *job) mutationPrompt(report *mutationReport) string {
+	survivors := report.survivors()
+	if len(survivors) > maxSurvivorsInPrompt {
+		survivors = survivors[:maxSurvivorsInPrompt]
+	}
+
+	var list strings.Builder
+	for _, m := range survivors {
+		fmt.Fprintf(&list, "- %s:%d (%s): `%s` -> `%s`\n", report.File, m.Line, m.Kind, m.Original, m.Mutated)
+	}
+
+	return j.t("The tests pass, but they still pass after the following changes of the source code, so their assertions are too weak") + ":\n\n" +
+		list.String() + "\n" +
+		j.t("Strengthen the assertions of the tests so that each of these changes makes a test fail, without changing the source file") + ".\n\n" +
+		j.t("Here is the Golang code") + " :\n\n" + string(j.currentSrcTest) + "\n\n" +
+		j.t("Generates a concise response that specifies the file to modify in the form") +
+		": \"MODIFY: <function or section name> (test file)\"." +
+		j.t("Then provide the corrected code in the form") + ": \"CODE: <corrected code>\".\n\n" +
+		j.t("responds without adding comments or explanations")
+}
+
+// mutationGate runs the mutation testing of the source file and returns a prompt
+// strengthening the tests if the mutation score is too low.
+func (j *job) mutationGate() (string, error) {
+	report, err := j.runMutationTesting(j.currentSourceFileName)
+	if err != nil {
+		return "", err
+	}
+
+	killed, total, percent := report.score()
+	log.Infof(j.t("Mutation score of %s")+": %d/%d (%.1f%%)", report.File, killed, total, percent)
+
+	minScore := j.mutationConfig.MinScore
+	if minScore <= 0 {
+		minScore = defaultMinMutationScore
+	}
+	if percent >= minScore {
+		return "", nil
+	}
+
+	return j.mutationPrompt(report), nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"go/parser"
+	"go/token"
+	"reflect"
+	"testing"
+)
+
+func TestCollectMutants(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want []string
+	}{
+		{
+			name: "comparison and logical operators",
+			src:  "func f(a, b int) bool { return a < b && a != 0 }",
+			want: []string{
+				"flip logical operator: a < b && a != 0 -> a < b || a != 0",
+				"flip comparison: a < b -> a >= b",
+				"flip comparison: a != 0 -> a == 0",
+				"change constant: 0 -> 1",
+			},
+		},
+		{
+			name: "condition and constants",
+			src:  "func f(s string) bool { if s == \"\" { return true }; return false }",
+			want: []string{
+				"negate condition: s == \"\" -> !(s == \"\")",
+				"flip comparison: s == \"\" -> s != \"\"",
+				"change constant: \"\" -> \"mutant\"",
+				"change constant: true -> false",
+				"change constant: false -> true",
+			},
+		},
+		{
+			name: "nested return without results",
+			src:  "func f(n int) { if n > 1 { return }; println(\"x\") }",
+			want: []string{
+				"negate condition: n > 1 -> !(n > 1)",
+				"flip comparison: n > 1 -> n <= 1",
+				"change constant: 1 -> 2",
+				"drop return: return -> ",
+				"change constant: \"x\" -> \"\"",
+			},
+		},
+		{
+			name: "returned error",
+			src:  "func f() (int, error) { if err := g(); err != nil { return 0, err }; return g2() }",
+			want: []string{
+				"negate condition: err != nil -> !(err != nil)",
+				"flip comparison: err != nil -> err == nil",
+				"return nil error: return 0, err -> return 0, nil",
+				"change constant: 0 -> 1",
+			},
+		},
+		{
+			name: "function literal with its own results",
+			src:  "func f() { g := func() error { return e }; _ = g }",
+			want: []string{
+				"return nil error: return e -> return nil",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := token.NewFileSet()
+			file, err := parser.ParseFile(fs, "f.go", "package p\n\n"+tt.src+"\n", 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for _, m := range collectMutants(fs, file) {
+				got = append(got, string(m.Kind)+": "+m.Original+" -> "+m.Mutated)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("collectMutants() =\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
@@ -17,6 +17,9 @@ func (j *job) callIA(prompt string) (string, error) {
 	j.waitingPrompt()
 
 	prompt = strings.TrimSpace(prompt)
+	if followUps := j.followUpsPrompt(); followUps != "" && prompt != "" {
+		prompt = followUps + "\n\n" + prompt
+	}
 	if prompt == "" {
 		return "", fmt.Errorf(j.t("empty prompt"))
 	}
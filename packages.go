@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// goPackage is a package described by `go list -json`.
+type goPackage struct {
+	Dir          string
+	ImportPath   string
+	Name         string
+	GoFiles      []string
+	TestGoFiles  []string
+	XTestGoFiles []string
+	Imports      []string
+	Deps         []string
+	Module       *struct {
+		Path string
+		Main bool
+	}
+}
+
+// listPackages returns the packages of the main module found in the job folder.
+func (j *job) listPackages() ([]goPackage, error) {
+	cmd := exec.Command("go", "list", "-e", "-json", "./...")
+	cmd.Dir = j.fileDir
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf(j.t("error running go list")+": %v - %s", err, stderr.String())
+	}
+
+	var packages []goPackage
+	decoder := json.NewDecoder(&stdout)
+	for {
+		var pkg goPackage
+		if err := decoder.Decode(&pkg); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf(j.t("error decoding go list output")+": %v", err)
+		}
+		packages = append(packages, pkg)
+	}
+
+	return packages, nil
+}
+
+// changedFilePaths returns the absolute paths of the files modified by the job.
+func (j *job) changedFilePaths() []string {
+	files := append([]string{}, j.filesChanged...)
+	if j.currentSourceFileName != "" {
+		files = append(files, j.currentSourceFileName)
+	}
+	if j.currentTestFileName != "" {
+		files = append(files, j.currentTestFileName)
+	}
+
+	var paths []string
+	for _, file := range removeDuplicates(files) {
+		path, err := filepath.Abs(filepath.Join(j.fileDir, file))
+		if err != nil {
+			continue
+		}
+		paths = append(paths, path)
+	}
+	return removeDuplicates(paths)
+}
+
+// packagesToBuild returns the build patterns of the packages containing the changed files,
+// followed by the packages of the module that depend on them.
+func (j *job) packagesToBuild() ([]string, error) {
+	changedDirs := make(map[string]struct{})
+	for _, path := range j.changedFilePaths() {
+		changedDirs[filepath.Dir(path)] = struct{}{}
+	}
+
+	packages, err := j.listPackages()
+	if err != nil {
+		return nil, err
+	}
+
+	changedPackages := make(map[string]struct{})
+	for _, pkg := range packages {
+		if _, ok := changedDirs[pkg.Dir]; ok {
+			changedPackages[pkg.ImportPath] = struct{}{}
+			delete(changedDirs, pkg.Dir)
+		}
+	}
+
+	var patterns []string
+	for importPath := range changedPackages {
+		patterns = append(patterns, importPath)
+	}
+
+	// Reverse dependencies must be rebuilt as the changed packages may have broken them.
+	for _, pkg := range packages {
+		if _, ok := changedPackages[pkg.ImportPath]; ok {
+			continue
+		}
+		for _, dep := range pkg.Deps {
+			if _, ok := changedPackages[dep]; ok {
+				patterns = append(patterns, pkg.ImportPath)
+				break
+			}
+		}
+	}
+
+	// Folders unknown to `go list` (e.g. a file just created) are built from their path.
+	absFileDir, err := filepath.Abs(j.fileDir)
+	if err != nil {
+		return nil, err
+	}
+	for dir := range changedDirs {
+		rel, err := filepath.Rel(absFileDir, dir)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			continue
+		}
+		patterns = append(patterns, "./"+filepath.ToSlash(rel))
+	}
+
+	sort.Strings(patterns)
+	return patterns, nil
+}
+
+// buildPackages compiles the given packages and writes the binaries in a temporary folder.
+func (j *job) buildPackages(patterns []string) (string, error) {
+	outDir, err := os.MkdirTemp("", "goia-build-")
+	if err != nil {
+		return "", err
+	}
+
+	defer func() {
+		_ = os.RemoveAll(outDir)
+	}()
+
+	cmd := exec.Command("go", "build", "-o", outDir+string(filepath.Separator))
+	if goBuildSupportsJSON() {
+		cmd.Args = append(cmd.Args, "-json")
+	}
+	cmd.Args = append(cmd.Args, patterns...)
+	cmd.Dir = j.fileDir
+
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+
+	err = cmd.Run()
+
+	return buildOutputText(out.String()), err
+}
+
+// runGolangFile compiles the packages of the changed files and their reverse dependencies.
+func (j *job) runGolangFile() (string, error) {
+	patterns, err := j.packagesToBuild()
+	if err != nil {
+		log.WithError(err).Warn(j.t("Could not list the packages to build, building the whole module"))
+		patterns = nil
+	}
+
+	if len(patterns) == 0 {
+		patterns = []string{"./..."}
+	}
+
+	log.Infof("go build %s", strings.Join(patterns, " "))
+	return j.buildPackages(patterns)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// writeModule writes the files of a module in a temporary folder and returns the folder.
+func writeModule(t *testing.T, files map[string]string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestPackagesToBuild(t *testing.T) {
+	dir := writeModule(t, map[string]string{
+		"go.mod":  "module example.com/m\n\ngo 1.22\n",
+		"a/a.go":  "package a\n\nfunc A() int { return 1 }\n",
+		"b/b.go":  "package b\n\nimport \"example.com/m/a\"\n\nfunc B() int { return a.A() }\n",
+		"c/c.go":  "package c\n\nimport \"example.com/m/b\"\n\nfunc C() int { return b.B() }\n",
+		"d/d.go":  "package d\n\nfunc D() {}\n",
+		"main.go": "package main\n\nfunc main() {}\n",
+	})
+
+	tests := []struct {
+		name    string
+		changed []string
+		want    []string
+	}{
+		{
+			name:    "package and its reverse dependencies",
+			changed: []string{"a/a.go"},
+			want:    []string{"example.com/m/a", "example.com/m/b", "example.com/m/c"},
+		},
+		{
+			name:    "package without reverse dependency",
+			changed: []string{"d/d.go"},
+			want:    []string{"example.com/m/d"},
+		},
+		{
+			name:    "folder unknown to go list",
+			changed: []string{"e/e.go"},
+			want:    []string{"./e"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			j := &job{fileDir: dir, filesChanged: tt.changed}
+			got, err := j.packagesToBuild()
+			if err != nil {
+				t.Fatalf("packagesToBuild() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("packagesToBuild() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
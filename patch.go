@@ -0,0 +1,378 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"regexp"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// responseFormatCode asks the model for whole declarations, merged by stepFixCode.
+	responseFormatCode = "code"
+	// responseFormatDiff asks the model for unified diffs or search/replace blocks.
+	responseFormatDiff = "diff"
+)
+
+// maxPatchFuzz is the maximum number of context lines ignored at each end of a hunk that does not match.
+const maxPatchFuzz = 2
+
+var (
+	regHunkHeader    = regexp.MustCompile(`^@@ -(\d+)(?:,\d+)? \+\d+(?:,\d+)? @@`)
+	regSearchReplace = regexp.MustCompile(`(?s)<<<<<<< SEARCH\r?\n(.*?)\r?\n?=======\r?\n(.*?)\r?\n?>>>>>>> REPLACE`)
+)
+
+// patchLine is a line of a hunk: ' ' for the context, '-' for a removed line and '+' for an added line.
+type patchLine struct {
+	op   byte
+	text string
+}
+
+// patchHunk is a change of a response in diff mode, from a unified diff hunk or a search/replace block.
+type patchHunk struct {
+	// oldStart is the line of the hunk in the file, or 0 when it is unknown.
+	oldStart int
+	lines    []patchLine
+}
+
+// old returns the lines of the file replaced by the hunk.
+func (h patchHunk) old() []string {
+	var lines []string
+	for _, line := range h.lines {
+		if line.op != '+' {
+			lines = append(lines, line.text)
+		}
+	}
+	return lines
+}
+
+// new returns the lines written by the hunk.
+func (h patchHunk) new() []string {
+	var lines []string
+	for _, line := range h.lines {
+		if line.op != '-' {
+			lines = append(lines, line.text)
+		}
+	}
+	return lines
+}
+
+// String returns the hunk as a unified diff hunk.
+func (h patchHunk) String() string {
+	var b strings.Builder
+	if h.oldStart > 0 {
+		fmt.Fprintf(&b, "@@ -%d +%d @@\n", h.oldStart, h.oldStart)
+	} else {
+		b.WriteString("@@ @@\n")
+	}
+	for _, line := range h.lines {
+		b.WriteByte(line.op)
+		b.WriteString(line.text)
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// patchReject is a hunk that does not apply to the file, because its lines are not found
+// or are found several times without a line number to choose.
+type patchReject struct {
+	hunk    patchHunk
+	matches int
+}
+
+// parsePatch returns the hunks of a response in diff mode, or none when the response is Go code.
+func parsePatch(code string) []patchHunk {
+	code = strings.TrimPrefix(strings.TrimSpace(code), "diff\n")
+
+	if matches := regSearchReplace.FindAllStringSubmatch(code, -1); matches != nil {
+		var hunks []patchHunk
+		for _, match := range matches {
+			var hunk patchHunk
+			for _, line := range splitPatchLines(match[1]) {
+				hunk.lines = append(hunk.lines, patchLine{op: '-', text: line})
+			}
+			for _, line := range splitPatchLines(match[2]) {
+				hunk.lines = append(hunk.lines, patchLine{op: '+', text: line})
+			}
+			hunks = append(hunks, hunk)
+		}
+		return hunks
+	}
+
+	var hunks []patchHunk
+	var current *patchHunk
+	lines := strings.Split(strings.ReplaceAll(code, "\r\n", "\n"), "\n")
+	for i, line := range lines {
+		switch {
+		case strings.HasPrefix(line, "@@"):
+			hunks = append(hunks, patchHunk{})
+			current = &hunks[len(hunks)-1]
+			if match := regHunkHeader.FindStringSubmatch(line); match != nil {
+				current.oldStart, _ = strconv.Atoi(match[1])
+			}
+		case current == nil:
+			// The headers of the diff are ignored, the file is the one of the response.
+		case strings.HasPrefix(line, "--- ") && i+1 < len(lines) && strings.HasPrefix(lines[i+1], "+++ "):
+			current = nil
+		case strings.HasPrefix(line, "\\"), line == "```":
+		case line == "":
+			current.lines = append(current.lines, patchLine{op: ' '})
+		case line[0] == ' ' || line[0] == '-' || line[0] == '+':
+			current.lines = append(current.lines, patchLine{op: line[0], text: line[1:]})
+		default:
+			// The models sometimes drop the space of the context lines.
+			current.lines = append(current.lines, patchLine{op: ' ', text: line})
+		}
+	}
+
+	// The empty lines at the end of the hunks are the separators of the response.
+	for i := range hunks {
+		for n := len(hunks[i].lines); n > 0 && hunks[i].lines[n-1] == (patchLine{op: ' '}); n-- {
+			hunks[i].lines = hunks[i].lines[:n-1]
+		}
+	}
+	return hunks
+}
+
+// splitPatchLines splits the text of a search/replace block into lines.
+func splitPatchLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+}
+
+// normalizeSpaces returns a line without its leading, trailing and repeated whitespace.
+func normalizeSpaces(line string) string {
+	return strings.Join(strings.Fields(line), " ")
+}
+
+// matchLines checks whether the lines of the file at a position are the expected lines.
+func matchLines(lines []string, pos int, expected []string, loose bool) bool {
+	if pos < 0 || pos+len(expected) > len(lines) {
+		return false
+	}
+	for i, line := range expected {
+		if lines[pos+i] == line {
+			continue
+		}
+		if !loose || normalizeSpaces(lines[pos+i]) != normalizeSpaces(line) {
+			return false
+		}
+	}
+	return true
+}
+
+// locateHunk finds where a hunk applies: the lines are first compared exactly, then ignoring the whitespace,
+// then ignoring up to maxPatchFuzz context lines at each end. It returns the position in the file and the
+// lines of the hunk that apply there, or the number of matches when it is not found once.
+func locateHunk(lines []string, hunk patchHunk, expected int) (int, []patchLine, int) {
+	for fuzz := 0; fuzz <= maxPatchFuzz; fuzz++ {
+		ops, lead, ok := trimContext(hunk.lines, fuzz)
+		if !ok {
+			break
+		}
+		old := patchHunk{lines: ops}.old()
+		if len(old) == 0 {
+			break
+		}
+
+		for _, loose := range []bool{false, true} {
+			var candidates []int
+			for pos := 0; pos+len(old) <= len(lines); pos++ {
+				if matchLines(lines, pos, old, loose) {
+					candidates = append(candidates, pos)
+				}
+			}
+			if len(candidates) == 0 {
+				continue
+			}
+
+			if hunk.oldStart == 0 {
+				if len(candidates) > 1 {
+					return 0, nil, len(candidates)
+				}
+				return candidates[0], ops, 1
+			}
+
+			best := candidates[0]
+			for _, pos := range candidates[1:] {
+				if abs(pos-expected-lead) < abs(best-expected-lead) {
+					best = pos
+				}
+			}
+			return best, ops, 1
+		}
+	}
+	return 0, nil, 0
+}
+
+// trimContext removes fuzz context lines at each end of a hunk, and returns the number of lines removed
+// at the start. It fails when neither end has fuzz context lines left to remove.
+func trimContext(lines []patchLine, fuzz int) ([]patchLine, int, bool) {
+	if fuzz == 0 {
+		return lines, 0, true
+	}
+	lead, trail := 0, 0
+	for lead < fuzz && lead < len(lines) && lines[lead].op == ' ' {
+		lead++
+	}
+	for trail < fuzz && trail < len(lines)-lead && lines[len(lines)-1-trail].op == ' ' {
+		trail++
+	}
+	if lead < fuzz && trail < fuzz {
+		return nil, 0, false
+	}
+	return lines[lead : len(lines)-trail], lead, true
+}
+
+// abs returns the absolute value of an integer.
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// applyPatch applies the hunks of a response in order, tolerating the offset and the whitespace
+// differences, and returns the patched source with the hunks that do not apply.
+func applyPatch(src []byte, hunks []patchHunk) ([]byte, []patchReject) {
+	lines := strings.Split(string(src), "\n")
+	offset := 0
+
+	var rejects []patchReject
+	for _, hunk := range hunks {
+		expected := hunk.oldStart - 1 + offset
+
+		if len(hunk.old()) == 0 {
+			// A hunk without context is inserted at its line, or appended to the file.
+			pos := len(lines)
+			if hunk.oldStart > 0 && expected <= len(lines) {
+				pos = max(expected+1, 0)
+			}
+			added := hunk.new()
+			lines = append(lines[:pos], append(added, lines[pos:]...)...)
+			offset += len(added)
+			continue
+		}
+
+		pos, ops, matches := locateHunk(lines, hunk, expected)
+		if matches != 1 {
+			rejects = append(rejects, patchReject{hunk: hunk, matches: matches})
+			continue
+		}
+
+		// The context lines keep the text of the file.
+		var replacement []string
+		i := pos
+		for _, line := range ops {
+			switch line.op {
+			case ' ':
+				replacement = append(replacement, lines[i])
+				i++
+			case '-':
+				i++
+			case '+':
+				replacement = append(replacement, line.text)
+			}
+		}
+
+		lines = append(lines[:pos], append(replacement, lines[i:]...)...)
+		offset += len(replacement) - (i - pos)
+	}
+
+	return []byte(strings.Join(lines, "\n")), rejects
+}
+
+// stepApplyPatch applies the hunks of a response in diff mode to a file. The hunks that do not apply
+// are merged as declarations by stepFixCode when they contain whole declarations, and are otherwise
+// hasCompleteDeclaration checks whether lines of code parse to declarations with at least one complete
+// function, type, constant or variable.
+func hasCompleteDeclaration(lines []string) bool {
+	code := strings.TrimSpace(strings.Join(lines, "\n"))
+	if !strings.HasPrefix(code, "package") {
+		code = "package main\n\n" + code
+	}
+	node, err := parser.ParseFile(token.NewFileSet(), "", code, 0)
+	if err != nil {
+		return false
+	}
+
+	for _, decl := range node.Decls {
+		switch decl := decl.(type) {
+		case *ast.FuncDecl:
+			if isCompleteFunction(decl) {
+				return true
+			}
+		case *ast.GenDecl:
+			if decl.Tok != token.IMPORT {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// reported to the model with the next prompt.
+func (j *job) stepApplyPatch(currentFileName string, hunks []patchHunk) ([]byte, error) {
+	var data []byte
+	if currentFileName == j.currentSourceFileName {
+		data = j.currentSrcSource
+	} else if currentFileName == j.currentTestFileName {
+		data = j.currentSrcTest
+	}
+
+	result, rejects := applyPatch(data, hunks)
+
+	var reported []patchReject
+	for _, reject := range rejects {
+		// Only the hunks adding or changing whole declarations can be merged: the deletions would be lost.
+		if !hasCompleteDeclaration(reject.hunk.new()) {
+			reported = append(reported, reject)
+			continue
+		}
+		merged, err := j.mergeCode(currentFileName, result, strings.Join(reject.hunk.new(), "\n"))
+		if err != nil || bytes.Equal(merged, result) {
+			reported = append(reported, reject)
+			continue
+		}
+		log.Infof(j.t("A hunk that does not apply to %s is merged as declarations"), currentFileName)
+		result = merged
+	}
+
+	if len(reported) > 0 {
+		prompt := fmt.Sprintf(j.t("The following hunks of the previous response do not apply to %s and are ignored"), currentFileName) + ":\n\n"
+		for _, reject := range reported {
+			reason := j.t("the lines to replace are not found in the file")
+			if reject.matches > 1 {
+				reason = fmt.Sprintf(j.t("the lines to replace are found %d times in the file"), reject.matches)
+			}
+			log.Warnf(j.t("Hunk rejected")+" (%s):\n%s", reason, reject.hunk)
+			prompt += "```diff\n" + reject.hunk.String() + "```\n" + reason + ".\n\n"
+		}
+		j.followUps = append(j.followUps, prompt+j.t("Send them again with the exact current lines of the file as context")+".")
+	}
+
+	formatted, err := format.Source(result)
+	if err != nil {
+		// The syntax errors are reported by the compilation.
+		log.WithError(err).Warn(j.t("The patched file is not formatted"))
+		return result, nil
+	}
+	return formatted, nil
+}
+
+// diffModePrompt returns the instructions asking the model to reply with diffs.
+func (j *job) diffModePrompt() string {
+	return j.t("When you modify an existing file, do not return whole functions: return a unified diff of the file in a ```diff block, " +
+		"with @@ -<line>,<count> +<line>,<count> @@ hunk headers and 3 lines of context copied exactly from the current file, " +
+		"or search/replace blocks made of the lines `<<<<<<< SEARCH`, the exact current lines, `=======`, the new lines and `>>>>>>> REPLACE`. " +
+		"New files are returned as code")
+}
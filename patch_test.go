@@ -0,0 +1,200 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParsePatch(t *testing.T) {
+	tests := []struct {
+		name string
+		code string
+		want []patchHunk
+	}{
+		{
+			name: "go code",
+			code: "func F() int {\n\treturn 1\n}",
+			want: nil,
+		},
+		{
+			name: "unified diff",
+			code: "```diff\n--- a/p.go\n+++ b/p.go\n@@ -3,3 +3,3 @@ func F() int {\n func F() int {\n-\treturn 1\n+\treturn 2\n\n}\n\n```",
+			want: []patchHunk{{oldStart: 3, lines: []patchLine{
+				{op: ' ', text: "func F() int {"},
+				{op: '-', text: "\treturn 1"},
+				{op: '+', text: "\treturn 2"},
+				{op: ' '},
+				{op: ' ', text: "}"},
+			}}},
+		},
+		{
+			name: "several files and hunks without line",
+			code: "--- a/p.go\n+++ b/p.go\n@@ @@\n-a\n+b\n--- a/q.go\n+++ b/q.go\n@@ -1 +1 @@\n-c\n\\ No newline at end of file\n+d\n",
+			want: []patchHunk{
+				{lines: []patchLine{{op: '-', text: "a"}, {op: '+', text: "b"}}},
+				{oldStart: 1, lines: []patchLine{{op: '-', text: "c"}, {op: '+', text: "d"}}},
+			},
+		},
+		{
+			name: "search and replace blocks",
+			code: "<<<<<<< SEARCH\n\treturn 1\n=======\n\treturn 2\n\treturn 3\n>>>>>>> REPLACE\n\n<<<<<<< SEARCH\nfunc G() {}\n=======\n>>>>>>> REPLACE",
+			want: []patchHunk{
+				{lines: []patchLine{{op: '-', text: "\treturn 1"}, {op: '+', text: "\treturn 2"}, {op: '+', text: "\treturn 3"}}},
+				{lines: []patchLine{{op: '-', text: "func G() {}"}}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parsePatch(tt.code); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parsePatch() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyPatch(t *testing.T) {
+	src := "package p\n\nfunc F() int {\n\treturn 1\n}\n\nfunc G() int {\n\treturn 1\n}\n"
+
+	tests := []struct {
+		name        string
+		patch       string
+		want        string
+		wantRejects []int
+	}{
+		{
+			name:  "exact hunk",
+			patch: "@@ -3,3 +3,3 @@\n func F() int {\n-\treturn 1\n+\treturn 2\n }\n",
+			want:  "package p\n\nfunc F() int {\n\treturn 2\n}\n\nfunc G() int {\n\treturn 1\n}\n",
+		},
+		{
+			name:  "line offset",
+			patch: "@@ -1,3 +1,3 @@\n func G() int {\n-\treturn 1\n+\treturn 3\n }\n",
+			want:  "package p\n\nfunc F() int {\n\treturn 1\n}\n\nfunc G() int {\n\treturn 3\n}\n",
+		},
+		{
+			name:  "closest of several matches",
+			patch: "@@ -8,1 +8,1 @@\n-\treturn 1\n+\treturn 4\n",
+			want:  "package p\n\nfunc F() int {\n\treturn 1\n}\n\nfunc G() int {\n\treturn 4\n}\n",
+		},
+		{
+			name:        "several matches without line",
+			patch:       "<<<<<<< SEARCH\n\treturn 1\n=======\n\treturn 5\n>>>>>>> REPLACE",
+			want:        src,
+			wantRejects: []int{2},
+		},
+		{
+			name:  "whitespace differences",
+			patch: "<<<<<<< SEARCH\nfunc  F()   int {\n=======\nfunc F() int64 {\n>>>>>>> REPLACE",
+			want:  "package p\n\nfunc F() int64 {\n\treturn 1\n}\n\nfunc G() int {\n\treturn 1\n}\n",
+		},
+		{
+			name:  "wrong context lines",
+			patch: "@@ -3,5 +3,5 @@\n // F returns one.\n func F() int {\n-\treturn 1\n+\treturn 6\n }\n // end\n",
+			want:  "package p\n\nfunc F() int {\n\treturn 6\n}\n\nfunc G() int {\n\treturn 1\n}\n",
+		},
+		{
+			name:        "lines not found",
+			patch:       "<<<<<<< SEARCH\nfunc H() {}\n=======\n>>>>>>> REPLACE",
+			want:        src,
+			wantRejects: []int{0},
+		},
+		{
+			name:  "addition at the end",
+			patch: "@@ @@\n+\n+func H() {}\n",
+			want:  src + "\n\nfunc H() {}",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, rejects := applyPatch([]byte(src), parsePatch(tt.patch))
+			if string(got) != tt.want {
+				t.Errorf("applyPatch() =\n%s\nwant\n%s", got, tt.want)
+			}
+
+			var matches []int
+			for _, reject := range rejects {
+				matches = append(matches, reject.matches)
+			}
+			if !reflect.DeepEqual(matches, tt.wantRejects) {
+				t.Errorf("applyPatch() rejects = %v, want %v", matches, tt.wantRejects)
+			}
+		})
+	}
+}
+
+func TestTrimContext(t *testing.T) {
+	lines := []patchLine{{op: ' ', text: "a"}, {op: '-', text: "b"}, {op: ' ', text: "c"}, {op: ' ', text: "d"}}
+
+	tests := []struct {
+		fuzz     int
+		want     []patchLine
+		wantLead int
+		wantOk   bool
+	}{
+		{0, lines, 0, true},
+		{1, lines[1:3], 1, true},
+		{2, lines[1:2], 1, true},
+		{3, nil, 0, false},
+	}
+
+	for _, tt := range tests {
+		got, lead, ok := trimContext(lines, tt.fuzz)
+		if !reflect.DeepEqual(got, tt.want) || lead != tt.wantLead || ok != tt.wantOk {
+			t.Errorf("trimContext(%d) = %v, %d, %v, want %v, %d, %v", tt.fuzz, got, lead, ok, tt.want, tt.wantLead, tt.wantOk)
+		}
+	}
+}
+
+func TestStepApplyPatch(t *testing.T) {
+	src := "package p\n\nfunc F() int {\n\treturn 1\n}\n\nfunc G() int {\n\treturn 1\n}\n"
+
+	tests := []struct {
+		name         string
+		patch        string
+		want         string
+		wantFollowUp bool
+	}{
+		{
+			name:  "declaration merged",
+			patch: "<<<<<<< SEARCH\nfunc F() int {\n\treturn 10\n}\n=======\nfunc F() int {\n\treturn 2\n}\n>>>>>>> REPLACE",
+			want:  "package p\n\nfunc F() int {\n\treturn 2\n}\n\nfunc G() int {\n\treturn 1\n}\n",
+		},
+		{
+			name:         "deletion",
+			patch:        "<<<<<<< SEARCH\nfunc H() int {\n\treturn 1\n}\n=======\n>>>>>>> REPLACE",
+			want:         src,
+			wantFollowUp: true,
+		},
+		{
+			name:         "deletion with context lines",
+			patch:        "@@ -3,3 +3,2 @@\n func F() int {\n-\treturn 10\n",
+			want:         src,
+			wantFollowUp: true,
+		},
+		{
+			name:         "declaration already in the file",
+			patch:        "<<<<<<< SEARCH\nfunc F() int {\n\treturn 7\n}\n=======\nfunc F() int {\n\treturn 1\n}\n>>>>>>> REPLACE",
+			want:         src,
+			wantFollowUp: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			j := &job{fileDir: t.TempDir(), currentSourceFileName: "p.go", currentSrcSource: []byte(src)}
+			got, err := j.stepApplyPatch("p.go", parsePatch(tt.patch))
+			if err != nil {
+				t.Fatalf("stepApplyPatch() error = %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("stepApplyPatch() =\n%s\nwant\n%s", got, tt.want)
+			}
+			if (len(j.followUps) > 0) != tt.wantFollowUp {
+				t.Errorf("stepApplyPatch() follow-ups = %q, want a follow-up %v", j.followUps, tt.wantFollowUp)
+			}
+		})
+	}
+}
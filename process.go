@@ -1,11 +1,9 @@
 package main
 
 import (
-	"bytes"
 	"fmt"
 	"go/ast"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"regexp"
 
@@ -26,10 +24,16 @@ type Conversation struct {
 type job struct {
 	args                  *appArgs
 	cache                 *ConfigCache
+	characterizationTests []string
 	fileDir               string
 	fileDirSelected       string
 	fileName              string
+	followUps             []string
 	fileWithVendor        bool
+	filesChanged          []string
+	gatesConfig           GatesConfig
+	flakyConfig           FlakyConfig
+	frozenFiles           map[string]bool
 	conversation          Conversation
 	listFiles             []string
 	currentFileDir        string
@@ -39,18 +43,29 @@ type job struct {
 	currentStep           step
 	currentSrcSource      []byte
 	currentSrcTest        []byte
+	baseContents          map[string][]byte
 	repoStructure         string
+	runConfig             RunConfig
+	sandboxConfig         SandboxConfig
+	sandbox               *sandbox
 	lang                  string
+	local                 string
+	prefixes              []string
 	listFunctionsCreated  []string
 	listFunctionsUpdated  []string
 	maxAttempts           int
 	mockOpenAIResponse    bool
 	modulePath            string
+	mutationConfig        MutationConfig
 	openAIApiKey          secret.String
 	openAIURL             string
 	openAIMaxTokens       int
+	responseFormat        string
+	rewriteBaseline       map[string]bool // tests failing before the rewrite, nil outside the rewrite steps
+	rewriteSteps          []string
 	source                fileSource
 	trad                  Translations
+	unstableTests         map[string]testClass
 	validateEachStep      bool
 }
 
@@ -89,6 +104,12 @@ func newJob(cache *ConfigCache, fileDir string, args *appArgs) (*job, error) {
 		lang:                  "en",
 		args:                  args,
 		validateEachStep:      cache.rootConfig.ValidateEachStep,
+		runConfig:             cache.rootConfig.Run,
+		sandboxConfig:         cache.rootConfig.Sandbox,
+		gatesConfig:           cache.rootConfig.Gates,
+		flakyConfig:           cache.rootConfig.Flaky,
+		mutationConfig:        cache.rootConfig.Mutation,
+		unstableTests:         map[string]testClass{},
 	}
 
 	return &j, nil
@@ -101,7 +122,7 @@ func (j *job) run() error {
 	}
 
 	if err := j.findReposAndSubRepos(); err != nil {
-		log.WithError(err).Error("Error finding repos and subrepos: %v", err)
+		log.WithError(err).Error("Error finding repos and subrepos")
 	}
 
 	userPrompt, err := j.promptForQuery()
@@ -122,6 +143,10 @@ func (j *job) run() error {
 		j.currentStep = stepEntry.ValidStep
 		j.currentFileName = j.fileName
 
+		// The edits made on disk during the step are merged with the changes of the model.
+		j.recordBase(j.currentSourceFileName, j.currentSrcSource)
+		j.recordBase(j.currentTestFileName, j.currentSrcTest)
+
 		if j.currentStep == stepVerifyGoPrompt ||
 			j.currentStep == stepVerifyTestPrompt ||
 			j.currentStep == stepVerifySwaggerPrompt {
@@ -169,10 +194,28 @@ func (j *job) run() error {
 			prompt += "\n\n" + string(fileContent)
 		}
 
+		// The optimizations target the hotspots measured by the benchmarks.
+		if j.currentStep == stepOptimize {
+			evidence, err := j.profileEvidence()
+			if err != nil {
+				log.WithError(err).Error(j.t("Error profiling the benchmarks"))
+			} else if evidence != "" {
+				prompt += "\n\n" + evidence
+			}
+		}
+
 		if j.currentStep == stepAddTest {
 			j.currentFileName = j.currentTestFileName
 		}
 
+		// Untested code gets characterization tests before being rewritten.
+		if isRewriteStep(j.currentStep) {
+			if err := j.ensureCharacterizationTests(); err != nil {
+				log.WithError(err).Error(j.t("Error generating the characterization tests"))
+				return err
+			}
+		}
+
 		for attempt := 1; attempt <= j.maxAttempts; attempt++ {
 			log.Println("attempt:", attempt)
 			log.Infof("\nprompt: "+blue("%s")+"\n\n", prompt)
@@ -192,7 +235,7 @@ func (j *job) run() error {
 				parseListFolders := j.parseListFolders(code)
 				j.createFoldersFromList(parseListFolders)
 				if err := j.findReposAndSubRepos(); err != nil {
-					log.WithError(err).Error("Error finding repos and subrepos: %v", err)
+					log.WithError(err).Error("Error finding repos and subrepos")
 				}
 				break
 
@@ -254,6 +297,10 @@ func (j *job) run() error {
 		}
 	}
 
+	if report := j.unstableTestsReport(); report != "" {
+		log.Warn(report)
+	}
+
 	log.Info(j.t("End of the job") + "\n\n" + j.t("Restarting the job ?"))
 	j.reinJob()
 	return j.run()
@@ -308,7 +355,9 @@ func (j *job) runStepStart(stepEntry StepWithError, code string) (prompt, output
 
 				log.Infof("API response:\n\n"+green("\"%s\"")+"\n\n", codeReceived)
 
-				if err = j.fixCodeAndWriteFile(file, codeReceived); err != nil {
+				// Once the source file is done, the replies fix the tests: the file to modify is given by the reply.
+				fileToModify, code := j.splitFileNameAndCode(codeReceived)
+				if err = j.fixCodeAndWriteFile(fileToModify, code); err != nil {
 					log.WithError(err).Error(j.t("Error fixing code and writing file"))
 					return
 				}
@@ -350,14 +399,31 @@ func (j *job) runStepStart(stepEntry StepWithError, code string) (prompt, output
 
 // fixCodeAndWriteFile fixes the code and writes the file.
 func (j *job) fixCodeAndWriteFile(fileToModify, code string) (err error) {
+	// Les suppressions et les renommages sont appliqués au paquet avant la fusion du code.
+	ops, code := parseDeclOps(code)
+	if err = j.applyDeclOps(fileToModify, ops); err != nil {
+		log.WithError(err).Error(j.t("Error applying the operations on declarations"))
+		return
+	}
+
+	// Only the responses asked in diff mode are read as patches, Go code can contain lines looking like hunks.
+	var hunks []patchHunk
+	if j.responseFormat == responseFormatDiff {
+		hunks = parsePatch(code)
+	}
+
 	var codeModified []byte
-	codeModified, err = j.stepFixCode(fileToModify, code)
+	if len(hunks) > 0 {
+		codeModified, err = j.stepApplyPatch(fileToModify, hunks)
+	} else {
+		codeModified, err = j.stepFixCode(fileToModify, code)
+	}
 	if err != nil {
 		log.WithError(err).Error(j.t("Error to get code modified"))
 		return
 	}
 
-	if err = j.writeFile(fileToModify, codeModified); err != nil {
+	if err = j.writeResponse(fileToModify, codeModified); err != nil {
 		log.WithError(err).Error(j.t("Error updating file"))
 		return
 	}
@@ -418,7 +484,7 @@ func (j *job) runContentForFile(stepEntry StepWithError) (prompt, output string,
 		log.Infof("------------------------------------ code result (failed): \n\n %s", output)
 		j.currentStep = stepEntry.ErrorStep
 
-		var unusedImports []string
+		var unusedImports map[string][]string
 		unusedImports, err = j.extractUnusedImports(output)
 		if err != nil {
 			log.WithError(err).Error(j.t("Error when extract unused imports"))
@@ -426,11 +492,12 @@ func (j *job) runContentForFile(stepEntry StepWithError) (prompt, output string,
 		}
 
 		if len(unusedImports) > 0 {
-			log.Infof("------------------------------------ fix unused imports: \n\n%v", magenta(unusedImports))
-			err = j.removeUnusedImports(unusedImports, j.currentSourceFileName)
-			if err != nil {
-				fmt.Println(j.t("Error deleting imports")+":", err)
-				return
+			for file, imports := range unusedImports {
+				log.Infof("------------------------------------ fix unused imports in %s: \n\n%v", file, magenta(imports))
+				if err = j.removeUnusedImports(imports, file); err != nil {
+					fmt.Println(j.t("Error deleting imports")+":", err)
+					return
+				}
 			}
 			log.Info("------------------------------------ imports fixed")
 			output, err = j.runGolangFile()
@@ -450,6 +517,7 @@ func (j *job) runContentForFile(stepEntry StepWithError) (prompt, output string,
 			prompt = j.t("Fix the following code that generated an error") + ":\n\n" + funcCode + "\n\n" +
 				j.t("Error") + " : " + output + "\n\n" +
 				j.t("responds without adding comments or explanations") + "\n\n" +
+				j.declOpsPrompt() + ".\n\n" +
 				j.t("Generates a concise response that specifies the file to modify in the form: \"MODIFY: <function or section name> (source file, not test file)\"") + "." +
 				j.t("Then provide the corrected code in the form: \"CODE: <corrected code>\"") + "."
 
@@ -458,15 +526,90 @@ func (j *job) runContentForFile(stepEntry StepWithError) (prompt, output string,
 		}
 	}
 
+	var findings []gateFinding
+	findings, err = j.runGates()
+	if err != nil {
+		log.WithError(err).Error(j.t("Error running the validation gates"))
+		return
+	}
+	if len(findings) > 0 {
+		prompt, err = j.gatesPrompt(findings)
+		if err != nil {
+			log.WithError(err).Error(j.t("Error when extract errors from prompt"))
+			return
+		}
+		j.currentStep = stepEntry.ErrorStep
+		mustContinue = true
+		return
+	}
+
+	if j.runConfig.Enabled && !j.isTestFile(j.currentFileName) && j.isMainPackageFile(j.currentSourceFileName) {
+		prompt, output, err = j.runGate()
+		if err != nil {
+			log.WithError(err).Error(j.t("Error running the generated program"))
+			return
+		}
+		if prompt != "" {
+			log.Infof("------------------------------------ run result (failed): \n\n %s", output)
+			j.currentStep = stepEntry.ErrorStep
+			mustContinue = true
+			return
+		}
+	}
+
+	if j.rewriteBaseline != nil && !j.isTestFile(j.currentFileName) {
+		prompt, output, err = j.characterizationGate()
+		if err != nil {
+			log.WithError(err).Error(j.t("Error running the characterization tests"))
+			return
+		}
+		if prompt != "" {
+			log.Infof("------------------------------------ characterization tests (failed): \n\n %s", output)
+			j.currentStep = stepEntry.ErrorStep
+			mustContinue = true
+			return
+		}
+	}
+
 	if j.isTestFile(j.currentFileName) {
 
+		// Generated tests must use the test libraries of the project.
+		prompt, err = j.validateTestStyle()
+		if err != nil {
+			log.WithError(err).Error(j.t("Error checking the style of the tests"))
+			return
+		}
+		if prompt != "" {
+			j.currentStep = stepEntry.ErrorStep
+			mustContinue = true
+			return
+		}
+
 		output, err = j.runGolangTestFile()
 		if err != nil {
 			fmt.Println(fmt.Sprintf("------------------------------------ test result (failed): \n\n %s", output))
+
+			var failedTests, deterministicTests []string
+			failedTests, err = j.getFailedTests(output)
+			if err != nil {
+				return
+			}
+
+			// Tests that do not fail on every rerun are not worth a fix from the model.
+			deterministicTests, err = j.filterFlakyTests(output, failedTests)
+			if err != nil {
+				log.WithError(err).Error(j.t("Error rerunning the failed tests"))
+				return
+			}
+			if len(failedTests) > 0 && len(deterministicTests) == 0 {
+				log.Info(j.t("Only unstable tests failed"))
+				return
+			}
+
 			j.currentStep = stepEntry.ErrorStep
 
 			if j.currentStep == stepAddTestError {
-				prompt, err = j.stepAddTestErrorProcessPrompt(output)
+				prompt, err = j.stepAddTestErrorProcessPrompt(output, deterministicTests)
 				if err != nil {
 					return
 				}
@@ -487,6 +630,20 @@ func (j *job) runContentForFile(stepEntry StepWithError) (prompt, output string,
 			mustContinue = true
 			return
 		}
+
+		// Passing tests must also detect small changes of the source code.
+		if j.mutationConfig.Enabled {
+			prompt, err = j.mutationGate()
+			if err != nil {
+				log.WithError(err).Error(j.t("Error running the mutation testing"))
+				return
+			}
+			if prompt != "" {
+				j.currentStep = stepEntry.ErrorStep
+				mustContinue = true
+				return
+			}
+		}
 	}
 	return
 }
@@ -495,6 +652,10 @@ func (j *job) runContentForFile(stepEntry StepWithError) (prompt, output string,
 func (j *job) reinJob() {
 	j.listFunctionsUpdated = []string{}
 	j.listFunctionsCreated = []string{}
+	j.filesChanged = []string{}
+	j.unstableTests = map[string]testClass{}
+	j.characterizationTests = nil
+	j.rewriteBaseline = nil
 }
 
 // printTestsFuncName returns the names of the functions to test.
@@ -521,52 +682,16 @@ func isCompleteFunction(funcDecl *ast.FuncDecl) bool {
 	return false
 }
 
-// runGolangFile executes the Go file.
-func (j *job) runGolangFile() (string, error) {
-	var cmd *exec.Cmd
-	// Sinon, utiliser "go build" pour vérifier la compilation
-	// Cela ne nécessite pas que le package soit main ou qu'il y ait une fonction main
-	cmd = exec.Command("go", "build", "-o", "temp_binary")
-	// Vous pouvez spécifier le fichier ou le package à construire
-	cmd.Args = append(cmd.Args, j.currentSourceFileName)
-
-	// Spécifier le répertoire contenant le fichier et le go.mod
-	cmd.Dir = j.fileDir
-
-	// Préparer le buffer pour capturer la sortie
-	var out bytes.Buffer
-	cmd.Stdout = &out
-	cmd.Stderr = &out
-
-	// Exécuter la commande
-	err := cmd.Run()
-
-	// Nettoyer le binaire temporaire si "go build" a réussi
-	if err == nil {
-		// Supprimer le binaire temporaire généré par "go build"
-		removeCmd := exec.Command("rm", "temp_binary")
-		removeCmd.Dir = j.fileDir
-		removeCmd.Run()
-	}
-
-	return out.String(), err
-}
-
 // runGolangTestFile runs the Go test file.
 func (j *job) runGolangTestFile() (string, error) {
 	if j.currentTestFileName == "" {
 		return "", nil
 	}
 
-	cmd := exec.Command("go", "test", "./...")
-
-	cmd.Dir = j.fileDir
-
-	var out bytes.Buffer
-	cmd.Stdout = &out
-	cmd.Stderr = &out
-
-	err := cmd.Run()
+	result, err := j.runCommand(nil, nil, "go", "test", "./...")
+	if result == nil {
+		return "", err
+	}
 
-	return out.String(), err
+	return result.Output(), err
 }
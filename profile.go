@@ -0,0 +1,351 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// maxHotFunctions is the maximum number of hot functions sent to the model.
+	maxHotFunctions = 10
+	// maxAllocSites is the maximum number of allocation sites sent to the model.
+	maxAllocSites = 10
+	// maxEscapes is the maximum number of escape analysis results sent to the model.
+	maxEscapes = 20
+)
+
+var (
+	// regPprofClosure matches the suffix of the closures and of the type parameters in the pprof function names.
+	regPprofClosure = regexp.MustCompile(`(\.func\d+(\.\d+)*|\[[^\]]*\])`)
+	regPprofLine    = regexp.MustCompile(`^\s*(\S+)\s+(\S+%)\s+(\S+%)\s+(\S+)\s+(\S+%)\s+(.+)$`)
+)
+
+// pprofEntry is a line of the output of `go tool pprof -top`.
+type pprofEntry struct {
+	Flat, FlatPercent string
+	Cum, CumPercent   string
+	Function          string
+	// File and Line are only set with the -lines option.
+	File string
+	Line int
+}
+
+// funcRange is the position of a function declaration of a package.
+type funcRange struct {
+	Name       string
+	File       string
+	Start, End int
+}
+
+// parsePprofTop parses the output of `go tool pprof -top`.
+func parsePprofTop(output string) []pprofEntry {
+	var entries []pprofEntry
+	header := false
+	for _, line := range strings.Split(output, "\n") {
+		if !header {
+			header = strings.Contains(line, "flat%") && strings.Contains(line, "cum%")
+			continue
+		}
+
+		matches := regPprofLine.FindStringSubmatch(line)
+		if matches == nil {
+			continue
+		}
+
+		entry := pprofEntry{
+			Flat: matches[1], FlatPercent: matches[2],
+			Cum: matches[4], CumPercent: matches[5],
+		}
+
+		fields := strings.Fields(strings.TrimSuffix(matches[6], " (inline)"))
+		entry.Function = fields[0]
+		if len(fields) > 1 {
+			if i := strings.LastIndex(fields[1], ":"); i > 0 {
+				entry.File = fields[1][:i]
+				fmt.Sscanf(fields[1][i+1:], "%d", &entry.Line)
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// pprofFunctionName returns the name printed by pprof for a function declaration of a package.
+func pprofFunctionName(importPath string, funcDecl *ast.FuncDecl) string {
+	if funcDecl.Recv == nil || len(funcDecl.Recv.List) == 0 {
+		return importPath + "." + funcDecl.Name.Name
+	}
+
+	name := receiverTypeName(funcDecl.Recv)
+	if _, pointer := funcDecl.Recv.List[0].Type.(*ast.StarExpr); pointer {
+		name = "(*" + name + ")"
+	}
+	return importPath + "." + name + "." + funcDecl.Name.Name
+}
+
+// packageImportPathOf returns the import path of the package of a file of the module.
+func (j *job) packageImportPathOf(fileName string) string {
+	dir := filepath.ToSlash(filepath.Dir(fileName))
+	if dir == "." {
+		return j.modulePath
+	}
+	return j.modulePath + "/" + strings.TrimPrefix(dir, "./")
+}
+
+// packageFunctions returns the function declarations of the package of a file, indexed by their pprof name.
+func (j *job) packageFunctions(fileName string) map[string]funcRange {
+	functions := make(map[string]funcRange)
+	importPath := j.packageImportPathOf(fileName)
+
+	root, err := filepath.Abs(j.fileDir)
+	if err != nil {
+		return functions
+	}
+	files, err := goSourceFiles(filepath.Join(root, filepath.Dir(fileName)))
+	if err != nil {
+		return functions
+	}
+
+	for _, path := range files {
+		fs := token.NewFileSet()
+		node, err := parser.ParseFile(fs, path, nil, 0)
+		if err != nil {
+			continue
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			continue
+		}
+
+		for _, decl := range node.Decls {
+			funcDecl, ok := decl.(*ast.FuncDecl)
+			if !ok {
+				continue
+			}
+			name := pprofFunctionName(importPath, funcDecl)
+			functions[name] = funcRange{
+				Name:  declarationName(funcDecl),
+				File:  rel,
+				Start: fs.Position(funcDecl.Pos()).Line,
+				End:   fs.Position(funcDecl.End()).Line,
+			}
+		}
+	}
+	return functions
+}
+
+// relevantBenchmarks returns the benchmarks of the package of a file that use its declarations.
+func (j *job) relevantBenchmarks(fileName string) []string {
+	node, err := parser.ParseFile(token.NewFileSet(), filepath.Join(j.fileDir, fileName), nil, 0)
+	if err != nil {
+		return nil
+	}
+
+	declared := make(map[string]bool)
+	for _, decl := range node.Decls {
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			declared[d.Name.Name] = true
+		case *ast.GenDecl:
+			for _, spec := range d.Specs {
+				if typeSpec, ok := spec.(*ast.TypeSpec); ok {
+					declared[typeSpec.Name.Name] = true
+				}
+			}
+		}
+	}
+
+	testFiles, _ := filepath.Glob(filepath.Join(j.fileDir, filepath.Dir(fileName), "*_test.go"))
+
+	var benchmarks []string
+	for _, testFile := range testFiles {
+		testNode, err := parser.ParseFile(token.NewFileSet(), testFile, nil, 0)
+		if err != nil {
+			continue
+		}
+
+		for _, decl := range testNode.Decls {
+			funcDecl, ok := decl.(*ast.FuncDecl)
+			if !ok || funcDecl.Recv != nil || funcDecl.Body == nil || !strings.HasPrefix(funcDecl.Name.Name, "Benchmark") {
+				continue
+			}
+
+			uses := false
+			ast.Inspect(funcDecl.Body, func(n ast.Node) bool {
+				if ident, ok := n.(*ast.Ident); ok && declared[ident.Name] {
+					uses = true
+				}
+				return !uses
+			})
+			if uses {
+				benchmarks = append(benchmarks, funcDecl.Name.Name)
+			}
+		}
+	}
+
+	sort.Strings(benchmarks)
+	return benchmarks
+}
+
+// pprofTop runs `go tool pprof -top` on a profile.
+func (j *job) pprofTop(profile string, args ...string) ([]pprofEntry, error) {
+	cmdArgs := append([]string{"tool", "pprof", "-top", "-nodecount=200"}, args...)
+	cmd := exec.Command("go", append(cmdArgs, profile)...)
+	cmd.Dir = j.fileDir
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf(j.t("error reading the profile")+": %v - %s", err, output)
+	}
+	return parsePprofTop(string(output)), nil
+}
+
+// escapeAnalysis returns the heap escapes reported by `go build -gcflags=-m` in the given functions.
+func (j *job) escapeAnalysis(fileName string, hot []funcRange) []diagnostic {
+	result, err := j.runCommand(nil, nil, "go", "build", "-gcflags=-m", "./"+filepath.ToSlash(filepath.Dir(fileName)))
+	if err != nil && result == nil {
+		log.WithError(err).Warn(j.t("Error running the escape analysis"))
+		return nil
+	}
+
+	var escapes []diagnostic
+	for _, d := range parseDiagnostics(result.Output()) {
+		if !strings.Contains(d.Message, "escapes to heap") && !strings.Contains(d.Message, "moved to heap") {
+			continue
+		}
+		for _, fn := range hot {
+			if sameFileName(j.diagnosticFilePath(d.File), filepath.Join(j.fileDir, fn.File)) && d.Line >= fn.Start && d.Line <= fn.End {
+				escapes = append(escapes, d)
+				break
+			}
+		}
+		if len(escapes) >= maxEscapes {
+			break
+		}
+	}
+	return escapes
+}
+
+// profileEvidence runs the benchmarks of the current source file with the CPU and memory profilers,
+// and returns its hot functions, allocation sites and heap escapes for the optimize prompt.
+func (j *job) profileEvidence() (string, error) {
+	benchmarks := j.relevantBenchmarks(j.currentSourceFileName)
+	if len(benchmarks) == 0 {
+		log.Info(j.t("No benchmark found for the file, the optimization is not guided by a profile"))
+		return "", nil
+	}
+
+	dir, err := os.MkdirTemp("", "goia-profile-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+
+	cpuProfile, memProfile := filepath.Join(dir, "cpu.out"), filepath.Join(dir, "mem.out")
+	result, err := j.runCommand(nil, nil, "go", "test", "-run", "^$", "-bench", failedTestsRunPattern(benchmarks), "-benchmem",
+		"-cpuprofile", cpuProfile, "-memprofile", memProfile, "-o", filepath.Join(dir, "pkg.test"),
+		"./"+filepath.ToSlash(filepath.Dir(j.currentSourceFileName)))
+	if err != nil {
+		if result == nil {
+			return "", err
+		}
+		log.WithError(err).Warnf(j.t("Error running the benchmarks")+":\n\n%s", result.Output())
+		return "", nil
+	}
+
+	functions := j.packageFunctions(j.currentSourceFileName)
+	inFile := func(name string) (funcRange, bool) {
+		fn, ok := functions[regPprofClosure.ReplaceAllString(name, "")]
+		return fn, ok && sameFileName(fn.File, j.currentSourceFileName)
+	}
+
+	cpu, err := j.pprofTop(cpuProfile, "-cum")
+	if err != nil {
+		return "", err
+	}
+
+	var hot []funcRange
+	var hotLines []string
+	seen := make(map[string]bool)
+	for _, entry := range cpu {
+		fn, ok := inFile(entry.Function)
+		if !ok || seen[fn.Name] {
+			continue
+		}
+		seen[fn.Name] = true
+		hot = append(hot, fn)
+		hotLines = append(hotLines, fmt.Sprintf("- %s (%s:%d): flat %s (%s), cum %s (%s)",
+			fn.Name, fn.File, fn.Start, entry.Flat, entry.FlatPercent, entry.Cum, entry.CumPercent))
+		if len(hot) >= maxHotFunctions {
+			break
+		}
+	}
+
+	allocs, err := j.pprofTop(memProfile, "-sample_index=alloc_space", "-lines")
+	if err != nil {
+		return "", err
+	}
+
+	var allocLines []string
+	for _, entry := range allocs {
+		fn, ok := inFile(entry.Function)
+		if !ok || entry.Flat == "0" {
+			continue
+		}
+		if !seen[fn.Name] {
+			seen[fn.Name] = true
+			hot = append(hot, fn)
+		}
+		allocLines = append(allocLines, fmt.Sprintf("- %s:%d in %s: %s (%s)", fn.File, entry.Line, fn.Name, entry.Flat, entry.FlatPercent))
+		if len(allocLines) >= maxAllocSites {
+			break
+		}
+	}
+
+	if len(hotLines) == 0 && len(allocLines) == 0 {
+		return "", nil
+	}
+
+	evidence := fmt.Sprintf(j.t("Profiling evidence collected by running the benchmarks %s"), strings.Join(benchmarks, ", ")) + ":\n\n" +
+		buildBenchmarkSummary(result.Stdout)
+
+	if len(hotLines) > 0 {
+		evidence += j.t("Hot functions (CPU, sorted by cumulative time)") + ":\n" + strings.Join(hotLines, "\n") + "\n\n"
+	}
+	if len(allocLines) > 0 {
+		evidence += j.t("Allocation sites (allocated bytes)") + ":\n" + strings.Join(allocLines, "\n") + "\n\n"
+	}
+	if escapes := j.escapeAnalysis(j.currentSourceFileName, hot); len(escapes) > 0 {
+		evidence += j.t("Escape analysis of these functions") + ":\n"
+		for _, d := range escapes {
+			evidence += "- " + d.String() + "\n"
+		}
+		evidence += "\n"
+	}
+
+	return evidence + j.t("Focus the optimizations on these hotspots, and keep the code that does not appear in the profile unchanged") + ".", nil
+}
+
+// buildBenchmarkSummary returns the result lines of the benchmarks.
+func buildBenchmarkSummary(output string) string {
+	var lines []string
+	for _, line := range strings.Split(output, "\n") {
+		if strings.HasPrefix(line, "Benchmark") {
+			lines = append(lines, strings.Join(strings.Fields(line), " "))
+		}
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.Join(lines, "\n") + "\n\n"
+}
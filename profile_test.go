@@ -0,0 +1,112 @@
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParsePprofTop(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   []pprofEntry
+	}{
+		{
+			name:   "no header",
+			output: "      10ms 50.00% 50.00%       10ms 50.00%  main.f\n",
+			want:   nil,
+		},
+		{
+			name: "functions",
+			output: "File: m.test\nType: cpu\n" +
+				"      flat  flat%   sum%        cum   cum%\n" +
+				"     120ms 60.00% 60.00%      150ms 75.00%  example.com/m.(*Parser).next\n" +
+				"      30ms 15.00% 75.00%       30ms 15.00%  runtime.memmove\n",
+			want: []pprofEntry{
+				{Flat: "120ms", FlatPercent: "60.00%", Cum: "150ms", CumPercent: "75.00%", Function: "example.com/m.(*Parser).next"},
+				{Flat: "30ms", FlatPercent: "15.00%", Cum: "30ms", CumPercent: "15.00%", Function: "runtime.memmove"},
+			},
+		},
+		{
+			name: "lines",
+			output: "      flat  flat%   sum%        cum   cum%\n" +
+				"    1.50MB 80.00% 80.00%     1.50MB 80.00%  example.com/m.Parse /src/m/parse.go:42 (inline)\n",
+			want: []pprofEntry{
+				{Flat: "1.50MB", FlatPercent: "80.00%", Cum: "1.50MB", CumPercent: "80.00%", Function: "example.com/m.Parse", File: "/src/m/parse.go", Line: 42},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parsePprofTop(tt.output); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parsePprofTop() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPprofFunctionName(t *testing.T) {
+	src := "package m\n\nfunc F() {}\nfunc (p *Parser) Next() {}\nfunc (p Parser) Peek() {}\nfunc (l *List[T]) Push() {}\n"
+	file, err := parser.ParseFile(token.NewFileSet(), "", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"example.com/m.F", "example.com/m.(*Parser).Next", "example.com/m.Parser.Peek", "example.com/m.(*List).Push"}
+	var got []string
+	for _, decl := range file.Decls {
+		got = append(got, pprofFunctionName("example.com/m", decl.(*ast.FuncDecl)))
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("pprofFunctionName() = %q, want %q", got, want)
+	}
+}
+
+func TestPackageFunctions(t *testing.T) {
+	dir := writeModule(t, map[string]string{
+		"p/p.go": "package p\n\nfunc F() {\n}\n\ntype T struct{}\n\nfunc (t *T) M() {}\n",
+	})
+	j := &job{fileDir: dir, modulePath: "example.com/m"}
+
+	want := map[string]funcRange{
+		"example.com/m/p.F":      {Name: "F", File: filepath.Join("p", "p.go"), Start: 3, End: 4},
+		"example.com/m/p.(*T).M": {Name: "(*T) M", File: filepath.Join("p", "p.go"), Start: 8, End: 8},
+	}
+	if got := j.packageFunctions("p/p.go"); !reflect.DeepEqual(got, want) {
+		t.Errorf("packageFunctions() = %#v, want %#v", got, want)
+	}
+}
+
+func TestRelevantBenchmarks(t *testing.T) {
+	dir := writeModule(t, map[string]string{
+		"p/parse.go": "package p\n\ntype Parser struct{}\n\nfunc Parse(s string) int { return len(s) }\n",
+		"p/other.go": "package p\n\nfunc Other() {}\n",
+		"p/p_test.go": "package p\n\nimport \"testing\"\n\n" +
+			"func BenchmarkParse(b *testing.B) { Parse(\"x\") }\n" +
+			"func BenchmarkParser(b *testing.B) { _ = Parser{} }\n" +
+			"func BenchmarkOther(b *testing.B) { Other() }\n" +
+			"func TestParse(t *testing.T) { Parse(\"x\") }\n",
+	})
+	j := &job{fileDir: dir}
+
+	want := []string{"BenchmarkParse", "BenchmarkParser"}
+	if got := j.relevantBenchmarks("p/parse.go"); !reflect.DeepEqual(got, want) {
+		t.Errorf("relevantBenchmarks() = %v, want %v", got, want)
+	}
+}
+
+func TestBuildBenchmarkSummary(t *testing.T) {
+	output := "goos: linux\nBenchmarkParse-8   \t 1000000\t      1052 ns/op\t     128 B/op\nPASS\n"
+	want := "BenchmarkParse-8 1000000 1052 ns/op 128 B/op\n\n"
+	if got := buildBenchmarkSummary(output); got != want {
+		t.Errorf("buildBenchmarkSummary() = %q, want %q", got, want)
+	}
+	if got := buildBenchmarkSummary("PASS\n"); got != "" {
+		t.Errorf("buildBenchmarkSummary() without benchmark = %q", got)
+	}
+}
@@ -54,10 +54,15 @@ func (j *job) waitingPrompt() {
 }
 
 func (j *job) archiPrompt() map[string]string {
+	content := j.t("Here is the current project tree") + ": " + j.repoStructure + ".\n\n" +
+		j.t("Here is the main import path to use from root") + ": " + j.modulePath + "."
+	if j.responseFormat == responseFormatDiff {
+		content += "\n\n" + j.diffModePrompt() + "."
+	}
+
 	return map[string]string{
-		"role": "system",
-		"content": j.t("Here is the current project tree") + ": " + j.repoStructure + ".\n\n" +
-			j.t("Here is the main import path to use from root") + ": " + j.modulePath + ".",
+		"role":    "system",
+		"content": content,
 	}
 }
 
@@ -67,6 +72,7 @@ func (j *job) prepareGoPrompt(userPrompt string) string {
 
 	goContextSuffix := ".\n\n" +
 		j.t("In your response, for each part of the code returned, specify in which folder or file the code should be added (for example: `usecase/`, `model/`, `handler/`, etc.)") + ".\n\n" +
+		j.declOpsPrompt() + ".\n\n" +
 		j.t("Strictly use the following format for each part") + ": `**<folder/file.go>** <code ici>`.\n\n" +
 		j.t("Reply without comment or explanation, only the code needed")
 
@@ -317,3 +323,14 @@ func (j *job) promptForQuery() (string, error) {
 	}
 	return query, nil
 }
+
+// followUpsPrompt returns the instructions collected since the last prompt, like the rejected hunks
+// and the reasons of the changes rejected during the review.
+func (j *job) followUpsPrompt() string {
+	if len(j.followUps) == 0 {
+		return ""
+	}
+	prompt := strings.Join(j.followUps, "\n\n")
+	j.followUps = nil
+	return prompt
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// maxReproFrames is the maximum number of frames of the module whose code is sent to the model.
+const maxReproFrames = 5
+
+// runReproCommand implements `goia repro [-trace file] [path]`.
+func runReproCommand(args *appArgs, cmdArgs []string) error {
+	flags := flag.NewFlagSet("repro", flag.ExitOnError)
+	traceFile := flags.String("trace", "", "file containing the stack trace or the error log, read from stdin if empty")
+	if err := flags.Parse(cmdArgs); err != nil {
+		return err
+	}
+
+	path := "."
+	if flags.NArg() > 0 {
+		path = flags.Arg(0)
+	}
+
+	j, err := newCommandJob(args, path)
+	if err != nil {
+		return err
+	}
+	defer j.closeSandbox()
+
+	trace, err := j.readTrace(*traceFile)
+	if err != nil {
+		return err
+	}
+
+	return j.repro(trace)
+}
+
+// readTrace reads the stack trace or the error log from a file or from stdin.
+func (j *job) readTrace(traceFile string) (string, error) {
+	if traceFile != "" {
+		data, err := os.ReadFile(traceFile)
+		return string(data), err
+	}
+
+	if info, err := os.Stdin.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+		fmt.Println(j.t("Paste the stack trace or the error log, then press Ctrl-D"))
+	}
+
+	data, err := io.ReadAll(os.Stdin)
+	return string(data), err
+}
+
+// localFile returns the path, relative to the job folder, of a file of a trace printed on another machine.
+// The file is searched by its path in the module, then by the longest suffix of its path found in the module.
+// A file name alone only matches for the functions of the module, so that the files of the standard library
+// are not confused with the files of the module.
+func (j *job) localFile(file, function string) (string, bool) {
+	file = filepath.ToSlash(file)
+
+	if filepath.IsAbs(file) {
+		if rel, err := filepath.Rel(j.fileDir, file); err == nil && !strings.HasPrefix(rel, "..") {
+			if _, err := os.Stat(file); err == nil {
+				return rel, true
+			}
+		}
+	}
+
+	// Binaries built with -trimpath print the import path of the files.
+	if j.modulePath != "" && strings.HasPrefix(file, j.modulePath+"/") {
+		rel := strings.TrimPrefix(file, j.modulePath+"/")
+		if _, err := os.Stat(filepath.Join(j.fileDir, rel)); err == nil {
+			return filepath.FromSlash(rel), true
+		}
+	}
+
+	parts := strings.Split(strings.TrimPrefix(file, "/"), "/")
+	inModule := len(parts) == 1 || strings.HasPrefix(function, "main.") ||
+		(j.modulePath != "" && strings.HasPrefix(function, j.modulePath))
+	for i := range parts {
+		if i == len(parts)-1 && !inModule {
+			break
+		}
+
+		rel := filepath.Join(parts[i:]...)
+		if _, err := os.Stat(filepath.Join(j.fileDir, rel)); err == nil {
+			return rel, true
+		}
+	}
+
+	return "", false
+}
+
+// traceLocations returns the locations of the module found in a stack trace or in an error log,
+// the deepest frames first.
+func (j *job) traceLocations(trace string) []stackFrame {
+	frames := parseStackTrace(trace)
+	for _, d := range parseDiagnostics(trace) {
+		frames = append(frames, stackFrame{File: d.File, Line: d.Line})
+	}
+
+	seen := make(map[string]bool)
+	var locations []stackFrame
+	for _, frame := range frames {
+		rel, ok := j.localFile(frame.File, frame.Function)
+		if !ok || strings.HasPrefix(rel, "vendor"+string(filepath.Separator)) {
+			continue
+		}
+
+		key := fmt.Sprintf("%s:%d", rel, frame.Line)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		frame.File = rel
+		locations = append(locations, frame)
+	}
+
+	return locations
+}
+
+// testFunctions returns the names of the test functions of a test file.
+func (j *job) testFunctions(testFile string) map[string]bool {
+	names := make(map[string]bool)
+
+	node, err := parser.ParseFile(token.NewFileSet(), filepath.Join(j.fileDir, testFile), nil, 0)
+	if err != nil {
+		return names
+	}
+
+	for _, decl := range node.Decls {
+		if funcDecl, ok := decl.(*ast.FuncDecl); ok && funcDecl.Recv == nil && strings.HasPrefix(funcDecl.Name.Name, "Test") {
+			names[funcDecl.Name.Name] = true
+		}
+	}
+	return names
+}
+
+// locationsCode returns the code of the declarations containing the locations.
+func (j *job) locationsCode(locations []stackFrame) string {
+	var code strings.Builder
+	seen := make(map[string]bool)
+	for _, location := range locations {
+		funcCode, err := j.extractFunctionFromLine(location.File, location.Line)
+		if err != nil || seen[funcCode] {
+			continue
+		}
+		seen[funcCode] = true
+		fmt.Fprintf(&code, "// %s:%d\n%s\n\n", location.File, location.Line, funcCode)
+	}
+	return code.String()
+}
+
+// repro asks the model for a regression test reproducing the failure of the trace, checks that it
+// fails, then fixes the code until it passes.
+func (j *job) repro(trace string) error {
+	locations := j.traceLocations(trace)
+
+	var sourceLocations []stackFrame
+	for _, location := range locations {
+		if !j.isTestFile(location.File) {
+			sourceLocations = append(sourceLocations, location)
+		}
+	}
+	if len(sourceLocations) == 0 {
+		return errors.New(j.t("no location of the module found in the trace"))
+	}
+	if len(sourceLocations) > maxReproFrames {
+		sourceLocations = sourceLocations[:maxReproFrames]
+	}
+
+	code := j.locationsCode(sourceLocations)
+
+	j.currentSourceFileName = sourceLocations[0].File
+	j.currentTestFileName = strings.TrimSuffix(sourceLocations[0].File, ".go") + "_test.go"
+	j.fileName = j.currentSourceFileName
+	if err := j.loadCurrentFiles(); err != nil {
+		return err
+	}
+
+	pkgDir := "./" + filepath.ToSlash(filepath.Dir(j.currentSourceFileName))
+	existingTests := j.testFunctions(j.currentTestFileName)
+
+	// The source files of the trace must not change while the regression test is written.
+	j.frozenFiles = make(map[string]bool)
+	for _, location := range sourceLocations {
+		j.frozenFiles[location.File] = true
+	}
+
+	var regressionTests []string
+	reproPrompt := j.t("The following failure occurred") + ":\n\n" + trace + "\n\n" +
+		j.t("Here is the code of the module found in the trace") + " :\n\n" + code +
+		fmt.Sprintf(j.t("Write a regression test in the test file %s that reproduces this failure and fails with the current code, without fixing the code"), j.currentTestFileName) + ".\n\n" +
+		j.t("Strictly use the following format for each part") + ": `**<folder/file.go>** <code ici>`.\n\n" +
+		j.t("Reply without comment or explanation")
+
+	err := j.repairLoop(reproPrompt, func() (string, error) {
+		if prompt, err := j.compileTestsPrompt(); err != nil || prompt != "" {
+			return prompt, err
+		}
+
+		regressionTests = nil
+		for name := range j.testFunctions(j.currentTestFileName) {
+			if !existingTests[name] {
+				regressionTests = append(regressionTests, name)
+			}
+		}
+		if len(regressionTests) == 0 {
+			return reproPrompt, nil
+		}
+
+		result, err := j.runCommand(nil, nil, "go", "test", "-count=1", "-run", failedTestsRunPattern(regressionTests), pkgDir)
+		if err != nil && result == nil {
+			return "", err
+		}
+		if err == nil {
+			return fmt.Sprintf(j.t("The test %s passes with the current code, so it does not reproduce the failure"), strings.Join(regressionTests, ", ")) + ".\n\n" +
+				reproPrompt, nil
+		}
+
+		log.Infof(j.t("The regression test reproduces the failure")+":\n\n%s", result.Output())
+		return "", nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// The regression test is now frozen and the code is fixed until it passes.
+	j.frozenFiles = map[string]bool{j.currentTestFileName: true}
+
+	check := func() (string, error) {
+		if prompt, err := j.compileTestsPrompt(); err != nil || prompt != "" {
+			return prompt, err
+		}
+
+		result, err := j.runCommand(nil, nil, "go", "test", "-count=1", pkgDir)
+		if err == nil {
+			log.Info(j.t("The tests pass"))
+			return "", nil
+		}
+		if result == nil {
+			return "", err
+		}
+
+		testCode, err := j.getTestCode(regressionTests)
+		if err != nil {
+			return "", err
+		}
+
+		// The frames of the test output point to the current code.
+		currentCode := code
+		if locations := j.traceLocations(result.Output()); len(locations) > 0 {
+			currentCode = j.locationsCode(locations)
+		}
+
+		return j.t("Fix the code so that the following regression test passes, without modifying the test") + ":\n\n" +
+			testCode + "\n\n" +
+			j.t("Here is the code of the module found in the trace") + " :\n\n" + currentCode +
+			j.t("Error") + " : " + result.Output() + "\n\n" +
+			j.t("Strictly use the following format for each part") + ": `**<folder/file.go>** <code ici>`.\n\n" +
+			j.t("Reply without comment or explanation"), nil
+	}
+
+	prompt, err := check()
+	if err != nil || prompt == "" {
+		return err
+	}
+
+	return j.repairLoop(prompt, check)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLocalFile(t *testing.T) {
+	dir := writeModule(t, map[string]string{
+		"go.mod":              "module example.com/m\n\ngo 1.22\n",
+		"main.go":             "package main\n",
+		"server/server.go":    "package server\n",
+		"internal/strings.go": "package internal\n",
+	})
+	j := &job{fileDir: dir, modulePath: "example.com/m"}
+
+	tests := []struct {
+		name     string
+		file     string
+		function string
+		want     string
+		wantOk   bool
+	}{
+		{"absolute path in the module", filepath.Join(dir, "server", "server.go"), "example.com/m/server.Run", filepath.Join("server", "server.go"), true},
+		{"trimmed path", "example.com/m/server/server.go", "example.com/m/server.Run", filepath.Join("server", "server.go"), true},
+		{"path of another machine", "/home/ci/build/server/server.go", "example.com/m/server.Run", filepath.Join("server", "server.go"), true},
+		{"file name of the module", "/home/ci/build/main.go", "main.main", "main.go", true},
+		{"file name of the standard library", "/usr/local/go/src/strings.go", "strings.Index", "", false},
+		{"unknown file", "/home/ci/build/client/client.go", "example.com/m/client.Run", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := j.localFile(tt.file, tt.function)
+			if got != tt.want || ok != tt.wantOk {
+				t.Errorf("localFile() = %q, %v, want %q, %v", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestTraceLocations(t *testing.T) {
+	dir := writeModule(t, map[string]string{
+		"go.mod":            "module example.com/m\n\ngo 1.22\n",
+		"main.go":           "package main\n",
+		"vendor/v/v.go":     "package v\n",
+		"server/handler.go": "package server\n",
+	})
+	j := &job{fileDir: dir, modulePath: "example.com/m"}
+
+	trace := "panic: boom\n\n" +
+		"goroutine 1 [running]:\n" +
+		"example.com/m/server.handle(...)\n" +
+		"\t/build/server/handler.go:12 +0x1d\n" +
+		"example.com/m/vendor/v.F()\n" +
+		"\t/build/vendor/v/v.go:3 +0x1d\n" +
+		"runtime.gopanic({0x1, 0x2})\n" +
+		"\t/usr/local/go/src/runtime/panic.go:770 +0x132\n" +
+		"main.main()\n" +
+		"\t/build/main.go:8 +0x25\n" +
+		"main.main()\n" +
+		"\t/build/main.go:8 +0x25\n" +
+		"server/handler.go:20:3: undefined: x\n"
+
+	want := []stackFrame{
+		{Function: "example.com/m/server.handle", File: filepath.Join("server", "handler.go"), Line: 12},
+		{Function: "main.main", File: "main.go", Line: 8},
+		{File: filepath.Join("server", "handler.go"), Line: 20},
+	}
+	if got := j.traceLocations(trace); !reflect.DeepEqual(got, want) {
+		t.Errorf("traceLocations() = %#v, want %#v", got, want)
+	}
+}
+
+func TestTestFunctions(t *testing.T) {
+	dir := writeModule(t, map[string]string{
+		"a_test.go": "package a\n\nfunc TestA(t *testing.T) {}\nfunc TestB(t *testing.T) {}\nfunc helper() {}\nfunc (s suite) TestC() {}\n",
+	})
+	j := &job{fileDir: dir}
+
+	want := map[string]bool{"TestA": true, "TestB": true}
+	if got := j.testFunctions("a_test.go"); !reflect.DeepEqual(got, want) {
+		t.Errorf("testFunctions() = %v, want %v", got, want)
+	}
+	if got := j.testFunctions("missing_test.go"); len(got) != 0 {
+		t.Errorf("testFunctions() of a missing file = %v", got)
+	}
+}
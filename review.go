@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/manifoldco/promptui"
+	log "github.com/sirupsen/logrus"
+)
+
+// reviewChoice is the decision of the user on a change during the review.
+type reviewChoice int
+
+const (
+	reviewAccept reviewChoice = iota
+	reviewReject
+	reviewRejectWithReason
+	reviewEdit
+	reviewAcceptAll
+)
+
+// reviewChange is a declaration changed by the model. When the declaration is missing from a version,
+// its range in that version is the place where it would be inserted.
+type reviewChange struct {
+	key          string
+	old, new     declUnit
+	inOld, inNew bool
+}
+
+// reviewChanges returns the declarations, the header and the imports changed between two versions of a file.
+func reviewChanges(fileName string, src, res []byte) ([]reviewChange, error) {
+	oldSide, err := parseMergeSide(fileName, src)
+	if err != nil {
+		return nil, err
+	}
+	newSide, err := parseMergeSide(fileName, res)
+	if err != nil {
+		return nil, err
+	}
+
+	type versions struct {
+		old, new     declUnit
+		inOld, inNew bool
+	}
+	byKey := map[string]*versions{
+		"package": {
+			old: declUnit{key: "package", end: len(oldSide.header), text: oldSide.header}, inOld: true,
+			new: declUnit{key: "package", end: len(newSide.header), text: newSide.header}, inNew: true,
+		},
+		"import": {
+			old: oldSide.importBlock, inOld: oldSide.importBlock.key != "",
+			new: newSide.importBlock, inNew: newSide.importBlock.key != "",
+		},
+	}
+	if !byKey["import"].inNew {
+		byKey["import"].new = declUnit{start: len(newSide.header), end: len(newSide.header)}
+	}
+
+	keys := []string{"package", "import"}
+	for _, key := range newSide.keys {
+		byKey[key] = &versions{new: newSide.units[key], inNew: true}
+		keys = append(keys, key)
+	}
+	for _, key := range oldSide.keys {
+		v, ok := byKey[key]
+		if !ok {
+			// A deleted declaration is restored at the end of the file.
+			v = &versions{new: declUnit{start: len(res), end: len(res)}}
+			byKey[key] = v
+			keys = append(keys, key)
+		}
+		v.old, v.inOld = oldSide.units[key], true
+	}
+
+	var changes []reviewChange
+	for _, key := range keys {
+		v := byKey[key]
+		if v.inOld == v.inNew && (!v.inOld || sameCode(v.old.text, v.new.text)) {
+			continue
+		}
+		changes = append(changes, reviewChange{key: key, old: v.old, new: v.new, inOld: v.inOld, inNew: v.inNew})
+	}
+	return changes, nil
+}
+
+// reviewFile shows the changes of the model on a file declaration by declaration, and returns the content
+// with the changes accepted or edited by the user. The reasons of the rejections are sent to the model
+// with the next prompt.
+func (j *job) reviewFile(fileName string, src, res []byte) []byte {
+	changes, err := reviewChanges(fileName, src, res)
+	if err != nil {
+		// A version that does not parse is reviewed as a whole.
+		changes = []reviewChange{{
+			key: fileName,
+			old: declUnit{end: len(src), text: string(src)}, inOld: len(src) > 0,
+			new: declUnit{end: len(res), text: string(res)}, inNew: true,
+		}}
+	}
+
+	var edits []textEdit
+	replace := func(change reviewChange, text string) {
+		switch {
+		case change.inNew || text == "":
+		case change.key == "import":
+			text += "\n\n"
+		default:
+			text = "\n\n" + text + "\n"
+		}
+		edits = append(edits, textEdit{start: change.new.start, end: change.new.end, text: text})
+	}
+
+	for i, change := range changes {
+		j.printReviewChange(fileName, change, i+1, len(changes))
+
+		choice, err := j.askReviewChoice()
+		if err != nil {
+			log.WithError(err).Warn(j.t("The changes are accepted"))
+			break
+		}
+
+		switch choice {
+		case reviewReject:
+			replace(change, change.old.text)
+
+		case reviewRejectWithReason:
+			replace(change, change.old.text)
+			reason, err := (&promptui.Prompt{Label: j.t("Reason of the rejection")}).Run()
+			if err == nil && strings.TrimSpace(reason) != "" {
+				j.followUps = append(j.followUps,
+					fmt.Sprintf(j.t("The change of %s in %s was rejected during the review"), change.key, fileName)+": "+reason+".")
+			}
+
+		case reviewEdit:
+			text := change.new.text
+			if !change.inNew {
+				text = change.old.text
+			}
+			text, err := j.editInEditor(text)
+			if err != nil {
+				log.WithError(err).Warn(j.t("The change is accepted without modification"))
+				continue
+			}
+			replace(change, text)
+		}
+
+		if choice == reviewAcceptAll {
+			break
+		}
+	}
+
+	if len(edits) == 0 {
+		return res
+	}
+
+	reviewed := applyTextEdits(res, edits)
+	if formatted, err := format.Source(reviewed); err == nil {
+		return formatted
+	}
+	return reviewed
+}
+
+// printReviewChange displays the diff of a declaration with its unchanged lines around.
+func (j *job) printReviewChange(fileName string, change reviewChange, index, total int) {
+	fmt.Printf("\n%s\n", blue(fmt.Sprintf("%s (%d/%d): %s", fileName, index, total, change.key)))
+
+	var oldText, newText string
+	if change.inOld {
+		oldText = strings.TrimRight(change.old.text, "\n") + "\n"
+	}
+	if change.inNew {
+		newText = strings.TrimRight(change.new.text, "\n") + "\n"
+	}
+
+	if j.args.sideBySide {
+		fmt.Print(sideBySideDiff(oldText, newText, terminalWidth()))
+	} else {
+		var b strings.Builder
+		for _, hunk := range diffHunks(lineDiff(oldText, newText), diffContext) {
+			writeColorEdits(&b, hunk.edits)
+		}
+		fmt.Print(b.String())
+	}
+	fmt.Println()
+}
+
+// askReviewChoice asks the user what to do with a change.
+func (j *job) askReviewChoice() (reviewChoice, error) {
+	prompt := promptui.Select{
+		Label: j.t("Apply this change ?"),
+		Items: []string{
+			j.t("Accept"),
+			j.t("Reject"),
+			j.t("Reject with a reason for the model"),
+			j.t("Edit"),
+			j.t("Accept all the remaining changes"),
+		},
+	}
+
+	index, _, err := prompt.Run()
+	return reviewChoice(index), err
+}
+
+// editInEditor opens a text in the editor of the user and returns the edited text.
+func (j *job) editInEditor(text string) (string, error) {
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+
+	f, err := os.CreateTemp("", "goia-*.go")
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = os.Remove(f.Name())
+	}()
+
+	_, err = f.WriteString(text)
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	if err != nil {
+		return "", err
+	}
+
+	args := append(strings.Fields(editor), f.Name())
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf(j.t("error running the editor")+": %v", err)
+	}
+
+	data, err := os.ReadFile(f.Name())
+	if err != nil {
+		return "", err
+	}
+	return string(bytes.TrimRight(data, "\n")), nil
+}
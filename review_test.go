@@ -0,0 +1,75 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestReviewChanges(t *testing.T) {
+	const src = "package m\n\nimport \"fmt\"\n\nfunc A() { fmt.Println(1) }\n\nfunc B() {}\n"
+
+	type change struct {
+		key          string
+		inOld, inNew bool
+	}
+
+	tests := []struct {
+		name    string
+		res     string
+		want    []change
+		wantErr bool
+	}{
+		{
+			name: "no change",
+			res:  src,
+			want: nil,
+		},
+		{
+			name: "formatting only",
+			res:  "package m\n\nimport \"fmt\"\n\nfunc A() {\n\tfmt.Println(1)\n}\n\nfunc B() {}\n",
+			want: nil,
+		},
+		{
+			name: "changed declaration",
+			res:  "package m\n\nimport \"fmt\"\n\nfunc A() { fmt.Println(2) }\n\nfunc B() {}\n",
+			want: []change{{"func A", true, true}},
+		},
+		{
+			name: "added and deleted declarations",
+			res:  "package m\n\nimport \"fmt\"\n\nfunc A() { fmt.Println(1) }\n\nfunc C() {}\n",
+			want: []change{{"func C", false, true}, {"func B", true, false}},
+		},
+		{
+			name: "changed imports",
+			res:  "package m\n\nimport (\n\t\"fmt\"\n\t\"os\"\n)\n\nfunc A() { fmt.Println(1) }\n\nfunc B() { os.Exit(0) }\n",
+			want: []change{{"import", true, true}, {"func B", true, true}},
+		},
+		{
+			name: "removed imports",
+			res:  "package m\n\nfunc A() {}\n\nfunc B() {}\n",
+			want: []change{{"import", true, false}, {"func A", true, true}},
+		},
+		{
+			name:    "invalid code",
+			res:     "package m\n\nfunc A( {\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changes, err := reviewChanges("m.go", []byte(src), []byte(tt.res))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("reviewChanges() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			var got []change
+			for _, c := range changes {
+				got = append(got, change{c.key, c.inOld, c.inNew})
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("reviewChanges() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// defaultRunTimeout is the maximum execution time of a generated program when none is configured.
+const defaultRunTimeout = 10 * time.Second
+
+// RunConfig configures the execution of the generated main programs.
+type RunConfig struct {
+	// Enabled executes the generated main programs after a successful build.
+	Enabled bool `yaml:"enabled"`
+	// Args are the command line arguments given to the program.
+	Args []string `yaml:"args"`
+	// Stdin is written on the standard input of the program.
+	Stdin string `yaml:"stdin"`
+	// Env is a list of `KEY=value` added to the environment of the program.
+	Env []string `yaml:"env"`
+	// Timeout is the maximum execution time of the program.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// Merge merges the given RunConfig with this one.
+func (cfg *RunConfig) Merge(newCfg RunConfig) {
+	if newCfg.Enabled {
+		cfg.Enabled = newCfg.Enabled
+	}
+	if len(newCfg.Args) > 0 {
+		cfg.Args = newCfg.Args
+	}
+	if newCfg.Stdin != "" {
+		cfg.Stdin = newCfg.Stdin
+	}
+	if len(newCfg.Env) > 0 {
+		cfg.Env = append(cfg.Env, newCfg.Env...)
+	}
+	if newCfg.Timeout != 0 {
+		cfg.Timeout = newCfg.Timeout
+	}
+}
+
+// runResult is the result of the execution of a program.
+type runResult struct {
+	ExitCode int
+	Stdout   string
+	Stderr   string
+	TimedOut bool
+	Frames   []stackFrame
+}
+
+// stackFrame is a frame of a goroutine stack trace.
+type stackFrame struct {
+	Function string
+	File     string
+	Line     int
+}
+
+var (
+	regStackFrameFile = regexp.MustCompile(`^\s+(.+\.go):(\d+)(?: \+0x[0-9a-f]+)?$`)
+	regStackFrameArgs = regexp.MustCompile(`\([^()]*\)$`)
+)
+
+// parseStackTrace extracts the frames of the goroutine traces printed by a panic or a SIGQUIT.
+func parseStackTrace(output string) []stackFrame {
+	var frames []stackFrame
+	var previousLine string
+
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if matches := regStackFrameFile.FindStringSubmatch(line); matches != nil && previousLine != "" {
+			lineNumber, err := strconv.Atoi(matches[2])
+			function := strings.TrimPrefix(strings.TrimSpace(previousLine), "created by ")
+			// The output of the program may put a blank line or only arguments before a file line.
+			fields := strings.Fields(regStackFrameArgs.ReplaceAllString(function, ""))
+			if err == nil && len(fields) > 0 {
+				frames = append(frames, stackFrame{
+					Function: fields[0],
+					File:     matches[1],
+					Line:     lineNumber,
+				})
+			}
+			previousLine = ""
+			continue
+		}
+
+		previousLine = line
+	}
+
+	return frames
+}
+
+// moduleFrames returns the frames whose file belongs to the job folder.
+func (j *job) moduleFrames(frames []stackFrame) []stackFrame {
+	root, err := filepath.Abs(j.fileDir)
+	if err != nil {
+		return nil
+	}
+
+	var result []stackFrame
+	for _, frame := range frames {
+		if strings.HasPrefix(frame.File, root+string(filepath.Separator)) {
+			result = append(result, frame)
+		}
+	}
+	return result
+}
+
+// isMainPackageFile checks whether a file of the job folder declares the main package.
+func (j *job) isMainPackageFile(fileName string) bool {
+	fs := token.NewFileSet()
+	node, err := parser.ParseFile(fs, filepath.Join(j.fileDir, fileName), nil, parser.PackageClauseOnly)
+	if err != nil {
+		return false
+	}
+	return node.Name.Name == "main"
+}
+
+// runMainProgram builds the main package of the current source file and runs it with the configured
+// arguments, standard input and environment.
+func (j *job) runMainProgram() (*runResult, error) {
+	outDir, err := os.MkdirTemp("", "goia-run-")
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		_ = os.RemoveAll(outDir)
+	}()
+
+	binary := filepath.Join(outDir, "program")
+	pkgDir := "./" + filepath.ToSlash(filepath.Dir(strings.TrimPrefix(j.currentSourceFileName, "/")))
+
+	build := exec.Command("go", "build", "-o", binary, pkgDir)
+	build.Dir = j.fileDir
+	if output, err := build.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf(j.t("error building program")+": %v - %s", err, output)
+	}
+
+	if j.sandboxConfig.Enabled {
+		return j.runMainProgramInSandbox(binary)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), j.runTimeout())
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, binary, j.runConfig.Args...)
+	cmd.Dir = j.fileDir
+	cmd.Env = append(os.Environ(), j.runConfig.Env...)
+	cmd.Stdin = strings.NewReader(j.runConfig.Stdin)
+	// SIGQUIT makes the Go runtime print the stack of every goroutine before exiting.
+	cmd.Cancel = func() error {
+		return cmd.Process.Signal(syscall.SIGQUIT)
+	}
+	cmd.WaitDelay = time.Second
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err = cmd.Run()
+
+	result := &runResult{
+		Stdout:   stdout.String(),
+		Stderr:   stderr.String(),
+		TimedOut: errors.Is(ctx.Err(), context.DeadlineExceeded),
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		result.ExitCode = exitErr.ExitCode()
+	} else if err != nil && !result.TimedOut {
+		return nil, err
+	}
+
+	result.Frames = parseStackTrace(result.Stderr)
+	return result, nil
+}
+
+// runMainProgramInSandbox runs the built program in an isolated working copy.
+func (j *job) runMainProgramInSandbox(binary string) (*runResult, error) {
+	s, err := j.jobSandbox()
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := s.run(j.runTimeout(), ".", strings.NewReader(j.runConfig.Stdin), j.runConfig.Env, binary, j.runConfig.Args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &runResult{
+		ExitCode: res.ExitCode,
+		Stdout:   res.Stdout,
+		Stderr:   res.Stderr,
+		TimedOut: res.TimedOut,
+		Frames:   parseStackTrace(res.Stderr),
+	}, nil
+}
+
+// runTimeout returns the maximum execution time of a generated program.
+func (j *job) runTimeout() time.Duration {
+	if j.runConfig.Timeout == 0 {
+		return defaultRunTimeout
+	}
+	return j.runConfig.Timeout
+}
+
+// failed checks whether the execution of the program failed.
+func (r *runResult) failed() bool {
+	return r.TimedOut || r.ExitCode != 0
+}
+
+// runGateOutput returns a summary of the execution to display and to send to the model.
+func (j *job) runGateOutput(result *runResult) string {
+	var builder strings.Builder
+	if result.TimedOut {
+		builder.WriteString(fmt.Sprintf(j.t("The program did not finish within %s")+"\n", j.runTimeout()))
+	}
+	builder.WriteString(fmt.Sprintf(j.t("Exit code")+": %d\n", result.ExitCode))
+	if result.Stdout != "" {
+		builder.WriteString("stdout:\n" + result.Stdout + "\n")
+	}
+	if result.Stderr != "" {
+		builder.WriteString("stderr:\n" + result.Stderr + "\n")
+	}
+	return builder.String()
+}
+
+// runGate executes the generated main program and returns a repair prompt if it failed.
+func (j *job) runGate() (prompt, output string, err error) {
+	log.Info(j.t("Running the generated program"))
+
+	result, err := j.runMainProgram()
+	if err != nil {
+		return "", "", err
+	}
+
+	output = j.runGateOutput(result)
+	if !result.failed() {
+		return "", output, nil
+	}
+
+	var funcCode strings.Builder
+	for _, frame := range j.moduleFrames(result.Frames) {
+		name, code, err := j.extractDeclarationFromLine(frame.File, frame.Line)
+		if err != nil {
+			continue
+		}
+		funcCode.WriteString(fmt.Sprintf("// %s (%s:%d)\n%s\n\n", name, frame.File, frame.Line, code))
+		// The first frame of the project is the faulting function.
+		break
+	}
+
+	prompt = j.t("Fix the following code that failed at runtime") + ":\n\n" + funcCode.String() + "\n\n" +
+		j.t("Error") + " : " + output + "\n\n" +
+		j.t("responds without adding comments or explanations") + "\n\n" +
+		j.t("Generates a concise response that specifies the file to modify in the form: \"MODIFY: <function or section name> (source file, not test file)\"") + "." +
+		j.t("Then provide the corrected code in the form: \"CODE: <corrected code>\"") + "."
+
+	return prompt, output, nil
+}
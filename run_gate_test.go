@@ -0,0 +1,95 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseStackTrace(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   []stackFrame
+	}{
+		{
+			name:   "no trace",
+			output: "hello\nworld\n",
+			want:   nil,
+		},
+		{
+			name: "panic",
+			output: "panic: runtime error: index out of range [3] with length 2\n\n" +
+				"goroutine 1 [running]:\n" +
+				"main.get(...)\n" +
+				"\t/src/app/main.go:8\n" +
+				"main.(*server).run(0xc000012345, {0x4b2f60, 0x3})\n" +
+				"\t/src/app/server.go:21 +0x1d\n" +
+				"created by main.main in goroutine 1\n" +
+				"\t/src/app/main.go:30 +0x25\n" +
+				"exit status 2\n",
+			want: []stackFrame{
+				{Function: "main.get", File: "/src/app/main.go", Line: 8},
+				{Function: "main.(*server).run", File: "/src/app/server.go", Line: 21},
+				{Function: "main.main", File: "/src/app/main.go", Line: 30},
+			},
+		},
+		{
+			name:   "file line after a blank line",
+			output: "\n\t/src/app/main.go:8\n",
+			want:   nil,
+		},
+		{
+			name:   "file line after a line of spaces",
+			output: "   \n\t/src/app/main.go:8\n",
+			want:   nil,
+		},
+		{
+			name:   "file line after arguments only",
+			output: "(0x1, 0x2)\n\t/src/app/main.go:8 +0x1d\n",
+			want:   nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseStackTrace(tt.output)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseStackTrace() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestModuleFrames(t *testing.T) {
+	j := &job{fileDir: "/src/app"}
+	frames := []stackFrame{
+		{Function: "main.main", File: "/src/app/main.go", Line: 3},
+		{Function: "runtime.main", File: "/usr/local/go/src/runtime/proc.go", Line: 271},
+		{Function: "other.F", File: "/src/application/f.go", Line: 1},
+	}
+
+	want := []stackFrame{frames[0]}
+	if got := j.moduleFrames(frames); !reflect.DeepEqual(got, want) {
+		t.Errorf("moduleFrames() = %#v, want %#v", got, want)
+	}
+}
+
+func TestRunResultFailed(t *testing.T) {
+	tests := []struct {
+		name   string
+		result runResult
+		want   bool
+	}{
+		{"success", runResult{}, false},
+		{"exit code", runResult{ExitCode: 2}, true},
+		{"timeout", runResult{TimedOut: true}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.result.failed(); got != tt.want {
+				t.Errorf("failed() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,601 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// sandboxExecEnv is set when goia re-executes itself to apply the resource limits
+	// before running a command of the sandbox.
+	sandboxExecEnv = "GOIA_SANDBOX_EXEC"
+	// sandboxCPUTimeEnv, sandboxMemoryEnv and sandboxProcessesEnv give the resource limits to apply.
+	sandboxCPUTimeEnv   = "GOIA_SANDBOX_CPU_TIME"
+	sandboxMemoryEnv    = "GOIA_SANDBOX_MEMORY"
+	sandboxProcessesEnv = "GOIA_SANDBOX_PROCESSES"
+	// sandboxReadOnlyEnv lists the folders made read-only in the mount namespace of the sandbox.
+	sandboxReadOnlyEnv = "GOIA_SANDBOX_READONLY"
+	// sandboxHiddenEnv lists the folders hidden by an empty tmpfs in the mount namespace of the sandbox,
+	// and sandboxWritableEnv the folders under them mounted back writable.
+	sandboxHiddenEnv   = "GOIA_SANDBOX_HIDDEN"
+	sandboxWritableEnv = "GOIA_SANDBOX_WRITABLE"
+	// sandboxDeferLimitsEnv leaves the resource limits to the programs run by the go command with -exec.
+	sandboxDeferLimitsEnv = "GOIA_SANDBOX_DEFER_LIMITS"
+
+	// sandboxSetupExitCode and sandboxSetupError are the exit code and the start of the error output
+	// of goia when the sandbox of a command cannot be set up.
+	sandboxSetupExitCode = 125
+	sandboxSetupError    = "goia sandbox: "
+
+	defaultSandboxTimeout = 2 * time.Minute
+)
+
+// SandboxConfig configures the isolated execution of the generated tests and programs.
+type SandboxConfig struct {
+	// Enabled runs the generated tests and programs in an isolated working copy.
+	Enabled bool `yaml:"enabled"`
+	// CPUTime is the maximum CPU time of each process (RLIMIT_CPU).
+	CPUTime time.Duration `yaml:"cpu_time"`
+	// MemoryMB is the maximum virtual memory of each process in megabytes (RLIMIT_AS).
+	MemoryMB uint64 `yaml:"memory_mb"`
+	// MaxProcesses is the maximum number of processes of the user (RLIMIT_NPROC).
+	MaxProcesses uint64 `yaml:"max_processes"`
+	// Timeout is the wall clock time after which the whole process group is killed.
+	Timeout time.Duration `yaml:"timeout"`
+	// Network keeps the network access of the sandboxed commands.
+	Network bool `yaml:"network"`
+	// AllowUnisolated runs the commands with the network access and a writable module cache when the
+	// namespaces cannot be created, instead of failing.
+	AllowUnisolated bool `yaml:"allow_unisolated"`
+}
+
+// Merge merges the given SandboxConfig with this one.
+func (cfg *SandboxConfig) Merge(newCfg SandboxConfig) {
+	if newCfg.Enabled {
+		cfg.Enabled = newCfg.Enabled
+	}
+	if newCfg.CPUTime != 0 {
+		cfg.CPUTime = newCfg.CPUTime
+	}
+	if newCfg.MemoryMB != 0 {
+		cfg.MemoryMB = newCfg.MemoryMB
+	}
+	if newCfg.MaxProcesses != 0 {
+		cfg.MaxProcesses = newCfg.MaxProcesses
+	}
+	if newCfg.Timeout != 0 {
+		cfg.Timeout = newCfg.Timeout
+	}
+	if newCfg.Network {
+		cfg.Network = newCfg.Network
+	}
+	if newCfg.AllowUnisolated {
+		cfg.AllowUnisolated = newCfg.AllowUnisolated
+	}
+}
+
+// sandbox is an isolated working copy of the job folder with a scratch HOME and TMPDIR.
+// Without network access, the commands run in their own namespaces where the home of the user
+// is hidden, and the module cache, the toolchain and the job folder are read-only.
+type sandbox struct {
+	cfg      SandboxConfig
+	src      string
+	dir      string
+	root     string
+	home     string
+	tmp      string
+	goEnv    []string
+	readOnly []string
+	hidden   []string
+	writable []string
+	isolated bool
+	timeout  time.Duration
+}
+
+// commandResult is the result of a command run by runCommand.
+type commandResult struct {
+	Stdout   string
+	Stderr   string
+	ExitCode int
+	TimedOut bool
+}
+
+// Output returns the standard and error outputs of the command.
+func (r *commandResult) Output() string {
+	return r.Stdout + r.Stderr
+}
+
+// newSandbox copies the job folder in a temporary folder.
+func (j *job) newSandbox() (*sandbox, error) {
+	src, err := filepath.Abs(j.fileDir)
+	if err != nil {
+		return nil, err
+	}
+
+	root, err := os.MkdirTemp("", "goia-sandbox-")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &sandbox{
+		cfg:      j.sandboxConfig,
+		src:      src,
+		root:     root,
+		dir:      filepath.Join(root, "src"),
+		home:     filepath.Join(root, "home"),
+		tmp:      filepath.Join(root, "tmp"),
+		isolated: !j.sandboxConfig.Network,
+		timeout:  j.sandboxConfig.Timeout,
+	}
+	if s.timeout == 0 {
+		s.timeout = defaultSandboxTimeout
+	}
+
+	// The build cache of the sandboxes is kept apart from the one of the host, so that the code
+	// under test cannot alter the builds of the host. It is kept between the jobs to build faster.
+	goCache := filepath.Join(root, "gocache")
+	if userCache, err := os.UserCacheDir(); err == nil {
+		goCache = filepath.Join(userCache, "goia", "sandbox-gocache")
+	}
+
+	for _, dir := range []string{s.home, s.tmp, goCache} {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			_ = s.Close()
+			return nil, err
+		}
+	}
+
+	if err := syncTree(src, s.dir); err != nil {
+		_ = s.Close()
+		return nil, fmt.Errorf(j.t("error copying the working copy")+": %v", err)
+	}
+
+	out, err := exec.Command("go", "env", "GOMODCACHE", "GOROOT").Output()
+	if err != nil {
+		_ = s.Close()
+		return nil, fmt.Errorf(j.t("error running go env")+": %v", err)
+	}
+	modCache, goRoot, _ := strings.Cut(strings.TrimSpace(string(out)), "\n")
+
+	// The module cache of the host is used without network, read-only, as nothing can be downloaded.
+	s.goEnv = []string{"GOCACHE=" + goCache, "GOMODCACHE=" + modCache, "GOPATH=" + filepath.Join(root, "gopath")}
+	s.readOnly = []string{modCache, src}
+	s.writable = []string{root, goCache}
+
+	// The toolchain and goia are read-only too, unless they hold the folders written by the sandbox.
+	tools := []string{strings.TrimSpace(goRoot)}
+	if self, err := os.Executable(); err == nil {
+		tools = append(tools, filepath.Dir(self))
+	}
+	for _, dir := range tools {
+		if under, ok := foldersUnder(dir, s.writable); ok && len(under) == 0 {
+			s.readOnly = append(s.readOnly, dir)
+		}
+	}
+
+	// The home of the user, with the API key in ~/.goia, is hidden from the code under test. The folders
+	// used by the sandbox under it are mounted back.
+	if home, err := os.UserHomeDir(); err == nil {
+		s.hidden = []string{home}
+	}
+
+	return s, nil
+}
+
+// jobSandbox returns the working copy of the job, created on the first use and synchronized with the
+// job folder before each command.
+func (j *job) jobSandbox() (*sandbox, error) {
+	src, err := filepath.Abs(j.fileDir)
+	if err != nil {
+		return nil, err
+	}
+
+	if j.sandbox != nil && j.sandbox.src != src {
+		j.closeSandbox()
+	}
+	if j.sandbox == nil {
+		s, err := j.newSandbox()
+		if err != nil {
+			return nil, err
+		}
+		j.sandbox = s
+		return s, nil
+	}
+
+	if err := syncTree(src, j.sandbox.dir); err != nil {
+		return nil, fmt.Errorf(j.t("error copying the working copy")+": %v", err)
+	}
+	return j.sandbox, nil
+}
+
+// copyFromSandbox copies the files written by the commands under the folder rel of the working copy
+// back into the job folder, e.g. the failing inputs found by the fuzzing. Nothing is removed.
+func (j *job) copyFromSandbox(rel string) error {
+	if j.sandbox == nil {
+		return nil
+	}
+
+	root := filepath.Join(j.sandbox.dir, rel)
+	if _, err := os.Stat(root); os.IsNotExist(err) {
+		return nil
+	}
+
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+
+		relPath, err := filepath.Rel(j.sandbox.dir, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(j.sandbox.src, relPath)
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if current, err := os.Stat(target); err == nil &&
+			current.Size() == info.Size() && current.ModTime().Equal(info.ModTime()) {
+			return nil
+		}
+
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			return err
+		}
+		if err := copyFile(path, target, info.Mode().Perm()); err != nil {
+			return err
+		}
+		// The same modification time keeps the next synchronization from copying the file again.
+		return os.Chtimes(target, info.ModTime(), info.ModTime())
+	})
+}
+
+// closeSandbox removes the working copy of the job.
+func (j *job) closeSandbox() {
+	if j.sandbox == nil {
+		return
+	}
+	if err := j.sandbox.Close(); err != nil {
+		log.WithError(err).Error(j.t("Error removing the sandbox"))
+	}
+	j.sandbox = nil
+}
+
+// Close removes the working copy.
+func (s *sandbox) Close() error {
+	return os.RemoveAll(s.root)
+}
+
+// restorePaths replaces the paths of the working copy by the ones of the job folder.
+func (s *sandbox) restorePaths(output string) string {
+	return strings.ReplaceAll(output, s.dir, s.src)
+}
+
+// env returns the environment of the sandboxed commands.
+func (s *sandbox) env(extra []string) []string {
+	var env []string
+	for _, kv := range os.Environ() {
+		name, _, _ := strings.Cut(kv, "=")
+		switch name {
+		case "HOME", "TMPDIR", "GOCACHE", "GOMODCACHE", "GOPATH", "GOTMPDIR":
+			continue
+		}
+		env = append(env, kv)
+	}
+
+	env = append(env, "HOME="+s.home, "TMPDIR="+s.tmp, "GOTMPDIR="+s.tmp, "GOTOOLCHAIN=local")
+	env = append(env, s.goEnv...)
+	if !s.cfg.Network {
+		env = append(env, "GOPROXY=off")
+	}
+	if s.isolated {
+		env = append(env,
+			sandboxReadOnlyEnv+"="+strings.Join(s.readOnly, string(filepath.ListSeparator)),
+			sandboxHiddenEnv+"="+strings.Join(s.hidden, string(filepath.ListSeparator)),
+			sandboxWritableEnv+"="+strings.Join(s.writable, string(filepath.ListSeparator)),
+		)
+	}
+
+	env = append(env,
+		sandboxExecEnv+"=1",
+		sandboxCPUTimeEnv+"="+strconv.FormatInt(int64(s.cfg.CPUTime/time.Second), 10),
+		sandboxMemoryEnv+"="+strconv.FormatUint(s.cfg.MemoryMB, 10),
+		sandboxProcessesEnv+"="+strconv.FormatUint(s.cfg.MaxProcesses, 10),
+	)
+
+	return append(env, extra...)
+}
+
+// run executes a command in the working copy. goia re-executes itself to set up the namespaces
+// and apply the resource limits, then replaces itself by the command. The limits of the go command
+// apply to the test binaries and the programs it runs, not to the compiler.
+func (s *sandbox) run(timeout time.Duration, dir string, stdin io.Reader, extraEnv []string, name string, args ...string) (*commandResult, error) {
+	self, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+
+	if name == "go" {
+		args = goExecArgs(self, args)
+		extraEnv = append([]string{sandboxDeferLimitsEnv + "=1"}, extraEnv...)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	for {
+		cmd := exec.CommandContext(ctx, self, append([]string{name}, args...)...)
+		cmd.Dir = filepath.Join(s.dir, dir)
+		cmd.Env = s.env(extraEnv)
+		cmd.Stdin = stdin
+		setSandboxProcAttr(cmd, s.isolated)
+		// SIGQUIT makes the Go programs print the stack of every goroutine, the whole group is killed after.
+		cmd.Cancel = func() error {
+			time.AfterFunc(time.Second, func() {
+				_ = signalProcessGroup(cmd, syscall.SIGKILL)
+			})
+			return signalProcessGroup(cmd, syscall.SIGQUIT)
+		}
+		cmd.WaitDelay = 2 * time.Second
+
+		var stdout, stderr bytes.Buffer
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+
+		if err := cmd.Start(); err != nil {
+			if !s.isolated {
+				return nil, err
+			}
+			if err := s.disableIsolation(err); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		err := cmd.Wait()
+
+		result := &commandResult{
+			Stdout:   s.restorePaths(stdout.String()),
+			Stderr:   s.restorePaths(stderr.String()),
+			TimedOut: errors.Is(ctx.Err(), context.DeadlineExceeded),
+		}
+
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			result.ExitCode = exitErr.ExitCode()
+		} else if err != nil && !result.TimedOut {
+			return nil, err
+		}
+
+		if result.ExitCode == sandboxSetupExitCode && strings.HasPrefix(stderr.String(), sandboxSetupError) {
+			err := errors.New(strings.TrimSpace(stderr.String()))
+			if !s.isolated {
+				return nil, err
+			}
+			// The folders of the mount namespace cannot be set up either: the isolation is unavailable.
+			if err := s.disableIsolation(err); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		return result, nil
+	}
+}
+
+// disableIsolation runs the next commands without the namespaces when they cannot be set up and
+// allow_unisolated is set, or returns the error otherwise.
+func (s *sandbox) disableIsolation(err error) error {
+	if !s.cfg.AllowUnisolated {
+		return fmt.Errorf("isolation unavailable, set sandbox.allow_unisolated to run without it: %w", err)
+	}
+	log.WithError(err).Warn("isolation unavailable, running the sandbox with network access, a writable module cache and the home folder visible")
+	s.isolated = false
+	return nil
+}
+
+// foldersUnder returns the folders of paths found under dir, without the ones held by another of them.
+// ok is false when one of the paths is dir or holds it.
+func foldersUnder(dir string, paths []string) (under []string, ok bool) {
+	isUnder := func(path, parent string) bool {
+		rel, err := filepath.Rel(parent, path)
+		return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+	}
+
+	held := func(path string) bool {
+		for _, parent := range under {
+			if isUnder(path, parent) {
+				return true
+			}
+		}
+		return false
+	}
+
+	// The folders are sorted so that a folder comes before the ones it holds.
+	sorted := append([]string{}, paths...)
+	sort.Strings(sorted)
+	for _, path := range sorted {
+		switch {
+		case path == "":
+		case isUnder(dir, path):
+			return nil, false
+		case !isUnder(path, dir), held(path):
+		default:
+			under = append(under, filepath.Clean(path))
+		}
+	}
+	return under, true
+}
+
+// goExecArgs makes `go test` and `go run` start the test binaries and the programs through goia with -exec,
+// so that the resource limits apply to them.
+func goExecArgs(self string, args []string) []string {
+	if len(args) == 0 || (args[0] != "test" && args[0] != "run") {
+		return args
+	}
+	if strings.ContainsAny(self, " \t'") {
+		self = strconv.Quote(self)
+	}
+	return append([]string{args[0], "-exec", self}, args[1:]...)
+}
+
+// runCommand executes a command in the job folder, in a sandbox if configured.
+// The returned error is set when the command fails, as with exec.Cmd.Run.
+func (j *job) runCommand(stdin io.Reader, extraEnv []string, name string, args ...string) (*commandResult, error) {
+	if !j.sandboxConfig.Enabled {
+		cmd := exec.Command(name, args...)
+		cmd.Dir = j.fileDir
+		cmd.Env = append(os.Environ(), extraEnv...)
+		cmd.Stdin = stdin
+
+		var stdout, stderr bytes.Buffer
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+
+		err := cmd.Run()
+		result := &commandResult{Stdout: stdout.String(), Stderr: stderr.String()}
+
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			result.ExitCode = exitErr.ExitCode()
+		}
+		return result, err
+	}
+
+	s, err := j.jobSandbox()
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := s.run(s.timeout, ".", stdin, extraEnv, name, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	if result.TimedOut {
+		return result, fmt.Errorf(j.t("command killed after %s"), s.timeout)
+	}
+	if result.ExitCode != 0 {
+		return result, fmt.Errorf("exit status %d", result.ExitCode)
+	}
+	return result, nil
+}
+
+// syncTree makes dst a copy of the folder src, without the version control folders. Only the files
+// changed since the last synchronization are copied, and the ones removed from src are removed.
+func syncTree(src, dst string) error {
+	seen := make(map[string]bool)
+	err := filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		seen[rel] = true
+
+		switch {
+		case d.IsDir():
+			if d.Name() == ".git" && path != src {
+				return filepath.SkipDir
+			}
+			if info, err := os.Lstat(target); err == nil && !info.IsDir() {
+				_ = os.Remove(target)
+			}
+			return os.MkdirAll(target, 0o755)
+
+		case d.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			if current, err := os.Readlink(target); err == nil && current == link {
+				return nil
+			}
+			if err := os.RemoveAll(target); err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+
+		case d.Type().IsRegular():
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			if current, err := os.Lstat(target); err == nil && current.Mode().IsRegular() &&
+				current.Size() == info.Size() && current.ModTime().Equal(info.ModTime()) {
+				return nil
+			}
+			if err := os.RemoveAll(target); err != nil {
+				return err
+			}
+			if err := copyFile(path, target, info.Mode().Perm()); err != nil {
+				return err
+			}
+			return os.Chtimes(target, info.ModTime(), info.ModTime())
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return filepath.WalkDir(dst, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dst, path)
+		if err != nil || seen[rel] {
+			return err
+		}
+		if err := os.RemoveAll(path); err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+}
+
+// copyFile copies the regular file src into dst.
+func copyFile(src, dst string, perm fs.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = in.Close()
+	}()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
+}
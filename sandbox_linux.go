@@ -0,0 +1,203 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// setSandboxProcAttr starts the command in its own process group and, when isolated, in new user,
+// mount and network namespaces so that it has no network access and can have read-only folders.
+func setSandboxProcAttr(cmd *exec.Cmd, isolated bool) {
+	attr := &syscall.SysProcAttr{
+		Setpgid:   true,
+		Pdeathsig: syscall.SIGKILL,
+	}
+
+	if isolated {
+		attr.Cloneflags = syscall.CLONE_NEWNET | syscall.CLONE_NEWNS
+		if os.Getuid() != 0 {
+			attr.Cloneflags |= syscall.CLONE_NEWUSER
+			attr.UidMappings = []syscall.SysProcIDMap{{ContainerID: os.Getuid(), HostID: os.Getuid(), Size: 1}}
+			attr.GidMappings = []syscall.SysProcIDMap{{ContainerID: os.Getgid(), HostID: os.Getgid(), Size: 1}}
+			attr.GidMappingsEnableSetgroups = false
+		}
+	}
+
+	cmd.SysProcAttr = attr
+}
+
+// signalProcessGroup sends a signal to the command and all the processes it started.
+func signalProcessGroup(cmd *exec.Cmd, sig syscall.Signal) error {
+	if cmd.Process == nil {
+		return nil
+	}
+	return syscall.Kill(-cmd.Process.Pid, sig)
+}
+
+// execSandboxed makes the folders given by the environment read-only and applies the resource limits,
+// then replaces the current process by the command given in the arguments. When the limits are deferred,
+// they are applied by the programs run by the go command, which re-executes goia with -exec.
+func execSandboxed(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("no command to run in the sandbox")
+	}
+
+	readOnly := filepath.SplitList(os.Getenv(sandboxReadOnlyEnv))
+	hidden := filepath.SplitList(os.Getenv(sandboxHiddenEnv))
+	if len(readOnly) > 0 || len(hidden) > 0 {
+		if err := unix.Mount("", "/", "", unix.MS_REC|unix.MS_PRIVATE, ""); err != nil {
+			return fmt.Errorf("making the mounts private: %w", err)
+		}
+		kept := append(filepath.SplitList(os.Getenv(sandboxWritableEnv)), readOnly...)
+		if err := hideFolders(hidden, kept); err != nil {
+			return fmt.Errorf("hiding the folders: %w", err)
+		}
+		if err := remountReadOnly(readOnly); err != nil {
+			return fmt.Errorf("making the folders read-only: %w", err)
+		}
+	}
+
+	deferLimits := os.Getenv(sandboxDeferLimitsEnv) != ""
+
+	limits := []struct {
+		env      string
+		resource int
+		scale    uint64
+	}{
+		{env: sandboxCPUTimeEnv, resource: unix.RLIMIT_CPU, scale: 1},
+		{env: sandboxMemoryEnv, resource: unix.RLIMIT_AS, scale: 1024 * 1024},
+		{env: sandboxProcessesEnv, resource: unix.RLIMIT_NPROC, scale: 1},
+	}
+
+	for _, limit := range limits {
+		if deferLimits {
+			break
+		}
+		value, err := strconv.ParseUint(os.Getenv(limit.env), 10, 64)
+		if err != nil || value == 0 {
+			continue
+		}
+
+		rlimit := unix.Rlimit{Cur: value * limit.scale, Max: value * limit.scale}
+		if err := unix.Prlimit(0, limit.resource, &rlimit, nil); err != nil {
+			return fmt.Errorf("setting the limit %s: %w", limit.env, err)
+		}
+	}
+
+	path, err := exec.LookPath(args[0])
+	if err != nil {
+		return err
+	}
+
+	var env []string
+	for _, kv := range os.Environ() {
+		name, _, _ := strings.Cut(kv, "=")
+		switch {
+		case name == sandboxReadOnlyEnv, name == sandboxHiddenEnv, name == sandboxWritableEnv, name == sandboxDeferLimitsEnv:
+		case strings.HasPrefix(name, "GOIA_SANDBOX_") && !deferLimits:
+		default:
+			env = append(env, kv)
+		}
+	}
+
+	return syscall.Exec(path, args, env)
+}
+
+// hideFolders mounts an empty tmpfs over each hidden folder in the mount namespace of the process, then
+// mounts back the kept folders found under it. They are opened before being hidden, and mounted back
+// from their descriptors.
+func hideFolders(hidden, kept []string) error {
+	for _, dir := range hidden {
+		if _, err := os.Stat(dir); err != nil {
+			continue
+		}
+		under, ok := foldersUnder(dir, kept)
+		if !ok {
+			// A kept folder holds the hidden one, which cannot be hidden.
+			continue
+		}
+
+		fds := make([]int, 0, len(under))
+		for _, path := range under {
+			fd, err := unix.Open(path, unix.O_PATH|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+			if err != nil {
+				fd = -1
+			}
+			fds = append(fds, fd)
+		}
+
+		err := mountHidden(dir, under, fds)
+		for _, fd := range fds {
+			if fd >= 0 {
+				_ = unix.Close(fd)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mountHidden mounts a tmpfs over a folder and the folders opened as fds back at their paths.
+func mountHidden(dir string, under []string, fds []int) error {
+	if err := unix.Mount("tmpfs", dir, "tmpfs", unix.MS_NOSUID|unix.MS_NODEV, "mode=0700"); err != nil {
+		return err
+	}
+	for i, path := range under {
+		if fds[i] < 0 {
+			continue
+		}
+		if err := os.MkdirAll(path, 0o700); err != nil {
+			return err
+		}
+		if err := unix.Mount(fmt.Sprintf("/proc/self/fd/%d", fds[i]), path, "", unix.MS_BIND|unix.MS_REC, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// remountReadOnly makes folders read-only in the mount namespace of the process.
+func remountReadOnly(dirs []string) error {
+	for _, dir := range dirs {
+		if _, err := os.Stat(dir); err != nil {
+			continue
+		}
+		if err := unix.Mount(dir, dir, "", unix.MS_BIND|unix.MS_REC, ""); err != nil {
+			return err
+		}
+
+		// The flags of the mount locked by the user namespace must be kept by the remount.
+		var stat unix.Statfs_t
+		if err := unix.Statfs(dir, &stat); err != nil {
+			return err
+		}
+		flags := uintptr(unix.MS_BIND | unix.MS_REMOUNT | unix.MS_RDONLY)
+		for st, ms := range map[int64]uintptr{
+			unix.ST_NOSUID:     unix.MS_NOSUID,
+			unix.ST_NODEV:      unix.MS_NODEV,
+			unix.ST_NOEXEC:     unix.MS_NOEXEC,
+			unix.ST_NOATIME:    unix.MS_NOATIME,
+			unix.ST_NODIRATIME: unix.MS_NODIRATIME,
+			unix.ST_RELATIME:   unix.MS_RELATIME,
+		} {
+			if int64(stat.Flags)&st != 0 {
+				flags |= ms
+			}
+		}
+		if err := unix.Mount("", dir, "", flags, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,25 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"os/exec"
+	"syscall"
+)
+
+// setSandboxProcAttr does nothing as the isolation is only supported on Linux.
+func setSandboxProcAttr(cmd *exec.Cmd, isolated bool) {}
+
+// signalProcessGroup sends a signal to the command.
+func signalProcessGroup(cmd *exec.Cmd, sig syscall.Signal) error {
+	if cmd.Process == nil {
+		return nil
+	}
+	return cmd.Process.Signal(sig)
+}
+
+// execSandboxed is not supported outside of Linux.
+func execSandboxed(args []string) error {
+	return errors.New("the sandbox is only supported on Linux")
+}
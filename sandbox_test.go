@@ -0,0 +1,280 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// treeFiles returns the content of the regular files of a folder by relative path.
+func treeFiles(t *testing.T, dir string) map[string]string {
+	t.Helper()
+
+	files := make(map[string]string)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		files[filepath.ToSlash(rel)] = string(data)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestSyncTree(t *testing.T) {
+	src := writeModule(t, map[string]string{
+		"go.mod":      "module m\n",
+		"a/a.go":      "package a\n",
+		"b/b.go":      "package b\n",
+		".git/config": "[core]\n",
+	})
+	dst := filepath.Join(t.TempDir(), "copy")
+
+	if err := syncTree(src, dst); err != nil {
+		t.Fatalf("first syncTree() error = %v", err)
+	}
+	want := map[string]string{"go.mod": "module m\n", "a/a.go": "package a\n", "b/b.go": "package b\n"}
+	if got := treeFiles(t, dst); !reflect.DeepEqual(got, want) {
+		t.Fatalf("first syncTree() = %v, want %v", got, want)
+	}
+
+	tests := []struct {
+		name   string
+		change func()
+		want   map[string]string
+	}{
+		{
+			name: "modified file",
+			change: func() {
+				path := filepath.Join(src, "a", "a.go")
+				_ = os.WriteFile(path, []byte("package a // changed\n"), 0o644)
+				_ = os.Chtimes(path, time.Now().Add(time.Minute), time.Now().Add(time.Minute))
+			},
+			want: map[string]string{"go.mod": "module m\n", "a/a.go": "package a // changed\n", "b/b.go": "package b\n"},
+		},
+		{
+			name:   "removed folder",
+			change: func() { _ = os.RemoveAll(filepath.Join(src, "b")) },
+			want:   map[string]string{"go.mod": "module m\n", "a/a.go": "package a // changed\n"},
+		},
+		{
+			name:   "file written in the copy",
+			change: func() { _ = os.WriteFile(filepath.Join(dst, "a", "extra.go"), []byte("package a\n"), 0o644) },
+			want:   map[string]string{"go.mod": "module m\n", "a/a.go": "package a // changed\n"},
+		},
+		{
+			name: "file modified in the copy",
+			change: func() {
+				path := filepath.Join(dst, "go.mod")
+				_ = os.WriteFile(path, []byte("module x\n"), 0o644)
+				_ = os.Chtimes(path, time.Now().Add(time.Hour), time.Now().Add(time.Hour))
+			},
+			want: map[string]string{"go.mod": "module m\n", "a/a.go": "package a // changed\n"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.change()
+			if err := syncTree(src, dst); err != nil {
+				t.Fatalf("syncTree() error = %v", err)
+			}
+			if got := treeFiles(t, dst); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("syncTree() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGoExecArgs(t *testing.T) {
+	tests := []struct {
+		name string
+		self string
+		args []string
+		want []string
+	}{
+		{"test", "/bin/goia", []string{"test", "-count=1", "./..."}, []string{"test", "-exec", "/bin/goia", "-count=1", "./..."}},
+		{"run", "/bin/goia", []string{"run", "."}, []string{"run", "-exec", "/bin/goia", "."}},
+		{"build is not limited", "/bin/goia", []string{"build", "./..."}, []string{"build", "./..."}},
+		{"vet is not limited", "/bin/goia", []string{"vet", "./..."}, []string{"vet", "./..."}},
+		{"no argument", "/bin/goia", nil, nil},
+		{"path with spaces", "/my bin/goia", []string{"test"}, []string{"test", "-exec", `"/my bin/goia"`}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := goExecArgs(tt.self, tt.args); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("goExecArgs() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSandboxEnv(t *testing.T) {
+	t.Setenv("GOCACHE", "/host/cache")
+	t.Setenv("HOME", "/host/home")
+
+	tests := []struct {
+		name     string
+		sandbox  sandbox
+		contains []string
+		excludes []string
+	}{
+		{
+			name: "isolated without network",
+			sandbox: sandbox{
+				home: "/s/home", tmp: "/s/tmp", isolated: true,
+				goEnv:    []string{"GOCACHE=/s/cache", "GOMODCACHE=/host/mod"},
+				readOnly: []string{"/host/mod", "/src"},
+				hidden:   []string{"/host/home"},
+				writable: []string{"/s", "/host/home/.cache/goia"},
+				cfg:      SandboxConfig{MemoryMB: 512},
+			},
+			contains: []string{
+				"HOME=/s/home", "TMPDIR=/s/tmp", "GOCACHE=/s/cache", "GOMODCACHE=/host/mod", "GOPROXY=off",
+				sandboxReadOnlyEnv + "=/host/mod" + string(filepath.ListSeparator) + "/src",
+				sandboxHiddenEnv + "=/host/home",
+				sandboxWritableEnv + "=/s" + string(filepath.ListSeparator) + "/host/home/.cache/goia",
+				sandboxMemoryEnv + "=512", "EXTRA=1",
+			},
+			excludes: []string{"GOCACHE=/host/cache", "HOME=/host/home"},
+		},
+		{
+			name:     "with network",
+			sandbox:  sandbox{home: "/s/home", tmp: "/s/tmp", cfg: SandboxConfig{Network: true}},
+			contains: []string{"HOME=/s/home", "EXTRA=1"},
+			excludes: []string{"GOPROXY=off", "GOCACHE=/host/cache"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := tt.sandbox.env([]string{"EXTRA=1"})
+			sort.Strings(env)
+			for _, kv := range tt.contains {
+				if i := sort.SearchStrings(env, kv); i == len(env) || env[i] != kv {
+					t.Errorf("env() has no %s", kv)
+				}
+			}
+			for _, kv := range tt.excludes {
+				if i := sort.SearchStrings(env, kv); i < len(env) && env[i] == kv {
+					t.Errorf("env() has %s", kv)
+				}
+			}
+			for _, kv := range env {
+				if (strings.HasPrefix(kv, sandboxReadOnlyEnv+"=") || strings.HasPrefix(kv, sandboxHiddenEnv+"=")) && !tt.sandbox.isolated {
+					t.Errorf("env() has %s without isolation", kv)
+				}
+			}
+		})
+	}
+}
+
+func TestFoldersUnder(t *testing.T) {
+	tests := []struct {
+		name   string
+		paths  []string
+		want   []string
+		wantOk bool
+	}{
+		{"nothing", nil, nil, true},
+		{"outside", []string{"/tmp/s", "/usr/local/go", "/home/userx"}, nil, true},
+		{"under", []string{"/home/user/go/pkg/mod", "/tmp/s", "/home/user/src/"}, []string{"/home/user/go/pkg/mod", "/home/user/src"}, true},
+		{"held by another", []string{"/home/user/src/sub", "/home/user/src-2", "/home/user/src"}, []string{"/home/user/src", "/home/user/src-2"}, true},
+		{"same folder", []string{"/home/user/src", "/home/user"}, nil, false},
+		{"holding folder", []string{"/home"}, nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := foldersUnder("/home/user", tt.paths)
+			if ok != tt.wantOk || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("foldersUnder() = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestDisableIsolation(t *testing.T) {
+	tests := []struct {
+		name         string
+		allow        bool
+		wantErr      bool
+		wantIsolated bool
+	}{
+		{"not allowed", false, true, true},
+		{"allowed", true, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &sandbox{isolated: true, cfg: SandboxConfig{AllowUnisolated: tt.allow}}
+			err := s.disableIsolation(errors.New(sandboxSetupError + "hiding the folders: permission denied"))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("disableIsolation() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if s.isolated != tt.wantIsolated {
+				t.Errorf("disableIsolation() isolated = %v, want %v", s.isolated, tt.wantIsolated)
+			}
+		})
+	}
+}
+
+func TestCopyFromSandbox(t *testing.T) {
+	src := writeModule(t, map[string]string{
+		"go.mod":                       "module m\n",
+		"p/testdata/fuzz/FuzzF/seed-0": "go test fuzz v1\nstring(\"a\")\n",
+	})
+	root := t.TempDir()
+	s := &sandbox{src: src, dir: filepath.Join(root, "src"), root: root}
+	if err := syncTree(src, s.dir); err != nil {
+		t.Fatal(err)
+	}
+
+	// The fuzzing writes a failing input in the working copy.
+	crasher := filepath.Join(s.dir, "p", "testdata", "fuzz", "FuzzF", "771e938e4458e983")
+	if err := os.WriteFile(crasher, []byte("go test fuzz v1\nstring(\"0\")\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(s.dir, "p", "other.txt"), []byte("other\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	j := &job{sandbox: s}
+	if err := j.copyFromSandbox(filepath.Join("p", "testdata", "fuzz", "FuzzF")); err != nil {
+		t.Fatalf("copyFromSandbox() error = %v", err)
+	}
+	if err := j.copyFromSandbox(filepath.Join("p", "testdata", "fuzz", "FuzzMissing")); err != nil {
+		t.Fatalf("copyFromSandbox() of a missing folder error = %v", err)
+	}
+
+	want := map[string]string{
+		"go.mod":                                 "module m\n",
+		"p/testdata/fuzz/FuzzF/seed-0":           "go test fuzz v1\nstring(\"a\")\n",
+		"p/testdata/fuzz/FuzzF/771e938e4458e983": "go test fuzz v1\nstring(\"0\")\n",
+	}
+	if got := treeFiles(t, src); !reflect.DeepEqual(got, want) {
+		t.Errorf("copyFromSandbox() = %v, want %v", got, want)
+	}
+
+	// The failing input is kept by the next synchronization.
+	if err := syncTree(src, s.dir); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(crasher); err != nil {
+		t.Errorf("failing input removed by syncTree(): %v", err)
+	}
+}
@@ -18,11 +18,13 @@ const (
 	stepStart              step = "start"
 	stepStartTest          step = "startTest"
 	stepOptimize           step = "optimize"
+	stepRefactor           step = "refactor"
 	stepAddTest            step = "tests"
 	stepFinish             step = "finish"
 
 	stepStartError    step = "startError"
 	stepOptimizeError step = "optimizeError"
+	stepRefactorError step = "refactorError"
 
 	stepAddTestError step = "addTestsError"
 )
@@ -39,10 +41,16 @@ var stepsOrderDefault = []StepWithError{
 	{ValidStep: stepVerifyGoPrompt},
 	{ValidStep: stepProjectStructuring},
 	{ValidStep: stepStart, ErrorStep: stepStartError},
-	// {ValidStep: stepOptimize, ErrorStep: stepOptimizeError, Prompt: "Optimize this Golang code taking into account readability, performance, and best practices. Only change behavior if it can be improved for more efficient or safer use cases. Return optimizations made, without comment or explanation. Here is the code: \nHere is the Golang code:\n\n"},
 	// {ValidStep: stepAddTest, ErrorStep: stepAddTestError},
 }
 
+// stepsRewrite are the steps rewriting the existing code, selected by the rewrite_steps configuration
+// and run after the start step in the given order.
+var stepsRewrite = map[step]StepWithError{
+	stepOptimize: {ValidStep: stepOptimize, ErrorStep: stepOptimizeError, Prompt: "Optimize this Golang code taking into account readability, performance, and best practices. Only change behavior if it can be improved for more efficient or safer use cases. Return optimizations made, without comment or explanation. Here is the code: \nHere is the Golang code:\n\n"},
+	stepRefactor: {ValidStep: stepRefactor, ErrorStep: stepRefactorError, Prompt: "Refactor this Golang code to improve its readability and structure without changing its behavior. Return the refactored code, without comment or explanation. Here is the Golang code:\n\n"},
+}
+
 // stepsOrderTest is an ordered list of steps for test files.
 var stepsOrderTest = []StepWithError{
 	//{ValidStep: stepVerifyTestPrompt},
@@ -71,6 +79,7 @@ func (j *job) getStepFromFileName() ([]StepWithError, error) {
 	default:
 		j.currentFileName = j.fileName
 		j.currentSourceFileName = j.fileName
+		stepChoose = j.withRewriteSteps(stepsOrderDefault)
 		{
 			testFileName, err := j.getTestFilename()
 			if err != nil {
@@ -138,9 +147,15 @@ func (j *job) getPromptToAskTestsCreation() string {
 
 	prompt := j.t("I have some Golang code") + ":"
 	prompt += "\n\n" + string(fileContent)
+	if ref := j.apiReference(j.currentSourceFileName, nil); ref != "" {
+		prompt += "\n\n" + ref
+	}
 	prompt += "\n\n" + j.t("I would like to enrich these functions with unit tests") + ":"
 	prompt += "\n\n" + j.printTestsFuncName()
 	prompt += "\n\n" + j.t("Can you generate the tests for the nominal cases as well as the error cases? My goal is to ensure comprehensive coverage, particularly for:\n\nExpected success scenarios (nominal cases)\nError handling scenarios\nPlease structure the tests to be easily readable, using t.Run to name each test case.")
+	if style := j.testStylePrompt(); style != "" {
+		prompt += "\n\n" + style
+	}
 	prompt += "\n\n" + j.t("Reply without comment or explanation")
 	return prompt
 }
@@ -148,7 +163,8 @@ func (j *job) getPromptToAskTestsCreation() string {
 func (j *job) getPromptToAskTestCorrection() string {
 	prompt := j.t("Determines whether the problem is in the test file or the source file. Generates a concise response that specifies the file to modify in the form") +
 		": \"MODIFY: <function or section name> (source <folder/filename.go>, not test file)\" or \"MODIFY: <function or section name> (test file)\"." +
-		j.t("Then provide the corrected code in the form") + ": \"CODE: <corrected code>\".\n\n"
+		j.t("Then provide the corrected code in the form") + ": \"CODE: <corrected code>\".\n\n" +
+		j.declOpsPrompt() + ".\n\n"
 
 	fileContent := string(j.currentSrcTest)
 	if len(fileContent) > 50 {
@@ -158,13 +174,7 @@ func (j *job) getPromptToAskTestCorrection() string {
 }
 
 // stepAddTestErrorProcessPrompt adds a prompt to handle errors when adding tests.
-func (j *job) stepAddTestErrorProcessPrompt(output string) (string, error) {
-	getFailedTests, err := j.getFailedTests(output)
-	if err != nil {
-		fmt.Println(j.t("Error when recovering failed tests"), err)
-		return "", err
-	}
-
+func (j *job) stepAddTestErrorProcessPrompt(output string, getFailedTests []string) (string, error) {
 	if getFailedTests == nil {
 		fmt.Println(j.t("No test failed"))
 		return "", nil
@@ -178,10 +188,27 @@ func (j *job) stepAddTestErrorProcessPrompt(output string) (string, error) {
 
 	prompt := j.t("The following tests") + " \n\n" + testCode + "\n\n " +
 		j.t("returned the following errors") + ": \n\n" +
-		j.t("Error") + " : " + output + "\n\n" +
+		j.t("Error") + " : " + output + "\n\n"
+	if ref := j.apiReference(j.currentTestFileName, nil); ref != "" {
+		prompt += ref + "\n\n"
+	}
+	prompt += j.declOpsPrompt() + ".\n\n" +
 		j.t("Determines whether the problem is in the test file or the source file. Generates a concise response that specifies the file to modify in the form: \"MODIFY: <function or section name> (source file, not test file)\" or \"MODIFY: <function or section name> (test file)\"") + "." +
 		j.t("Then provide the corrected code in the form: \"CODE: <corrected code>\"") + "." +
 		j.t("responds without adding comments or explanations")
 
 	return prompt, nil
 }
+
+// withRewriteSteps returns the steps followed by the rewrite steps of the configuration.
+func (j *job) withRewriteSteps(steps []StepWithError) []StepWithError {
+	if len(j.rewriteSteps) == 0 {
+		return steps
+	}
+
+	withRewrite := append([]StepWithError{}, steps...)
+	for _, name := range j.rewriteSteps {
+		withRewrite = append(withRewrite, stepsRewrite[step(name)])
+	}
+	return withRewrite
+}
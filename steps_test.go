@@ -0,0 +1,41 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestWithRewriteSteps(t *testing.T) {
+	steps := []StepWithError{{ValidStep: stepVerifyGoPrompt}, {ValidStep: stepStart, ErrorStep: stepStartError}}
+
+	tests := []struct {
+		name    string
+		rewrite []string
+		want    []step
+	}{
+		{"no rewrite", nil, []step{stepVerifyGoPrompt, stepStart}},
+		{"optimize", []string{"optimize"}, []step{stepVerifyGoPrompt, stepStart, stepOptimize}},
+		{"refactor then optimize", []string{"refactor", "optimize"}, []step{stepVerifyGoPrompt, stepStart, stepRefactor, stepOptimize}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			j := &job{rewriteSteps: tt.rewrite}
+
+			var got []step
+			for _, s := range j.withRewriteSteps(steps) {
+				got = append(got, s.ValidStep)
+				if isRewriteStep(s.ValidStep) && s.Prompt == "" {
+					t.Errorf("step %s has no prompt", s.ValidStep)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("withRewriteSteps() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if len(steps) != 2 {
+		t.Errorf("withRewriteSteps() changed the given steps: %v", steps)
+	}
+}
@@ -0,0 +1,379 @@
+package main
+
+import (
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// testCaseNameFields are the struct fields naming the cases of a table-driven test.
+var testCaseNameFields = map[string]bool{
+	"name": true, "desc": true, "description": true, "title": true, "scenario": true, "testname": true,
+}
+
+// testTable is the table of cases of a table-driven test.
+type testTable struct {
+	lit *ast.CompositeLit
+	// elemType is the type of the cases, fields are its field names when it is an anonymous struct.
+	elemType ast.Expr
+	fields   map[string]bool
+	// isMap is true when the cases are the values of a map keyed by their name.
+	isMap bool
+}
+
+// findTestTable returns the table of a test function whose cases are run with t.Run in a range loop.
+func findTestTable(funcDecl *ast.FuncDecl) *testTable {
+	if funcDecl.Recv != nil || funcDecl.Body == nil || !strings.HasPrefix(funcDecl.Name.Name, "Test") {
+		return nil
+	}
+
+	params := funcDecl.Type.Params.List
+	if len(params) != 1 || len(params[0].Names) != 1 {
+		return nil
+	}
+	tName := params[0].Names[0].Name
+
+	tables := make(map[string]*ast.CompositeLit)
+	var found *ast.CompositeLit
+
+	ast.Inspect(funcDecl.Body, func(n ast.Node) bool {
+		if found != nil {
+			return false
+		}
+
+		switch node := n.(type) {
+		case *ast.AssignStmt:
+			for i, rhs := range node.Rhs {
+				if lit, ok := rhs.(*ast.CompositeLit); ok && i < len(node.Lhs) {
+					if ident, ok := node.Lhs[i].(*ast.Ident); ok {
+						tables[ident.Name] = lit
+					}
+				}
+			}
+
+		case *ast.ValueSpec:
+			for i, value := range node.Values {
+				if lit, ok := value.(*ast.CompositeLit); ok && i < len(node.Names) {
+					tables[node.Names[i].Name] = lit
+				}
+			}
+
+		case *ast.RangeStmt:
+			var lit *ast.CompositeLit
+			switch x := node.X.(type) {
+			case *ast.Ident:
+				lit = tables[x.Name]
+			case *ast.CompositeLit:
+				lit = x
+			}
+			if lit != nil && callsTestRun(node.Body, tName) {
+				found = lit
+			}
+		}
+		return true
+	})
+
+	if found == nil {
+		return nil
+	}
+
+	table := &testTable{lit: found}
+	switch typ := found.Type.(type) {
+	case *ast.ArrayType:
+		table.elemType = typ.Elt
+	case *ast.MapType:
+		if key, ok := typ.Key.(*ast.Ident); !ok || key.Name != "string" {
+			return nil
+		}
+		table.elemType = typ.Value
+		table.isMap = true
+	default:
+		return nil
+	}
+
+	if structType, ok := table.elemType.(*ast.StructType); ok {
+		table.fields = make(map[string]bool)
+		for _, field := range structType.Fields.List {
+			for _, name := range field.Names {
+				table.fields[name.Name] = true
+			}
+		}
+	}
+
+	return table
+}
+
+// callsTestRun checks whether a block calls t.Run, t being the name of the *testing.T parameter.
+func callsTestRun(body *ast.BlockStmt, tName string) bool {
+	found := false
+	ast.Inspect(body, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok || found {
+			return !found
+		}
+		if sel, ok := call.Fun.(*ast.SelectorExpr); ok && sel.Sel.Name == "Run" {
+			if x, ok := sel.X.(*ast.Ident); ok && x.Name == tName {
+				found = true
+			}
+		}
+		return true
+	})
+	return found
+}
+
+// caseName returns the name of a case of a table, or an empty string if it has none.
+func (table *testTable) caseName(elt ast.Expr) string {
+	if table.isMap {
+		kv, ok := elt.(*ast.KeyValueExpr)
+		if !ok {
+			return ""
+		}
+		return stringLiteral(kv.Key)
+	}
+
+	lit, ok := elt.(*ast.CompositeLit)
+	if !ok {
+		return ""
+	}
+	for _, field := range lit.Elts {
+		kv, ok := field.(*ast.KeyValueExpr)
+		if !ok {
+			continue
+		}
+		if key, ok := kv.Key.(*ast.Ident); ok && testCaseNameFields[strings.ToLower(key.Name)] {
+			return stringLiteral(kv.Value)
+		}
+	}
+	return ""
+}
+
+// stringLiteral returns the value of a string literal, or an empty string.
+func stringLiteral(expr ast.Expr) string {
+	lit, ok := expr.(*ast.BasicLit)
+	if !ok || lit.Kind != token.STRING {
+		return ""
+	}
+	s, err := strconv.Unquote(lit.Value)
+	if err != nil {
+		return ""
+	}
+	return s
+}
+
+// compatible checks whether the cases of the other table can be added to this table.
+func (table *testTable) compatible(other *testTable, otherSrc []byte, src []byte, fs, otherFs *token.FileSet) bool {
+	if table.isMap != other.isMap {
+		return false
+	}
+
+	if table.fields == nil {
+		// Named case types must be the same.
+		return nodeText(src, fs, table.elemType) == nodeText(otherSrc, otherFs, other.elemType)
+	}
+
+	for _, elt := range other.lit.Elts {
+		if kv, ok := elt.(*ast.KeyValueExpr); ok && table.isMap {
+			elt = kv.Value
+		}
+		lit, ok := elt.(*ast.CompositeLit)
+		if !ok {
+			return false
+		}
+		for _, field := range lit.Elts {
+			kv, ok := field.(*ast.KeyValueExpr)
+			if !ok {
+				return false
+			}
+			if key, ok := kv.Key.(*ast.Ident); !ok || !table.fields[key.Name] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// nodeText returns the source of a node.
+func nodeText(src []byte, fs *token.FileSet, node ast.Node) string {
+	start, end := fs.Position(node.Pos()).Offset, fs.Position(node.End()).Offset
+	if start < 0 || end > len(src) || start > end {
+		return ""
+	}
+	return string(src[start:end])
+}
+
+// textEdit replaces the bytes between two offsets of a source.
+type textEdit struct {
+	start, end int
+	text       string
+}
+
+// applyTextEdits applies non overlapping edits to a source.
+// The insertions at the same offset keep their order, and an insertion at the start of a replaced
+// range is put before the replacement.
+func applyTextEdits(src []byte, edits []textEdit) []byte {
+	// The edits are applied from the end of the source, so the insertions at the same offset are
+	// applied in reverse order, each one before the previous one.
+	sorted := make([]textEdit, len(edits))
+	for i, edit := range edits {
+		sorted[len(edits)-1-i] = edit
+	}
+	sort.SliceStable(sorted, func(a, b int) bool {
+		if sorted[a].start != sorted[b].start {
+			return sorted[a].start > sorted[b].start
+		}
+		return sorted[a].end > sorted[b].end
+	})
+
+	result := append([]byte(nil), src...)
+	for _, edit := range sorted {
+		result = append(result[:edit.start], append([]byte(edit.text), result[edit.end:]...)...)
+	}
+	return result
+}
+
+// mergeTestTables merges the cases of the table-driven tests of the response into the tables of the same
+// tests of the existing source: the cases with the same name are replaced, the others are added, and the
+// rest of the existing tests, such as their helpers and fixtures, is kept.
+// It returns the merged source and the names of the merged tests.
+func (j *job) mergeTestTables(src []byte, response string) ([]byte, map[string]bool) {
+	merged := make(map[string]bool)
+	if len(src) == 0 {
+		return src, merged
+	}
+
+	code := response
+	if !strings.HasPrefix(code, "package") {
+		code = "package main\n\n" + code
+	}
+
+	newFs := token.NewFileSet()
+	newNode, err := parser.ParseFile(newFs, "", code, parser.ParseComments)
+	if err != nil {
+		return src, merged
+	}
+
+	fs := token.NewFileSet()
+	node, err := parser.ParseFile(fs, "", src, parser.ParseComments)
+	if err != nil {
+		return src, merged
+	}
+
+	existing := make(map[string]*ast.FuncDecl)
+	for _, decl := range node.Decls {
+		if funcDecl, ok := decl.(*ast.FuncDecl); ok && funcDecl.Recv == nil {
+			existing[funcDecl.Name.Name] = funcDecl
+		}
+	}
+
+	var edits []textEdit
+	for _, decl := range newNode.Decls {
+		newFunc, ok := decl.(*ast.FuncDecl)
+		if !ok {
+			continue
+		}
+
+		oldFunc, ok := existing[newFunc.Name.Name]
+		if !ok {
+			continue
+		}
+
+		oldTable, newTable := findTestTable(oldFunc), findTestTable(newFunc)
+		if oldTable == nil || newTable == nil || !oldTable.compatible(newTable, []byte(code), src, fs, newFs) {
+			continue
+		}
+
+		funcEdits, ok := mergeTableCases(src, fs, oldTable, []byte(code), newFs, newTable)
+		if !ok {
+			continue
+		}
+
+		edits = append(edits, funcEdits...)
+		merged[newFunc.Name.Name] = true
+	}
+
+	if len(merged) == 0 {
+		return src, merged
+	}
+
+	result, err := format.Source(applyTextEdits(src, edits))
+	if err != nil {
+		log.WithError(err).Warn(j.t("Error merging the test tables, the tests are replaced"))
+		return src, map[string]bool{}
+	}
+
+	for name := range merged {
+		j.listFunctionsUpdated = append(j.listFunctionsUpdated, name)
+	}
+	return result, merged
+}
+
+// mergeTableCases returns the edits replacing the cases of the old table having the same name as
+// new cases, and adding the other new cases at the end of the old table.
+func mergeTableCases(src []byte, fs *token.FileSet, oldTable *testTable, newSrc []byte, newFs *token.FileSet, newTable *testTable) ([]textEdit, bool) {
+	oldCases := make(map[string]ast.Expr)
+	for _, elt := range oldTable.lit.Elts {
+		if name := oldTable.caseName(elt); name != "" {
+			oldCases[name] = elt
+		}
+	}
+
+	var edits []textEdit
+	var added []string
+	seen := make(map[string]bool)
+
+	for _, elt := range newTable.lit.Elts {
+		name := newTable.caseName(elt)
+		if name == "" {
+			// Cases without name cannot be deduplicated.
+			return nil, false
+		}
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		text := nodeText(newSrc, newFs, elt)
+		if old, ok := oldCases[name]; ok {
+			if nodeText(src, fs, old) != text {
+				edits = append(edits, textEdit{
+					start: fs.Position(old.Pos()).Offset,
+					end:   fs.Position(old.End()).Offset,
+					text:  text,
+				})
+			}
+			continue
+		}
+		added = append(added, text)
+	}
+
+	if len(added) > 0 {
+		insertion := "\n" + strings.Join(added, ",\n") + ",\n"
+		offset := fs.Position(oldTable.lit.Rbrace).Offset
+
+		if elts := oldTable.lit.Elts; len(elts) > 0 {
+			last := fs.Position(elts[len(elts)-1].End()).Offset
+			if strings.Contains(string(src[last:offset]), ",") {
+				insertion = ",\n" + strings.Join(added, ",\n")
+			} else {
+				insertion = "," + insertion
+			}
+			offset = last
+		}
+
+		edits = append(edits, textEdit{start: offset, end: offset, text: insertion})
+
+		// A table written on one line is split to have one case per line.
+		lbrace := fs.Position(oldTable.lit.Lbrace)
+		if lbrace.Line == fs.Position(oldTable.lit.Rbrace).Line && len(oldTable.lit.Elts) > 0 {
+			edits = append(edits, textEdit{start: lbrace.Offset + 1, end: lbrace.Offset + 1, text: "\n"})
+		}
+	}
+
+	return edits, true
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestFindTestTable(t *testing.T) {
+	tests := []struct {
+		name   string
+		src    string
+		want   bool
+		isMap  bool
+		fields []string
+	}{
+		{
+			name: "slice of anonymous structs",
+			src: `func TestF(t *testing.T) {
+	tests := []struct{ name string; in, want int }{{name: "a", in: 1, want: 1}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {})
+	}
+}`,
+			want:   true,
+			fields: []string{"in", "name", "want"},
+		},
+		{
+			name: "map keyed by the name",
+			src: `func TestF(t *testing.T) {
+	for name, tt := range map[string]struct{ in int }{"a": {in: 1}} {
+		t.Run(name, func(t *testing.T) { _ = tt })
+	}
+}`,
+			want:   true,
+			isMap:  true,
+			fields: []string{"in"},
+		},
+		{
+			name: "loop without t.Run",
+			src: `func TestF(t *testing.T) {
+	tests := []struct{ name string }{{name: "a"}}
+	for _, tt := range tests {
+		_ = tt
+	}
+}`,
+		},
+		{
+			name: "not a test",
+			src: `func helper(t *testing.T) {
+	tests := []struct{ name string }{{name: "a"}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {})
+	}
+}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file, err := parser.ParseFile(token.NewFileSet(), "", "package p\n\n"+tt.src, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			table := findTestTable(file.Decls[0].(*ast.FuncDecl))
+			if (table != nil) != tt.want {
+				t.Fatalf("findTestTable() = %v, want a table %v", table, tt.want)
+			}
+			if table == nil {
+				return
+			}
+			if table.isMap != tt.isMap {
+				t.Errorf("findTestTable().isMap = %v, want %v", table.isMap, tt.isMap)
+			}
+			var fields []string
+			for field := range table.fields {
+				fields = append(fields, field)
+			}
+			sort.Strings(fields)
+			if !reflect.DeepEqual(fields, tt.fields) {
+				t.Errorf("findTestTable().fields = %v, want %v", fields, tt.fields)
+			}
+		})
+	}
+}
+
+func TestMergeTestTables(t *testing.T) {
+	src := `package p
+
+import "testing"
+
+func helper() int { return 1 }
+
+func TestAdd(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b int
+		want int
+	}{
+		{name: "zero", a: 0, b: 0, want: 0},
+		{name: "positive", a: 1, b: 2, want: 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Add(tt.a, tt.b); got != tt.want {
+				t.Errorf("Add() = %d", got)
+			}
+		})
+	}
+}
+
+func TestOneLine(t *testing.T) {
+	for name, tt := range map[string]struct{ in int }{"one": {in: 1}} {
+		t.Run(name, func(t *testing.T) { _ = tt })
+	}
+}
+`
+
+	tests := []struct {
+		name       string
+		response   string
+		want       string
+		wantMerged map[string]bool
+	}{
+		{
+			name: "case replaced and case added",
+			response: `func TestAdd(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b int
+		want int
+	}{
+		{name: "positive", a: 1, b: 2, want: 3},
+		{name: "negative", a: -1, b: -2, want: -3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {})
+	}
+}`,
+			want: `		{name: "zero", a: 0, b: 0, want: 0},
+		{name: "positive", a: 1, b: 2, want: 3},
+		{name: "negative", a: -1, b: -2, want: -3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Add(tt.a, tt.b); got != tt.want {`,
+			wantMerged: map[string]bool{"TestAdd": true},
+		},
+		{
+			name: "table on one line",
+			response: `func TestOneLine(t *testing.T) {
+	for name, tt := range map[string]struct{ in int }{"two": {in: 2}} {
+		t.Run(name, func(t *testing.T) {})
+	}
+}`,
+			want: `	for name, tt := range map[string]struct{ in int }{
+		"one": {in: 1},
+		"two": {in: 2},
+	} {`,
+			wantMerged: map[string]bool{"TestOneLine": true},
+		},
+		{
+			name: "different fields",
+			response: `func TestAdd(t *testing.T) {
+	tests := []struct {
+		name string
+		in   []int
+		want int
+	}{
+		{name: "sum", in: []int{1, 2}, want: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {})
+	}
+}`,
+			wantMerged: map[string]bool{},
+		},
+		{
+			name: "case without name",
+			response: `func TestAdd(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b int
+		want int
+	}{
+		{a: 5, b: 5, want: 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {})
+	}
+}`,
+			wantMerged: map[string]bool{},
+		},
+		{
+			name:       "new test",
+			response:   `func TestOther(t *testing.T) {}`,
+			wantMerged: map[string]bool{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			j := &job{}
+			got, merged := j.mergeTestTables([]byte(src), tt.response)
+			if !reflect.DeepEqual(merged, tt.wantMerged) {
+				t.Fatalf("mergeTestTables() merged %v, want %v", merged, tt.wantMerged)
+			}
+
+			if tt.want == "" {
+				if string(got) != src {
+					t.Errorf("mergeTestTables() changed the source:\n%s", got)
+				}
+				return
+			}
+			if !strings.Contains(string(got), tt.want) {
+				t.Errorf("mergeTestTables() =\n%s\nwant it to contain\n%s", got, tt.want)
+			}
+			if !strings.Contains(string(got), "func helper() int { return 1 }") {
+				t.Errorf("mergeTestTables() lost the helper:\n%s", got)
+			}
+		})
+	}
+}
+
+func TestApplyTextEdits(t *testing.T) {
+	tests := []struct {
+		name  string
+		src   string
+		edits []textEdit
+		want  string
+	}{
+		{"no edit", "abc", nil, "abc"},
+		{"replacements", "abcdef", []textEdit{{0, 1, "A"}, {4, 6, "EF!"}}, "AbcdEF!"},
+		{"insertion before a replacement", "abc", []textEdit{{1, 2, "B"}, {1, 1, "+"}}, "a+Bc"},
+		{"deletion", "abc", []textEdit{{1, 2, ""}}, "ac"},
+		{"insertions at the same offset", "ac", []textEdit{{1, 1, "1"}, {1, 1, "2"}, {1, 1, "3"}}, "a123c"},
+		{"insertions around a replacement", "abc", []textEdit{{1, 1, "1"}, {1, 2, "B"}, {1, 1, "2"}, {2, 2, "3"}}, "a12B3c"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(applyTextEdits([]byte(tt.src), tt.edits)); got != tt.want {
+				t.Errorf("applyTextEdits() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// maxStyleFiles is the maximum number of test files read to build the test style profile.
+const maxStyleFiles = 50
+
+const (
+	testifyModule  = "github.com/stretchr/testify"
+	gomockModule   = "github.com/golang/mock"
+	uberMockModule = "go.uber.org/mock"
+)
+
+// testStyle is the profile of the tests already written in the project.
+type testStyle struct {
+	files int
+
+	testify         int
+	require         int
+	gomock          int
+	externalPackage int
+	parallel        int
+	golden          int
+	tableDriven     int
+}
+
+// majority checks whether a feature is used by at least half of the test files.
+func (s *testStyle) majority(count int) bool {
+	return s.files > 0 && count*2 >= s.files
+}
+
+// testFilesForStyle returns the test files of the folder of the current source file,
+// or the test files of the module when the folder has none.
+func (j *job) testFilesForStyle() ([]string, error) {
+	dir := filepath.Join(j.fileDir, filepath.Dir(j.currentSourceFileName))
+	files, err := filepath.Glob(filepath.Join(dir, "*_test.go"))
+	if err != nil || len(files) > 0 {
+		return files, err
+	}
+
+	err = filepath.WalkDir(j.fileDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			name := d.Name()
+			if path != j.fileDir && (name == "vendor" || name == "testdata" || strings.HasPrefix(name, ".")) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if strings.HasSuffix(path, "_test.go") {
+			files = append(files, path)
+			if len(files) >= maxStyleFiles {
+				return filepath.SkipAll
+			}
+		}
+		return nil
+	})
+	return files, err
+}
+
+// testStyleProfile reads the existing tests to find their assertion library, package layout and conventions.
+func (j *job) testStyleProfile() (*testStyle, error) {
+	files, err := j.testFilesForStyle()
+	if err != nil {
+		return nil, err
+	}
+
+	style := &testStyle{}
+	for _, file := range files {
+		if j.isCurrentTestFile(file) {
+			continue
+		}
+
+		node, err := parser.ParseFile(token.NewFileSet(), file, nil, 0)
+		if err != nil {
+			continue
+		}
+		style.files++
+
+		imports := make(map[string]bool)
+		for _, imp := range node.Imports {
+			path, _ := strconv.Unquote(imp.Path.Value)
+			imports[path] = true
+		}
+
+		if imports[testifyModule+"/assert"] || imports[testifyModule+"/require"] || imports[testifyModule+"/suite"] {
+			style.testify++
+		}
+		if imports[testifyModule+"/require"] {
+			style.require++
+		}
+		if imports[gomockModule+"/gomock"] || imports[uberMockModule+"/gomock"] {
+			style.gomock++
+		}
+		if strings.HasSuffix(node.Name.Name, "_test") {
+			style.externalPackage++
+		}
+
+		parallel, golden, tableDriven := false, false, false
+		ast.Inspect(node, func(n ast.Node) bool {
+			switch x := n.(type) {
+			case *ast.SelectorExpr:
+				if x.Sel.Name == "Parallel" {
+					parallel = true
+				}
+			case *ast.BasicLit:
+				if x.Kind == token.STRING && strings.Contains(x.Value, "golden") {
+					golden = true
+				}
+			case *ast.Ident:
+				if strings.Contains(strings.ToLower(x.Name), "golden") {
+					golden = true
+				}
+			case *ast.FuncDecl:
+				if findTestTable(x) != nil {
+					tableDriven = true
+				}
+			}
+			return true
+		})
+
+		if parallel {
+			style.parallel++
+		}
+		if golden {
+			style.golden++
+		}
+		if tableDriven {
+			style.tableDriven++
+		}
+	}
+
+	return style, nil
+}
+
+// isCurrentTestFile checks whether a test file is the one being generated, which does not define the style.
+func (j *job) isCurrentTestFile(path string) bool {
+	return j.currentTestFileName != "" && sameFileName(path, filepath.Join(j.fileDir, j.currentTestFileName))
+}
+
+// testStylePrompt returns the instructions asking the model to follow the style of the existing tests.
+func (j *job) testStylePrompt() string {
+	style, err := j.testStyleProfile()
+	if err != nil || style.files == 0 {
+		if !j.moduleRequires(testifyModule) {
+			return j.t("Only use the standard testing package, testify is not a dependency of the project") + "."
+		}
+		return ""
+	}
+
+	var rules []string
+	switch {
+	case style.majority(style.require):
+		rules = append(rules, j.t("Use github.com/stretchr/testify/require for the assertions, as the existing tests do"))
+	case style.majority(style.testify):
+		rules = append(rules, j.t("Use github.com/stretchr/testify/assert for the assertions, as the existing tests do"))
+	default:
+		rules = append(rules, j.t("Only use the standard testing package for the assertions, without testify, as the existing tests do"))
+	}
+
+	if style.gomock > 0 {
+		rules = append(rules, j.t("Use gomock for the mocks, as the existing tests do"))
+	}
+
+	if style.majority(style.externalPackage) {
+		rules = append(rules, j.t("Write the tests in the external test package <package>_test"))
+	} else {
+		rules = append(rules, j.t("Write the tests in the same package as the code"))
+	}
+
+	if style.majority(style.tableDriven) {
+		rules = append(rules, j.t("Write table-driven tests running each case with t.Run"))
+	}
+	if style.majority(style.parallel) {
+		rules = append(rules, j.t("Call t.Parallel() in the tests and subtests"))
+	}
+	if style.golden > 0 {
+		rules = append(rules, j.t("Compare large outputs with golden files under testdata, as the existing tests do"))
+	}
+
+	return j.t("Follow the style of the existing tests of the project") + ":\n\n- " + strings.Join(rules, ".\n- ") + "."
+}
+
+// validateTestStyle checks the imports of the generated test file and returns a repair prompt
+// when it uses a test library that the project does not use.
+func (j *job) validateTestStyle() (string, error) {
+	node, err := parser.ParseFile(token.NewFileSet(), filepath.Join(j.fileDir, j.currentTestFileName), nil, parser.ImportsOnly)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+
+	style, err := j.testStyleProfile()
+	if err != nil {
+		return "", err
+	}
+
+	var problems []string
+	for _, imp := range node.Imports {
+		path, _ := strconv.Unquote(imp.Path.Value)
+
+		switch {
+		case strings.HasPrefix(path, testifyModule+"/"):
+			if !j.moduleRequires(testifyModule) || (style.files > 0 && style.testify == 0) {
+				problems = append(problems, fmt.Sprintf(j.t("the test imports %s, but the project does not use testify"), path))
+			}
+		case strings.HasPrefix(path, gomockModule+"/"), strings.HasPrefix(path, uberMockModule+"/"):
+			module := gomockModule
+			if strings.HasPrefix(path, uberMockModule+"/") {
+				module = uberMockModule
+			}
+			if !j.moduleRequires(module) {
+				problems = append(problems, fmt.Sprintf(j.t("the test imports %s, but the project does not use gomock"), path))
+			}
+		}
+	}
+
+	if len(problems) == 0 {
+		return "", nil
+	}
+
+	return j.t("The generated tests do not follow the style of the project") + ":\n\n- " + strings.Join(problems, "\n- ") + "\n\n" +
+		j.testStylePrompt() + "\n\n" +
+		j.t("Here is the Golang code") + " :\n\n" + string(j.currentSrcTest) + "\n\n" +
+		j.t("Generates a concise response that specifies the file to modify in the form") +
+		": \"MODIFY: <function or section name> (test file)\"." +
+		j.t("Then provide the corrected code in the form") + ": \"CODE: <corrected code>\".\n\n" +
+		j.t("responds without adding comments or explanations"), nil
+}
+
+// moduleRequires checks whether the go.mod file of the module of the current source file requires the given module.
+func (j *job) moduleRequires(module string) bool {
+	root, err := findModuleRoot(filepath.Join(j.fileDir, filepath.Dir(j.currentSourceFileName)))
+	if err != nil {
+		return false
+	}
+	data, err := os.ReadFile(filepath.Join(root, "go.mod"))
+	if err != nil {
+		return false
+	}
+
+	reg := regexp.MustCompile(`(?m)^\s*(?:require\s+)?` + regexp.QuoteMeta(module) + `(?:/v\d+)?\s+v`)
+	return reg.Match(data)
+}
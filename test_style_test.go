@@ -0,0 +1,160 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTestStyleProfile(t *testing.T) {
+	dir := writeModule(t, map[string]string{
+		"go.mod": "module example.com/m\n\ngo 1.22\n\nrequire github.com/stretchr/testify v1.9.0\n",
+		"a/a_test.go": `package a_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestA(t *testing.T) {
+	t.Parallel()
+	tests := []struct{ name string }{{name: "x"}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) { require.True(t, true) })
+	}
+}
+`,
+		"a/b_test.go": `package a_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestB(t *testing.T) { require.FileExists(t, "testdata/b.golden") }
+`,
+		"a/c_test.go":   "package a\n\nimport \"testing\"\n\nfunc TestC(t *testing.T) {}\n",
+		"a/new_test.go": "package a\n\nimport \"testing\"\n\nfunc TestNew(t *testing.T) {}\n",
+		"b/b.go":        "package b\n",
+	})
+
+	tests := []struct {
+		name   string
+		source string
+		want   testStyle
+	}{
+		{
+			name:   "tests of the folder",
+			source: "a/a.go",
+			want:   testStyle{files: 3, testify: 2, require: 2, externalPackage: 2, parallel: 1, golden: 1, tableDriven: 1},
+		},
+		{
+			name:   "tests of the module",
+			source: "b/b.go",
+			want:   testStyle{files: 3, testify: 2, require: 2, externalPackage: 2, parallel: 1, golden: 1, tableDriven: 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			j := &job{fileDir: dir, currentSourceFileName: tt.source, currentTestFileName: "a/new_test.go"}
+			style, err := j.testStyleProfile()
+			if err != nil {
+				t.Fatalf("testStyleProfile() error = %v", err)
+			}
+			if *style != tt.want {
+				t.Errorf("testStyleProfile() = %+v, want %+v", *style, tt.want)
+			}
+
+			prompt := j.testStylePrompt()
+			for _, rule := range []string{"testify/require", "external test package", "golden files"} {
+				if !strings.Contains(prompt, rule) {
+					t.Errorf("testStylePrompt() has no %q rule:\n%s", rule, prompt)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateTestStyle(t *testing.T) {
+	tests := []struct {
+		name       string
+		gomod      string
+		test       string
+		wantPrompt bool
+	}{
+		{
+			name:  "standard library",
+			gomod: "module example.com/m\n",
+			test:  "package m\n\nimport \"testing\"\n",
+		},
+		{
+			name:       "testify without dependency",
+			gomod:      "module example.com/m\n",
+			test:       "package m\n\nimport \"github.com/stretchr/testify/assert\"\n",
+			wantPrompt: true,
+		},
+		{
+			name:  "testify required",
+			gomod: "module example.com/m\n\nrequire (\n\tgithub.com/stretchr/testify v1.9.0\n)\n",
+			test:  "package m\n\nimport \"github.com/stretchr/testify/assert\"\n",
+		},
+		{
+			name:       "gomock without dependency",
+			gomod:      "module example.com/m\n\nrequire github.com/golang/mock v1.6.0\n",
+			test:       "package m\n\nimport \"go.uber.org/mock/gomock\"\n",
+			wantPrompt: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := writeModule(t, map[string]string{"go.mod": tt.gomod, "m_test.go": tt.test})
+			j := &job{fileDir: dir, currentSourceFileName: "m.go", currentTestFileName: "m_test.go"}
+
+			prompt, err := j.validateTestStyle()
+			if err != nil {
+				t.Fatalf("validateTestStyle() error = %v", err)
+			}
+			if (prompt != "") != tt.wantPrompt {
+				t.Errorf("validateTestStyle() = %q, want a prompt %v", prompt, tt.wantPrompt)
+			}
+		})
+	}
+}
+
+func TestModuleRequires(t *testing.T) {
+	dir := writeModule(t, map[string]string{
+		"go.mod":       "module example.com/m\n\nrequire github.com/stretchr/testify v1.9.0\n",
+		"a/a.go":       "package a\n",
+		"sub/go.mod":   "module example.com/sub\n",
+		"sub/b/b.go":   "package b\n",
+		"other/c/c.go": "package c\n",
+		"other/go.mod": "module example.com/other\n\nrequire (\n\tgo.uber.org/mock v0.4.0\n)\n",
+	})
+
+	tests := []struct {
+		name       string
+		fileDir    string
+		sourceFile string
+		module     string
+		want       bool
+	}{
+		{"module root", dir, "a/a.go", testifyModule, true},
+		{"folder under the module root", filepath.Join(dir, "a"), "a.go", testifyModule, true},
+		{"nested module", dir, "sub/b/b.go", testifyModule, false},
+		{"nested module requiring it", dir, "other/c/c.go", uberMockModule, true},
+		{"module not required", dir, "a/a.go", uberMockModule, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			j := &job{fileDir: tt.fileDir, currentSourceFileName: tt.sourceFile}
+			if got := j.moduleRequires(tt.module); got != tt.want {
+				t.Errorf("moduleRequires(%q) = %v, want %v", tt.module, got, tt.want)
+			}
+		})
+	}
+}
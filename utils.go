@@ -69,6 +69,11 @@ func removeDuplicates(strings []string) []string {
 	return result
 }
 
+// sameFileName reports whether two file names relative to the job folder designate the same file.
+func sameFileName(a, b string) bool {
+	return strings.TrimPrefix(filepath.Clean(a), "/") == strings.TrimPrefix(filepath.Clean(b), "/")
+}
+
 // sanitizePackageName cleans up the package name so that it is valid.
 func sanitizePackageName(dirName string) string {
 	partsDirName := strings.Split(dirName, "/")